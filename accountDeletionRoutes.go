@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+func renderDeleteAccount(context echo.Context, status int, pongoContext pongo2.Context) error {
+	sessionUser, _ := getUser(context)
+	user, err := yana.GetUserFromUserID(sessionUser.UserId)
+	if err != nil {
+		fmt.Println("Error in renderDeleteAccount:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't load your account.")
+	}
+	usage, err := yana.GetStorageUsage(user.UserId)
+	if err != nil {
+		fmt.Println("Error in renderDeleteAccount:", err)
+	} else {
+		pongoContext["usage"] = usage
+	}
+	pongoContext["user"] = user
+	return context.Render(status, "static/delete-account.html", pongoContext)
+}
+
+// ------------ GET ------------
+
+func getDeleteAccount(context echo.Context) error {
+	return renderDeleteAccount(context, 200, pongo2.Context{})
+}
+
+// The receipt can be opened again later to see whether the notes are gone from MinIO too
+func getAccountDeleted(context echo.Context) error {
+	receipt, err := yana.GetAccountDeletionReceipt(context.QueryParam("receipt"))
+	if err == yana.ErrAccountDeletionNotFound {
+		return renderError(context, http.StatusNotFound, "There is no account deletion with this receipt number.")
+	} else if err != nil {
+		fmt.Println("Error in /account-deleted:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't load the receipt, please try again later.")
+	}
+	return context.Render(200, "static/account-deleted.html", pongo2.Context{"receipt": receipt})
+}
+
+// ------------ POST ------------
+
+func postDeleteAccount(context echo.Context) error {
+	user, _ := getUser(context)
+	if context.FormValue("confirm") != "on" {
+		return renderDeleteAccount(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "Please confirm that everything should be deleted."})
+	}
+	receipt, err := yana.DeleteAccount(user.UserId, context.FormValue("confirmation"))
+	if yana.GetYanaErrorCode(err) == yana.PasswordsNotEqual {
+		return renderDeleteAccount(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "The confirmation was wrong, your account wasn't deleted."})
+	} else if err != nil {
+		fmt.Println("Error in POST /delete-account:", err)
+		return renderDeleteAccount(context, http.StatusInternalServerError, pongo2.Context{"errorMessage": "Your account couldn't be deleted, please try again later."})
+	}
+	// Without the address and user agent, they have just been removed from the user's other events
+	yana.RecordAuditEvent(yana.AuditEvent{Event: yana.AUDIT_ACCOUNT_DELETED, ActorId: user.UserId, UserId: user.UserId, Details: receipt.Id})
+	// The session is gone already, the cookies only have to be removed from the browser
+	setSessionCookie(context, "")
+	setRememberCookie(context, "")
+	return context.Redirect(http.StatusSeeOther, "/account-deleted?receipt="+receipt.Id)
+}
+
+func initAccountDeletionRoutes(e *echo.Echo) {
+	e.GET("/delete-account", getDeleteAccount, requireLogin)
+	e.GET("/account-deleted", getAccountDeleted)
+
+	e.POST("/delete-account", postDeleteAccount, requireLogin)
+}
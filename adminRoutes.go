@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// Has to come after requireLogin
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(context echo.Context) error {
+		user, _ := getUser(context)
+		if !user.IsAdmin() {
+			return renderError(context, http.StatusForbidden, "Only administrators can open this page.")
+		}
+		return next(context)
+	}
+}
+
+func renderAdmin(context echo.Context, status int, pongoContext pongo2.Context) error {
+	users, err := yana.GetUsersOverview()
+	if err != nil {
+		fmt.Println("Error in /admin:", err)
+		pongoContext["errorMessage"] = "The users couldn't be loaded."
+	}
+	currentUser, _ := getUser(context)
+	pongoContext["users"] = users
+	pongoContext["currentUserId"] = currentUser.UserId
+	pongoContext["status"] = yana.GetSystemStatus()
+	pongoContext["roles"] = yana.ROLES
+	return context.Render(status, "static/admin.html", pongoContext)
+}
+
+// For actions of the logged in admin on the account userid
+func recordAdminAudit(context echo.Context, eventName string, userid string, details string) {
+	admin, _ := getUser(context)
+	recordAudit(context, yana.AuditEvent{Event: eventName, ActorId: admin.UserId, UserId: userid, Details: details})
+}
+
+// Admins can't lock themselves out, another admin has to do that
+func isOwnAccount(context echo.Context, userid string) bool {
+	user, _ := getUser(context)
+	return user.UserId == userid
+}
+
+// ------------ GET ------------
+
+func getAdmin(context echo.Context) error {
+	return renderAdmin(context, 200, pongo2.Context{})
+}
+
+// ------------ POST ------------
+
+func postAdminSetDisabled(context echo.Context) error {
+	userid := context.FormValue("userId")
+	isDisabled := context.FormValue("disabled") == "true"
+	if isOwnAccount(context, userid) {
+		return renderAdmin(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "You can't disable your own account."})
+	}
+	err := yana.SetUserDisabled(userid, isDisabled)
+	if err != nil {
+		fmt.Println("Error in POST /admin/set-disabled:", err)
+		return renderAdmin(context, http.StatusInternalServerError, pongo2.Context{"errorMessage": "The account couldn't be changed."})
+	}
+	if isDisabled {
+		recordAdminAudit(context, yana.AUDIT_ADMIN_USER_DISABLED, userid, "")
+		return renderAdmin(context, 200, pongo2.Context{"successMessage": "The account has been disabled and logged out everywhere."})
+	}
+	recordAdminAudit(context, yana.AUDIT_ADMIN_USER_ENABLED, userid, "")
+	return renderAdmin(context, 200, pongo2.Context{"successMessage": "The account has been enabled."})
+}
+
+func postAdminSetRole(context echo.Context) error {
+	userid := context.FormValue("userId")
+	if isOwnAccount(context, userid) {
+		return renderAdmin(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "You can't change your own role."})
+	}
+	err := yana.SetUserRole(userid, context.FormValue("role"))
+	if err != nil {
+		fmt.Println("Error in POST /admin/set-role:", err)
+		return renderAdmin(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "The role couldn't be changed."})
+	}
+	recordAdminAudit(context, yana.AUDIT_ADMIN_ROLE_CHANGED, userid, context.FormValue("role"))
+	return renderAdmin(context, 200, pongo2.Context{"successMessage": "The role has been changed."})
+}
+
+func postAdminForcePasswordReset(context echo.Context) error {
+	userid := context.FormValue("userId")
+	err := yana.ForcePasswordReset(userid)
+	if err != nil {
+		fmt.Println("Error in POST /admin/force-password-reset:", err)
+		return renderAdmin(context, http.StatusInternalServerError, pongo2.Context{"errorMessage": "The password reset couldn't be started."})
+	}
+	recordAdminAudit(context, yana.AUDIT_ADMIN_PASSWORD_FORCED, userid, "")
+	return renderAdmin(context, 200, pongo2.Context{"successMessage": "The user has been logged out everywhere and was mailed a link to choose a new password."})
+}
+
+func postAdminDeleteUser(context echo.Context) error {
+	userid := context.FormValue("userId")
+	if isOwnAccount(context, userid) {
+		return renderAdmin(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "Use the settings page to delete your own account."})
+	}
+	receipt, err := yana.AdminDeleteUser(userid)
+	if err != nil {
+		fmt.Println("Error in POST /admin/delete-user:", err)
+		return renderAdmin(context, http.StatusInternalServerError, pongo2.Context{"errorMessage": "The account couldn't be deleted."})
+	}
+	recordAdminAudit(context, yana.AUDIT_ADMIN_USER_DELETED, userid, receipt.Id)
+	if !receipt.IsFinished() {
+		return renderAdmin(context, 200, pongo2.Context{"successMessage": "The account has been deleted, its notes will be removed from MinIO once it works again. Receipt: " + receipt.Id})
+	}
+	return renderAdmin(context, 200, pongo2.Context{"successMessage": "The account and its notes have been deleted. Receipt: " + receipt.Id})
+}
+
+func initAdminRoutes(e *echo.Echo) {
+	e.GET("/admin", getAdmin, requireLogin, requireAdmin)
+
+	e.POST("/admin/set-disabled", postAdminSetDisabled, requireLogin, requireAdmin)
+	e.POST("/admin/set-role", postAdminSetRole, requireLogin, requireAdmin)
+	e.POST("/admin/force-password-reset", postAdminForcePasswordReset, requireLogin, requireAdmin)
+	e.POST("/admin/delete-user", postAdminDeleteUser, requireLogin, requireAdmin)
+}
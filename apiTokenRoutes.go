@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// The key under which apiTokenMiddleware stores the yana.APIToken of the request
+const API_TOKEN_CONTEXT_KEY = "apiToken"
+
+// Every route an API token can be used for and the scope it needs.
+// Everything else (including managing the tokens themselves) only works in the browser.
+var API_ROUTE_SCOPES = map[string]string{
+	"GET /":               yana.SCOPE_NOTES_READ,
+	"GET /index":          yana.SCOPE_NOTES_READ,
+	"GET /edit-note":      yana.SCOPE_NOTES_READ,
+	"POST /create-note":   yana.SCOPE_NOTES_WRITE,
+	"POST /edit-note":     yana.SCOPE_NOTES_WRITE,
+	"DELETE /delete-note": yana.SCOPE_NOTES_WRITE,
+	"GET /settings":       yana.SCOPE_ACCOUNT,
+	"GET /devices":        yana.SCOPE_ACCOUNT,
+	"POST /revoke-device": yana.SCOPE_ACCOUNT,
+}
+
+// How long a new token can be valid, in days. 0 means forever
+var API_TOKEN_LIFETIMES = []int{30, 90, 365, 0}
+
+func isAPIRequest(context echo.Context) bool {
+	_, isOk := context.Get(API_TOKEN_CONTEXT_KEY).(yana.APIToken)
+	return isOk
+}
+
+// Has to run before sessionMiddleware.
+// Requests with "Authorization: Bearer <token>" are logged in with the API token instead of cookies,
+// but only for the routes in API_ROUTE_SCOPES and only if the token has the scope.
+func apiTokenMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(context echo.Context) error {
+		token, isBearer := strings.CutPrefix(context.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !isBearer {
+			return next(context)
+		}
+		user, apiToken, err := yana.AuthenticateAPIToken(strings.TrimSpace(token))
+		if err == yana.ErrAPITokenNotFound {
+			return jsonError(context, http.StatusUnauthorized, "The API token is invalid or has expired.")
+		} else if err != nil {
+			fmt.Println("Error in apiTokenMiddleware:", err)
+			return jsonError(context, http.StatusInternalServerError, "Couldn't check the API token.")
+		}
+		scope, isAPIRoute := API_ROUTE_SCOPES[context.Request().Method+" "+context.Path()]
+		if !isAPIRoute {
+			return jsonError(context, http.StatusForbidden, "API tokens can't be used for this route.")
+		}
+		if !apiToken.HasScope(scope) {
+			return jsonError(context, http.StatusForbidden, fmt.Sprintf("The API token needs the %q scope for this route.", scope))
+		}
+		context.Set(USER_CONTEXT_KEY, user)
+		context.Set(API_TOKEN_CONTEXT_KEY, apiToken)
+		return next(context)
+	}
+}
+
+// ------------ GET ------------
+
+func getAPITokens(context echo.Context) error {
+	return renderAPITokens(context, 200, pongo2.Context{})
+}
+
+func renderAPITokens(context echo.Context, status int, pongoContext pongo2.Context) error {
+	user, _ := getUser(context)
+	apiTokens, err := yana.GetAPITokens(user.UserId)
+	if err != nil {
+		fmt.Println("Error in /api-tokens:", err)
+		pongoContext["errorMessage"] = "Your API tokens couldn't be loaded."
+	}
+	pongoContext["apiTokens"] = apiTokens
+	pongoContext["noAPITokens"] = len(apiTokens) == 0
+	pongoContext["scopes"] = yana.API_TOKEN_SCOPES
+	pongoContext["lifetimes"] = API_TOKEN_LIFETIMES
+	return context.Render(status, "static/api-tokens.html", pongoContext)
+}
+
+// ------------ POST ------------
+
+func postCreateAPIToken(context echo.Context) error {
+	user, _ := getUser(context)
+	form, err := context.FormParams()
+	if err != nil {
+		return renderAPITokens(context, http.StatusBadRequest, pongo2.Context{"errorMessage": "The form couldn't be read."})
+	}
+	lifetimeDays, err := strconv.Atoi(context.FormValue("lifetimeDays"))
+	if err != nil || lifetimeDays < 0 {
+		return renderAPITokens(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "Please choose when the token should expire."})
+	}
+	lifetime := time.Duration(lifetimeDays) * 24 * time.Hour
+	token, err := yana.CreateAPIToken(user.UserId, context.FormValue("name"), form["scopes"], lifetime)
+	if err != nil {
+		fmt.Println("Error in POST /create-api-token:", err)
+		return renderAPITokens(context, http.StatusUnprocessableEntity, pongo2.Context{
+			"errorMessage": "The token couldn't be created. It needs a name and at least one scope.",
+		})
+	}
+	recordOwnAudit(context, yana.AUDIT_API_TOKEN_CREATED, context.FormValue("name")+" ("+strings.Join(form["scopes"], " ")+")")
+	// The token isn't stored anywhere, so this is the only time the user can see it
+	return renderAPITokens(context, 200, pongo2.Context{"newToken": token})
+}
+
+func postRevokeAPIToken(context echo.Context) error {
+	user, _ := getUser(context)
+	err := yana.RevokeAPIToken(user.UserId, context.FormValue("tokenId"))
+	if err != nil {
+		fmt.Println("Error in POST /revoke-api-token:", err)
+	} else {
+		recordOwnAudit(context, yana.AUDIT_API_TOKEN_REVOKED, "")
+	}
+	return context.Redirect(http.StatusSeeOther, "/api-tokens")
+}
+
+func initAPITokenRoutes(e *echo.Echo) {
+	e.GET("/api-tokens", getAPITokens, requireLogin)
+
+	e.POST("/create-api-token", postCreateAPIToken, requireLogin)
+	e.POST("/revoke-api-token", postRevokeAPIToken, requireLogin)
+}
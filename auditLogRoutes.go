@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// How many events /admin/audit-log shows, the export has all of them
+const AUDIT_LOG_PAGE_SIZE = 200
+
+// The format of <input type="date">
+const AUDIT_DATE_FORMAT = "2006-01-02"
+
+// Fills in where the request came from and records the event
+func recordAudit(context echo.Context, event yana.AuditEvent) {
+	event.IPAddress = context.RealIP()
+	event.UserAgent = context.Request().UserAgent()
+	yana.RecordAuditEvent(event)
+}
+
+// For events of the logged in user on their own account
+func recordOwnAudit(context echo.Context, eventName string, details string) {
+	user, _ := getUser(context)
+	recordAudit(context, yana.AuditEvent{Event: eventName, ActorId: user.UserId, UserId: user.UserId, Details: details})
+}
+
+// Failed logins are recorded for the account they were meant for if it exists
+func recordLoginFailure(context echo.Context, login string, yanaErr yana.YanaError) {
+	reason := "error"
+	switch yanaErr.Code {
+	case yana.UserNotFound:
+		reason = "unknown account"
+	case yana.PasswordsNotEqual:
+		reason = "wrong password"
+	case yana.TooManyAttempts:
+		reason = "locked"
+	case yana.AccountDisabled:
+		reason = "account disabled"
+	case yana.PasswordResetRequired:
+		reason = "password reset required"
+	case yana.AuthenticatorFailed:
+		reason = "authenticator failed"
+	}
+	userid := ""
+	if yanaErr.Code != yana.UserNotFound {
+		var err error
+		userid, err = yana.GetUserIDFromEmail(login)
+		if err != nil {
+			fmt.Println("Error in recordLoginFailure:", err)
+		}
+	}
+	recordAudit(context, yana.AuditEvent{Event: yana.AUDIT_LOGIN_FAILED, UserId: userid, Details: reason})
+}
+
+// Reads the filter form of /admin/audit-log. "until" includes the whole day
+func auditFilterFromQuery(context echo.Context) (yana.AuditFilter, error) {
+	filter := yana.AuditFilter{
+		Event:     context.QueryParam("event"),
+		UserId:    context.QueryParam("userId"),
+		IPAddress: context.QueryParam("ip"),
+	}
+	if since := context.QueryParam("since"); since != "" {
+		sinceDate, err := time.Parse(AUDIT_DATE_FORMAT, since)
+		if err != nil {
+			return yana.AuditFilter{}, err
+		}
+		filter.Since = sinceDate
+	}
+	if until := context.QueryParam("until"); until != "" {
+		untilDate, err := time.Parse(AUDIT_DATE_FORMAT, until)
+		if err != nil {
+			return yana.AuditFilter{}, err
+		}
+		filter.Until = untilDate.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+// ------------ GET ------------
+
+func getAdminAuditLog(context echo.Context) error {
+	query := context.QueryParams()
+	pongoContext := pongo2.Context{
+		"events": yana.AUDIT_EVENTS,
+		// To fill the filter form in again
+		"filter": map[string]string{
+			"event":  query.Get("event"),
+			"userId": query.Get("userId"),
+			"ip":     query.Get("ip"),
+			"since":  query.Get("since"),
+			"until":  query.Get("until"),
+		},
+		"exportQuery": query.Encode(),
+	}
+	filter, err := auditFilterFromQuery(context)
+	if err != nil {
+		pongoContext["errorMessage"] = "The dates have to look like 2006-01-02."
+		return context.Render(http.StatusUnprocessableEntity, "static/audit-log.html", pongoContext)
+	}
+	filter.Limit = AUDIT_LOG_PAGE_SIZE
+	auditEvents, err := yana.GetAuditEvents(filter)
+	if err != nil {
+		fmt.Println("Error in /admin/audit-log:", err)
+		pongoContext["errorMessage"] = "The audit log couldn't be loaded."
+	}
+	pongoContext["auditEvents"] = auditEvents
+	pongoContext["pageSize"] = AUDIT_LOG_PAGE_SIZE
+	return context.Render(200, "static/audit-log.html", pongoContext)
+}
+
+// Every event that matches the filter as JSON lines (one JSON object per line)
+func getAdminAuditLogExport(context echo.Context) error {
+	filter, err := auditFilterFromQuery(context)
+	if err != nil {
+		return context.String(http.StatusUnprocessableEntity, "The dates have to look like 2006-01-02.")
+	}
+	fileName := "yana-audit-log-" + time.Now().UTC().Format(AUDIT_DATE_FORMAT) + ".jsonl"
+	response := context.Response()
+	response.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	response.Header().Set(echo.HeaderContentDisposition, "attachment; filename="+url.PathEscape(fileName))
+	response.WriteHeader(200)
+	err = yana.ExportAuditEvents(filter, response)
+	if err != nil {
+		// The status was sent already, so the download just ends early
+		fmt.Println("Error in /admin/audit-log.jsonl:", err)
+	}
+	return nil
+}
+
+func initAuditLogRoutes(e *echo.Echo) {
+	e.GET("/admin/audit-log", getAdminAuditLog, requireLogin, requireAdmin)
+	e.GET("/admin/audit-log.jsonl", getAdminAuditLogExport, requireLogin, requireAdmin)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"yana.go/yana"
+)
+
+// Commands that can be run instead of the server, e.g. "go run . create-admin -email admin@example.com"
+// Returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "create-admin":
+		return runCreateAdmin(args[1:])
+	case "migrate":
+		return runMigrate(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q. Available commands: create-admin, migrate\n", args[0])
+	return 2
+}
+
+// Makes an account an admin, creating it first if there is none with the email yet
+func runCreateAdmin(args []string) int {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the admin account")
+	name := flags.String("name", "Admin", "full name if the account has to be created")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *email == "" {
+		fmt.Fprintln(os.Stderr, "create-admin needs -email")
+		flags.Usage()
+		return 2
+	}
+	link, err := yana.BootstrapAdmin(*email, *name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't create admin:", err)
+		return 1
+	}
+	if link == "" {
+		fmt.Printf("%s is an admin now and can log in as usual.\n", *email)
+		return 0
+	}
+	fmt.Printf("Created the admin account %s. Choose its password here within %d minutes:\n%s\n",
+		*email, int(yana.PASSWORD_RESET_LIFETIME.Minutes()), link)
+	return 0
+}
+
+// Applies the missing migrations ("migrate" or "migrate up"), reverts the last ones ("migrate down -steps 1")
+// or lists which ones are applied ("migrate status")
+func runMigrate(args []string) int {
+	direction := "up"
+	if len(args) > 0 {
+		direction = args[0]
+		args = args[1:]
+	}
+	switch direction {
+	case "up":
+		migrations, err := yana.MigrateUp()
+		printMigrations("Applied", migrations)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Couldn't migrate:", err)
+			return 1
+		}
+		if len(migrations) == 0 {
+			fmt.Println("The database is up to date.")
+		}
+		return 0
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := flags.Int("steps", 1, "how many migrations to revert")
+		isForced := flags.Bool("force", false, "also revert the first migration, which drops every table")
+		err := flags.Parse(args)
+		if err != nil {
+			return 2
+		}
+		migrations, err := yana.MigrateDown(*steps, *isForced)
+		printMigrations("Reverted", migrations)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Couldn't migrate:", err)
+			return 1
+		}
+		return 0
+	case "status":
+		statuses, err := yana.GetMigrationStatus()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Couldn't get migrations:", err)
+			return 1
+		}
+		for _, status := range statuses {
+			if status.IsApplied {
+				fmt.Printf("%04d_%s applied at %s\n", status.Version, status.Name, status.AppliedAtUTC.Time.Format("2006-01-02 15:04:05 UTC"))
+			} else {
+				fmt.Printf("%04d_%s pending\n", status.Version, status.Name)
+			}
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Unknown migrate command %q. Available: up, down, status\n", direction)
+	return 2
+}
+
+func printMigrations(verb string, migrations []yana.Migration) {
+	for _, migration := range migrations {
+		fmt.Printf("%s migration %04d_%s\n", verb, migration.Version, migration.Name)
+	}
+}
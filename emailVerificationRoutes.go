@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// For routes that accounts with an unverified email may or may not use, depending on config/server.yml.
+// Has to come after requireLogin.
+func restrictUnverified(isAllowed func(config yana.ServerConfig) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			user, _ := getUser(context)
+			if !user.IsEmailVerified && !isAllowed(yana.GetServerConfig()) {
+				return renderError(context, http.StatusForbidden,
+					"Please verify your email address first. You can ask for a new link on your notes page.")
+			}
+			return next(context)
+		}
+	}
+}
+
+func canUnverifiedCreateNotes(config yana.ServerConfig) bool {
+	return config.UnverifiedCanCreateNotes
+}
+
+func canUnverifiedEditNotes(config yana.ServerConfig) bool {
+	return config.UnverifiedCanEditNotes
+}
+
+// ------------ GET ------------
+
+func getVerifyEmail(context echo.Context) error {
+	err := yana.VerifyEmail(context.QueryParam("token"))
+	pongoContext := pongo2.Context{"isLoggedIn": isLoggedIn(context)}
+	if err == yana.ErrEmailVerificationNotFound {
+		pongoContext["errorMessage"] = "This link has expired or was already used. Log in to ask for a new one."
+		return context.Render(http.StatusNotFound, "static/verify-email.html", pongoContext)
+	} else if err != nil {
+		fmt.Println("Error in /verify-email:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't verify your email address, please try again.")
+	}
+	pongoContext["isVerified"] = true
+	return context.Render(200, "static/verify-email.html", pongoContext)
+}
+
+// ------------ POST ------------
+
+func postResendVerification(context echo.Context) error {
+	user, _ := getUser(context)
+	err := yana.SendEmailVerification(user.UserId)
+	pongoContext := pongo2.Context{"isLoggedIn": true}
+	if errors.Is(err, yana.ErrVerificationMailTooSoon) {
+		pongoContext["errorMessage"] = "A verification mail was sent a moment ago. Please check your inbox or try again in a few minutes."
+		return context.Render(http.StatusTooManyRequests, "static/verify-email.html", pongoContext)
+	} else if err != nil {
+		fmt.Println("Error in POST /resend-verification:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't send the verification mail, please try again.")
+	}
+	pongoContext["isMailSent"] = true
+	return context.Render(200, "static/verify-email.html", pongoContext)
+}
+
+func initEmailVerificationRoutes(e *echo.Echo) {
+	e.GET("/verify-email", getVerifyEmail)
+
+	e.POST("/resend-verification", postResendVerification, requireLogin)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// Has to run after requireLogin
+func requireInviting(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(context echo.Context) error {
+		user, _ := getUser(context)
+		if !yana.CanInvite(user) {
+			return renderError(context, http.StatusForbidden, "You can't invite anyone on this server.")
+		}
+		return next(context)
+	}
+}
+
+// ------------ GET ------------
+
+func getInvitations(context echo.Context) error {
+	return renderInvitations(context, 200, pongo2.Context{})
+}
+
+func renderInvitations(context echo.Context, status int, pongoContext pongo2.Context) error {
+	user, _ := getUser(context)
+	invitations, err := yana.GetInvitations(user.UserId)
+	if err != nil {
+		fmt.Println("Error in /invitations:", err)
+		pongoContext["errorMessage"] = "Your invitations couldn't be loaded."
+	}
+	pongoContext["invitations"] = invitations
+	pongoContext["noInvitations"] = len(invitations) == 0
+	pongoContext["maxUses"] = yana.INVITATION_MAX_USES
+	pongoContext["lifetimes"] = yana.INVITATION_LIFETIME_DAYS
+	return context.Render(status, "static/invitations.html", pongoContext)
+}
+
+// ------------ POST ------------
+
+func postCreateInvitation(context echo.Context) error {
+	user, _ := getUser(context)
+	maxUses, maxUsesErr := strconv.Atoi(context.FormValue("maxUses"))
+	lifetimeDays, lifetimeErr := strconv.Atoi(context.FormValue("lifetimeDays"))
+	if maxUsesErr != nil || lifetimeErr != nil {
+		return renderInvitations(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "Please choose how often and how long the invitation can be used."})
+	}
+	code, err := yana.CreateInvitation(user.UserId, maxUses, lifetimeDays)
+	if err != nil {
+		fmt.Println("Error in POST /create-invitation:", err)
+		return renderInvitations(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "The invitation couldn't be created."})
+	}
+	recordOwnAudit(context, yana.AUDIT_INVITATION_CREATED, fmt.Sprintf("%d uses, %d days", maxUses, lifetimeDays))
+	// Like API tokens, the code isn't stored anywhere, so this is the only time the user can see it
+	return renderInvitations(context, 200, pongo2.Context{"newCode": code, "newLink": yana.BuildInvitationLink(code)})
+}
+
+func postRevokeInvitation(context echo.Context) error {
+	user, _ := getUser(context)
+	err := yana.RevokeInvitation(user.UserId, context.FormValue("invitationId"))
+	if err != nil {
+		fmt.Println("Error in POST /revoke-invitation:", err)
+	} else {
+		recordOwnAudit(context, yana.AUDIT_INVITATION_REVOKED, "")
+	}
+	return context.Redirect(http.StatusSeeOther, "/invitations")
+}
+
+func initInvitationRoutes(e *echo.Echo) {
+	e.GET("/invitations", getInvitations, requireLogin, requireInviting)
+
+	e.POST("/create-invitation", postCreateInvitation, requireLogin, requireInviting)
+	e.POST("/revoke-invitation", postRevokeInvitation, requireLogin, requireInviting)
+}
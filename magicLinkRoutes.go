@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// Binds a login link to the browser that asked for it, see yana/magicLinks.go
+const MAGIC_LINK_COOKIE_NAME = "magic_link"
+
+// ------------ GET ------------
+
+func getMagicLink(context echo.Context) error {
+	if !yana.GetServerConfig().MagicLinks {
+		return context.Redirect(http.StatusSeeOther, "/login")
+	}
+	cookie, err := context.Cookie(MAGIC_LINK_COOKIE_NAME)
+	browserToken := ""
+	if err == nil {
+		browserToken = cookie.Value
+	}
+	userid, isRemembered, err := yana.UseMagicLink(context.QueryParam("token"), browserToken)
+	if err == yana.ErrMagicLinkNotFound {
+		return renderLogin(context, http.StatusUnauthorized, pongo2.Context{
+			"errorMessage": "This login link has expired, was already used or was opened in a different browser than the one you asked for it in.",
+		})
+	} else if err != nil {
+		fmt.Println("Error in /magic-link:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't log you in, please try again.")
+	}
+	setAuthCookie(context, MAGIC_LINK_COOKIE_NAME, "", 0)
+	redirectTo, err := continueLogin(context, userid, isRemembered, "login link")
+	if err != nil {
+		fmt.Println("Error in /magic-link:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't log you in, please try again.")
+	}
+	return context.Redirect(http.StatusSeeOther, redirectTo)
+}
+
+// ------------ POST ------------
+
+func postMagicLink(context echo.Context) error {
+	if !yana.GetServerConfig().MagicLinks {
+		return context.Redirect(http.StatusSeeOther, "/login")
+	}
+	isRemembered := context.FormValue("remember") == "on"
+	browserToken, err := yana.RequestMagicLink(context.FormValue("email"), isRemembered, context.RealIP())
+	if yana.GetYanaErrorCode(err) == yana.TooManyAttempts {
+		return renderLogin(context, http.StatusTooManyRequests, pongo2.Context{"errorMessage": tooManyAttemptsMessage(err)})
+	} else if err != nil {
+		fmt.Println("Error in POST /magic-link:", err)
+		return renderLogin(context, http.StatusInternalServerError, pongo2.Context{"errorMessage": "Couldn't send the login link, please try again."})
+	}
+	setAuthCookie(context, MAGIC_LINK_COOKIE_NAME, browserToken, int(yana.MAGIC_LINK_LIFETIME.Seconds()))
+	return renderLogin(context, 200, pongo2.Context{
+		"successMessage": "If there is an account with this email, a login link is on the way. Open it in this browser.",
+	})
+}
+
+func initMagicLinkRoutes(e *echo.Echo) {
+	e.GET("/magic-link", getMagicLink)
+
+	e.POST("/magic-link", postMagicLink)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// Holds the state of the single sign-on login so that only the browser that started it can finish it
+const OIDC_STATE_COOKIE_NAME = "oidc_state"
+
+// Whether "Remember me" was checked when the single sign-on login was started
+const OIDC_REMEMBER_COOKIE_NAME = "oidc_remember"
+
+// ------------ GET ------------
+
+func getOIDCLogin(context echo.Context) error {
+	provider, err := yana.GetOIDCProvider()
+	if err == yana.ErrOIDCDisabled {
+		return context.Redirect(http.StatusSeeOther, "/login")
+	} else if err != nil {
+		fmt.Println("Error in /oidc-login:", err)
+		return renderError(context, http.StatusBadGateway, "The single sign-on provider can't be reached right now, please try again later.")
+	}
+	authURL, state, err := provider.BeginLogin()
+	if err != nil {
+		fmt.Println("Error in /oidc-login:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't start the single sign-on login, please try again.")
+	}
+	maxAge := int(yana.OIDC_LOGIN_LIFETIME.Seconds())
+	setAuthCookie(context, OIDC_STATE_COOKIE_NAME, state, maxAge)
+	if context.QueryParam("remember") == "on" {
+		setAuthCookie(context, OIDC_REMEMBER_COOKIE_NAME, "on", maxAge)
+	}
+	return context.Redirect(http.StatusSeeOther, authURL)
+}
+
+// The identity provider sends the user back here with either a code or an error
+func getOIDCCallback(context echo.Context) error {
+	cookie, err := context.Cookie(OIDC_STATE_COOKIE_NAME)
+	state := context.QueryParam("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return renderError(context, http.StatusBadRequest, "This single sign-on login wasn't started in this browser or has expired. Please try again.")
+	}
+	setAuthCookie(context, OIDC_STATE_COOKIE_NAME, "", 0)
+	isRemembered := false
+	rememberCookie, err := context.Cookie(OIDC_REMEMBER_COOKIE_NAME)
+	if err == nil {
+		isRemembered = rememberCookie.Value == "on"
+		setAuthCookie(context, OIDC_REMEMBER_COOKIE_NAME, "", 0)
+	}
+	if providerError := context.QueryParam("error"); providerError != "" {
+		fmt.Println("Error in /oidc-callback from the provider:", providerError, context.QueryParam("error_description"))
+		return renderError(context, http.StatusUnauthorized, "The single sign-on provider didn't log you in.")
+	}
+
+	provider, err := yana.GetOIDCProvider()
+	if err != nil {
+		fmt.Println("Error in /oidc-callback:", err)
+		return renderError(context, http.StatusBadGateway, "The single sign-on provider can't be reached right now, please try again later.")
+	}
+	identity, err := provider.FinishLogin(context.Request().Context(), state, context.QueryParam("code"))
+	if err != nil {
+		fmt.Println("Error in /oidc-callback:", err)
+		return renderError(context, http.StatusUnauthorized, "Your single sign-on login couldn't be checked. Please try again.")
+	}
+	userid, err := yana.LinkOIDCIdentity(identity, provider.Config.AllowSignup)
+	switch err {
+	case nil:
+	case yana.ErrOIDCEmailNotVerified:
+		return renderError(context, http.StatusForbidden, "Your single sign-on account has no verified email address, so it can't be used here.")
+	case yana.ErrOIDCSignupDisabled, yana.ErrExternalSignupClosed:
+		return renderError(context, http.StatusForbidden, "There is no YANAgo account for your single sign-on account.")
+	default:
+		fmt.Println("Error in /oidc-callback:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't log you in, please try again.")
+	}
+	redirectTo, err := continueLogin(context, userid, isRemembered, "single sign-on")
+	if err != nil {
+		fmt.Println("Error in /oidc-callback:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't log you in, please try again.")
+	}
+	return context.Redirect(http.StatusSeeOther, redirectTo)
+}
+
+func initOIDCRoutes(e *echo.Echo) {
+	e.GET("/oidc-login", getOIDCLogin)
+	e.GET("/oidc-callback", getOIDCCallback)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// Holds the token of the passkey ceremony between the begin and the finish request
+const PASSKEY_CEREMONY_COOKIE_NAME = "passkey_ceremony"
+
+// The passkey routes are called with fetch() from static/passkeys.js, so they answer with JSON
+
+func jsonError(context echo.Context, status int, message string) error {
+	return context.JSON(status, map[string]string{"error": message})
+}
+
+func takeCeremonyToken(context echo.Context) (string, bool) {
+	cookie, err := context.Cookie(PASSKEY_CEREMONY_COOKIE_NAME)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	setAuthCookie(context, PASSKEY_CEREMONY_COOKIE_NAME, "", 0)
+	return cookie.Value, true
+}
+
+// ------------ GET ------------
+
+func getPasskeys(context echo.Context) error {
+	user, _ := getUser(context)
+	passkeys, err := yana.GetPasskeys(user.UserId)
+	pongoContext := pongo2.Context{"passkeys": passkeys, "noPasskeys": len(passkeys) == 0}
+	if err != nil {
+		fmt.Println("Error in /passkeys:", err)
+		pongoContext["errorMessage"] = err.Error()
+	}
+	return context.Render(200, "static/passkeys.html", pongoContext)
+}
+
+// ------------ POST ------------
+
+func postBeginPasskeyRegistration(context echo.Context) error {
+	user, _ := getUser(context)
+	creation, ceremonyToken, err := yana.BeginPasskeyRegistration(user.UserId)
+	if err != nil {
+		fmt.Println("Error in POST /begin-passkey-registration:", err)
+		return jsonError(context, http.StatusInternalServerError, "Couldn't start adding a passkey.")
+	}
+	setAuthCookie(context, PASSKEY_CEREMONY_COOKIE_NAME, ceremonyToken, int(yana.WEBAUTHN_CEREMONY_LIFETIME.Seconds()))
+	return context.JSON(200, creation)
+}
+
+func postFinishPasskeyRegistration(context echo.Context) error {
+	user, _ := getUser(context)
+	ceremonyToken, isOk := takeCeremonyToken(context)
+	if !isOk {
+		return jsonError(context, http.StatusBadRequest, "Adding the passkey took too long, please try again.")
+	}
+	err := yana.FinishPasskeyRegistration(user.UserId, ceremonyToken, context.QueryParam("name"), context.Request())
+	if err != nil {
+		fmt.Println("Error in POST /finish-passkey-registration:", err)
+		return jsonError(context, http.StatusBadRequest, "The passkey couldn't be added.")
+	}
+	recordOwnAudit(context, yana.AUDIT_PASSKEY_ADDED, context.QueryParam("name"))
+	return context.JSON(200, map[string]string{"redirect": "/passkeys"})
+}
+
+func postBeginPasskeyLogin(context echo.Context) error {
+	assertion, ceremonyToken, err := yana.BeginPasskeyLogin()
+	if err != nil {
+		fmt.Println("Error in POST /begin-passkey-login:", err)
+		return jsonError(context, http.StatusInternalServerError, "Couldn't start logging in with a passkey.")
+	}
+	setAuthCookie(context, PASSKEY_CEREMONY_COOKIE_NAME, ceremonyToken, int(yana.WEBAUTHN_CEREMONY_LIFETIME.Seconds()))
+	return context.JSON(200, assertion)
+}
+
+// A passkey already proves possession and (with user verification) knowledge or biometrics,
+// so there is no additional two-factor step here
+func postFinishPasskeyLogin(context echo.Context) error {
+	ceremonyToken, isOk := takeCeremonyToken(context)
+	if !isOk {
+		return jsonError(context, http.StatusBadRequest, "Logging in took too long, please try again.")
+	}
+	userid, err := yana.FinishPasskeyLogin(ceremonyToken, context.Request())
+	if err != nil {
+		fmt.Println("Error in POST /finish-passkey-login:", err)
+		return jsonError(context, http.StatusUnauthorized, "This passkey couldn't be used to log in.")
+	}
+	err = finishLogin(context, userid, context.QueryParam("remember") == "on", "passkey")
+	if err != nil {
+		fmt.Println("Error in POST /finish-passkey-login:", err)
+		return jsonError(context, http.StatusInternalServerError, "Couldn't log you in, please try again.")
+	}
+	return context.JSON(200, map[string]string{"redirect": "/"})
+}
+
+func postDeletePasskey(context echo.Context) error {
+	user, _ := getUser(context)
+	err := yana.DeletePasskey(user.UserId, context.FormValue("passkeyId"))
+	if err != nil {
+		fmt.Println("Error in POST /delete-passkey:", err)
+	} else {
+		recordOwnAudit(context, yana.AUDIT_PASSKEY_REMOVED, "")
+	}
+	return context.Redirect(http.StatusSeeOther, "/passkeys")
+}
+
+func initPasskeyRoutes(e *echo.Echo) {
+	e.GET("/passkeys", getPasskeys, requireLogin)
+
+	e.POST("/begin-passkey-registration", postBeginPasskeyRegistration, requireLogin)
+	e.POST("/finish-passkey-registration", postFinishPasskeyRegistration, requireLogin)
+	e.POST("/begin-passkey-login", postBeginPasskeyLogin)
+	e.POST("/finish-passkey-login", postFinishPasskeyLogin)
+	e.POST("/delete-passkey", postDeletePasskey, requireLogin)
+}
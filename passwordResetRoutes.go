@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// ------------ GET ------------
+
+func getForgotPassword(context echo.Context) error {
+	return context.Render(200, "static/forgot-password.html", pongo2.Context{})
+}
+
+func getResetPassword(context echo.Context) error {
+	token := context.QueryParam("token")
+	isValid, err := yana.IsPasswordResetTokenValid(token)
+	if err != nil {
+		fmt.Println("Error in /reset-password:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't check your reset link, please try again.")
+	}
+	if !isValid {
+		return context.Render(http.StatusNotFound, "static/forgot-password.html", pongo2.Context{
+			"errorMessage": "This link has expired or was already used. You can ask for a new one here.",
+		})
+	}
+	return context.Render(200, "static/reset-password.html", pongo2.Context{"token": token})
+}
+
+// ------------ POST ------------
+
+func postForgotPassword(context echo.Context) error {
+	err := yana.RequestPasswordReset(context.FormValue("email"), context.RealIP())
+	if yana.GetYanaErrorCode(err) == yana.TooManyAttempts {
+		return context.Render(http.StatusTooManyRequests, "static/forgot-password.html", pongo2.Context{"errorMessage": tooManyAttemptsMessage(err)})
+	} else if err != nil {
+		// The user gets the same answer either way, otherwise errors could reveal which addresses have an account
+		fmt.Println("Error in POST /forgot-password:", err)
+	}
+	return context.Render(200, "static/forgot-password.html", pongo2.Context{"isMailSent": true})
+}
+
+func postResetPassword(context echo.Context) error {
+	token := context.FormValue("token")
+	password := context.FormValue("password")
+	if password == "" || password != context.FormValue("passwordRepeated") {
+		return context.Render(http.StatusUnprocessableEntity, "static/reset-password.html", pongo2.Context{
+			"token":        token,
+			"errorMessage": "The passwords are empty or don't match.",
+		})
+	}
+	userid, err := yana.ResetPassword(token, password)
+	if err == yana.ErrPasswordResetNotFound {
+		return context.Render(http.StatusNotFound, "static/forgot-password.html", pongo2.Context{
+			"errorMessage": "This link has expired or was already used. You can ask for a new one here.",
+		})
+	} else if err != nil {
+		fmt.Println("Error in POST /reset-password:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't change your password, please try again.")
+	}
+	recordAudit(context, yana.AuditEvent{Event: yana.AUDIT_PASSWORD_RESET, UserId: userid})
+	// This browser was logged out too if it was logged in
+	setSessionCookie(context, "")
+	setRememberCookie(context, "")
+	return renderLogin(context, 200, pongo2.Context{"successMessage": "Your password has been changed. You can log in with it now."})
+}
+
+func initPasswordResetRoutes(e *echo.Echo) {
+	e.GET("/forgot-password", getForgotPassword)
+	e.GET("/reset-password", getResetPassword)
+
+	e.POST("/forgot-password", postForgotPassword)
+	e.POST("/reset-password", postResetPassword)
+}
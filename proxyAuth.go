@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+// Pages that make no sense when the auth proxy logs users in (see config/proxyauth.yml)
+var LOGIN_ROUTES = map[string]bool{
+	"/login":                true,
+	"/register":             true,
+	"/login-two-factor":     true,
+	"/forgot-password":      true,
+	"/reset-password":       true,
+	"/begin-passkey-login":  true,
+	"/finish-passkey-login": true,
+	"/oidc-login":           true,
+	"/oidc-callback":        true,
+	"/magic-link":           true,
+}
+
+// Used instead of sessionMiddleware in proxy auth mode.
+// Takes the user from the headers of the auth proxy and creates their account on their first visit.
+// Requests that don't come from a trusted proxy are rejected.
+func proxyAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(context echo.Context) error {
+		if isAPIRequest(context) {
+			return next(context)
+		}
+		config := yana.GetProxyAuthConfig()
+		// RealIP() is the client behind the proxy, the proxy itself is the direct peer
+		proxyAddress := echo.ExtractIPDirect()(context.Request())
+		if !config.IsTrustedProxy(proxyAddress) {
+			fmt.Println("Error in proxyAuthMiddleware: Request from untrusted address", proxyAddress)
+			return renderError(context, http.StatusForbidden, "YANAgo can only be reached through its auth proxy.")
+		}
+		if LOGIN_ROUTES[context.Path()] {
+			return context.Redirect(http.StatusSeeOther, "/")
+		}
+		email := context.Request().Header.Get(config.EmailHeader)
+		if email == "" {
+			// e.g. /welcome if the proxy lets some pages through without logging in
+			return next(context)
+		}
+		userid, err := yana.FindOrProvisionExternalUser(email, context.Request().Header.Get(config.NameHeader))
+		if err == yana.ErrExternalSignupClosed {
+			return renderError(context, http.StatusForbidden, "There is no YANAgo account for you and registration is closed.")
+		} else if err != nil {
+			fmt.Println("Error in proxyAuthMiddleware:", err)
+			return renderError(context, http.StatusInternalServerError, "Couldn't load your account, please try again.")
+		}
+		user, err := yana.GetUserFromUserID(userid)
+		if err != nil {
+			fmt.Println("Error in proxyAuthMiddleware:", err)
+			return renderError(context, http.StatusInternalServerError, "Couldn't load your account, please try again.")
+		}
+		if user.IsDisabled {
+			return renderError(context, http.StatusForbidden, "Your account has been disabled by an administrator.")
+		}
+		context.Set(USER_CONTEXT_KEY, user)
+		return next(context)
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 
 	"github.com/flosch/pongo2"
 	"github.com/labstack/echo/v4"
@@ -14,7 +16,22 @@ import (
 
 const IS_DEBUG = false
 
-const USER_ID_COOKIE_NAME = "user"
+const SESSION_COOKIE_NAME = "session"
+const REMEMBER_COOKIE_NAME = "remember"
+const CSRF_COOKIE_NAME = "csrf" // Only used while not logged in
+const PENDING_LOGIN_COOKIE_NAME = "pending_login"
+
+// The keys under which sessionMiddleware stores the logged in yana.User and
+// their yana.Session in the echo context
+const USER_CONTEXT_KEY = "user"
+const SESSION_CONTEXT_KEY = "session"
+
+// The key under which csrfMiddleware stores the token that has to be sent back
+const CSRF_CONTEXT_KEY = "csrfToken"
+
+// The names under which forms and fetch calls send the CSRF token
+const CSRF_FORM_FIELD = "csrf_token"
+const CSRF_HEADER = "X-CSRF-Token"
 
 type Renderer struct {
 	Debug bool
@@ -33,12 +50,16 @@ func (renderer Renderer) Render(writer io.Writer, site string, data interface{},
 		}
 	}
 	context["version"] = "V0.0.1"
+	// Every template gets the token so that every form can send it back
+	context["csrfToken"] = c.Get(CSRF_CONTEXT_KEY)
+	// Every page with a link to /register hides it
+	context["isRegistrationClosed"] = yana.GetRegistrationMode() == yana.REGISTRATION_CLOSED
 
 	var template *pongo2.Template
 	var err error
 	template, err = pongo2.FromFile(site)
 	if err != nil {
-		fmt.Printf("err != nil in renderer (argh..): '%w'\n", err)
+		fmt.Printf("err != nil in renderer (argh..): '%v'\n", err)
 		fmt.Println(err)
 		return err
 	}
@@ -47,26 +68,228 @@ func (renderer Renderer) Render(writer io.Writer, site string, data interface{},
 	return template.ExecuteWriter(context, writer)
 }
 
+// Returns the HTTP status for errors from yana.GetNoteFromNoteId() and the other note functions
+// or 0 if the error has nothing to do with the user not being allowed to see the note
+func noteAccessStatus(err error) int {
+	switch yana.GetYanaErrorCode(err) {
+	case yana.NoteNotFound:
+		return http.StatusNotFound
+	case yana.NoteForbidden:
+		return http.StatusForbidden
+	}
+	return 0
+}
+
+func renderError(context echo.Context, status int, message string) error {
+	return context.Render(status, "static/error.html", pongo2.Context{
+		"status":       status,
+		"statusText":   http.StatusText(status),
+		"errorMessage": message,
+		"isLoggedIn":   isLoggedIn(context),
+	})
+}
+
+// Every login page needs to know whether to show the single sign-on button and which login field to show
+func renderLogin(context echo.Context, status int, pongoContext pongo2.Context) error {
+	pongoContext["oidcLoginName"] = yana.GetOIDCLoginName()
+	// Directory users might log in with their user name instead of their email
+	_, isLDAPEnabled := yana.GetAuthenticator().(*yana.LDAPAuthenticator)
+	pongoContext["isLDAPEnabled"] = isLDAPEnabled
+	pongoContext["isMagicLinkEnabled"] = yana.GetServerConfig().MagicLinks
+	return context.Render(status, "static/login.html", pongoContext)
+}
+
 func isLoggedIn(context echo.Context) bool {
-	cookie, err := context.Cookie(USER_ID_COOKIE_NAME)
-	return err == nil && cookie.Value != ""
+	_, isOk := getUser(context)
+	return isOk
 }
 
-// ------------ GET ------------
+// Returns the user that sessionMiddleware found for this request
+func getUser(context echo.Context) (yana.User, bool) {
+	user, isOk := context.Get(USER_CONTEXT_KEY).(yana.User)
+	return user, isOk
+}
 
-func getIndex(context echo.Context) error {
-	if !isLoggedIn(context) {
-		return context.Redirect(http.StatusMovedPermanently, "/welcome")
+// maxAge == 0 makes it a cookie that is deleted when the browser is closed
+func setAuthCookie(context echo.Context, name string, token string, maxAge int) {
+	cookie := new(http.Cookie)
+	cookie.Name = name
+	cookie.Value = token
+	cookie.Path = "/"
+	cookie.HttpOnly = true
+	cookie.Secure = yana.GetServerConfig().SecureCookies
+	cookie.SameSite = http.SameSiteLaxMode
+	cookie.MaxAge = maxAge
+	if token == "" {
+		// Tells the browser to delete the cookie
+		cookie.MaxAge = -1
 	}
-	cookie, err := context.Cookie(USER_ID_COOKIE_NAME)
+	context.SetCookie(cookie)
+}
+
+func setSessionCookie(context echo.Context, token string) {
+	setAuthCookie(context, SESSION_COOKIE_NAME, token, 0)
+}
+
+func setRememberCookie(context echo.Context, token string) {
+	setAuthCookie(context, REMEMBER_COOKIE_NAME, token, yana.GetServerConfig().RememberMeLifetimeDays*24*60*60)
+}
+
+// Creates a new session for userid and hands it to the browser
+func logIn(context echo.Context, userid string) error {
+	_, err := startSession(context, userid)
+	return err
+}
+
+func startSession(context echo.Context, userid string) (string, error) {
+	token, err := yana.CreateSession(userid)
 	if err != nil {
-		fmt.Println("Error in /index:", err)
+		return "", err
+	}
+	setSessionCookie(context, token)
+	return token, nil
+}
+
+// Logs the user in again with their remember cookie if their session is gone.
+// The remember token is rotated every time this happens.
+func resumeRememberedLogin(context echo.Context) (yana.User, yana.Session, bool) {
+	cookie, err := context.Cookie(REMEMBER_COOKIE_NAME)
+	if err != nil || cookie.Value == "" {
+		return yana.User{}, yana.Session{}, false
+	}
+	userid, newToken, err := yana.ConsumeRememberToken(cookie.Value)
+	if err == yana.ErrRememberTokenNotFound || err == yana.ErrRememberTokenReused {
+		setRememberCookie(context, "")
+		return yana.User{}, yana.Session{}, false
+	} else if err != nil {
+		fmt.Println("Error in resumeRememberedLogin:", err)
+		return yana.User{}, yana.Session{}, false
 	}
-	notes, err := yana.GetAllNotesOfUser(cookie.Value)
+	if newToken != "" {
+		setRememberCookie(context, newToken)
+	}
+	sessionToken, err := startSession(context, userid)
+	if err != nil {
+		fmt.Println("Error in resumeRememberedLogin:", err)
+		return yana.User{}, yana.Session{}, false
+	}
+	recordAudit(context, yana.AuditEvent{Event: yana.AUDIT_LOGIN_SUCCEEDED, ActorId: userid, UserId: userid, Details: "remember me"})
+	user, session, err := yana.GetUserFromSession(sessionToken)
+	if err != nil {
+		fmt.Println("Error in resumeRememberedLogin:", err)
+		return yana.User{}, yana.Session{}, false
+	}
+	return user, session, true
+}
+
+// ------------ MIDDLEWARE ------------
+
+// Resolves the session cookie to a yana.User and stores it under USER_CONTEXT_KEY.
+// If there is no valid session, the remember cookie is tried.
+// Requests without either just continue without a user.
+// Requests with an API token were already handled by apiTokenMiddleware.
+func sessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(context echo.Context) error {
+		if isAPIRequest(context) {
+			return next(context)
+		}
+		cookie, err := context.Cookie(SESSION_COOKIE_NAME)
+		if err == nil && cookie.Value != "" {
+			user, session, err := yana.GetUserFromSession(cookie.Value)
+			if err == nil {
+				context.Set(USER_CONTEXT_KEY, user)
+				context.Set(SESSION_CONTEXT_KEY, session)
+				return next(context)
+			}
+			if err == yana.ErrSessionNotFound {
+				setSessionCookie(context, "")
+			} else {
+				fmt.Println("Error in sessionMiddleware:", err)
+				return next(context)
+			}
+		}
+		user, session, isOk := resumeRememberedLogin(context)
+		if isOk {
+			context.Set(USER_CONTEXT_KEY, user)
+			context.Set(SESSION_CONTEXT_KEY, session)
+		}
+		return next(context)
+	}
+}
+
+// Returns the CSRF token of the user's session or, if they aren't logged in,
+// the one in their csrf cookie. A new cookie is set if there is none.
+func getCSRFToken(context echo.Context) (string, error) {
+	session, isOk := context.Get(SESSION_CONTEXT_KEY).(yana.Session)
+	if isOk {
+		return session.CSRFToken, nil
+	}
+	cookie, err := context.Cookie(CSRF_COOKIE_NAME)
+	if err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	token, err := yana.GenerateCSRFToken()
+	if err != nil {
+		return "", err
+	}
+	setAuthCookie(context, CSRF_COOKIE_NAME, token, 0)
+	return token, nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Has to run after sessionMiddleware.
+// Every request that changes something has to send the CSRF token back,
+// either as the csrf_token form field or (for fetch calls) as the X-CSRF-Token header.
+func csrfMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(context echo.Context) error {
+		expectedToken, err := getCSRFToken(context)
+		if err != nil {
+			fmt.Println("Error in csrfMiddleware:", err)
+			return renderError(context, http.StatusInternalServerError, "Something went wrong, please try again.")
+		}
+		context.Set(CSRF_CONTEXT_KEY, expectedToken)
+		// API tokens aren't sent automatically by the browser like cookies, so there's nothing to forge
+		if isSafeMethod(context.Request().Method) || isAPIRequest(context) {
+			return next(context)
+		}
+		sentToken := context.Request().Header.Get(CSRF_HEADER)
+		if sentToken == "" {
+			sentToken = context.FormValue(CSRF_FORM_FIELD)
+		}
+		if sentToken == "" || subtle.ConstantTimeCompare([]byte(sentToken), []byte(expectedToken)) != 1 {
+			return renderError(context, http.StatusForbidden,
+				"This form has expired or was sent from a different website. Please go back, reload the page and try again.")
+		}
+		return next(context)
+	}
+}
+
+// For every route that is only visible when logged in (see static/visibility.txt)
+func requireLogin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(context echo.Context) error {
+		if !isLoggedIn(context) {
+			return context.Redirect(http.StatusMovedPermanently, "/welcome")
+		}
+		return next(context)
+	}
+}
+
+// ------------ GET ------------
+
+func getIndex(context echo.Context) error {
+	user, _ := getUser(context)
+	notes, err := yana.GetAllNotesOfUser(user.UserId)
 	if err != nil {
 		fmt.Println("Error in /index:", err)
 	}
-	return context.Render(200, "static/index.html", pongo2.Context{"notes": notes, "noNotes": len(notes) == 0})
+	return context.Render(200, "static/index.html", pongo2.Context{
+		"notes":           notes,
+		"noNotes":         len(notes) == 0,
+		"isEmailVerified": user.IsEmailVerified,
+	})
 }
 
 func getRoot(context echo.Context) error {
@@ -77,22 +300,12 @@ func getRoot(context echo.Context) error {
 }
 
 func getCreateNote(context echo.Context) error {
-	if !isLoggedIn(context) {
-		return context.Redirect(http.StatusMovedPermanently, "/welcome")
-	}
 	// noteTitle and noteContent are left empty
 	return context.Render(200, "static/note.html", pongo2.Context{"isNewNote": true, "formLink": "/create-note"})
 }
 
-func addCookieToContext(context *echo.Context, name string, value string) {
-	cookie := new(http.Cookie)
-	cookie.Name = name
-	cookie.Value = value
-	(*context).SetCookie(cookie)
-}
-
 func getLogin(context echo.Context) error {
-	return context.Render(200, "static/login.html", pongo2.Context{})
+	return renderLogin(context, 200, pongo2.Context{})
 }
 
 func getLogout(context echo.Context) error {
@@ -100,16 +313,54 @@ func getLogout(context echo.Context) error {
 	if !isLoggedIn(context) {
 		return context.Redirect(http.StatusMovedPermanently, "/welcome")
 	}
-	addCookieToContext(&context, USER_ID_COOKIE_NAME, "")
+	proxyAuthConfig := yana.GetProxyAuthConfig()
+	if proxyAuthConfig.Enabled {
+		// Only the auth proxy can log the user out
+		if proxyAuthConfig.LogoutURL == "" {
+			return renderError(context, http.StatusNotFound, "Please log out through your login provider.")
+		}
+		return context.Redirect(http.StatusSeeOther, proxyAuthConfig.LogoutURL)
+	}
+	recordOwnAudit(context, yana.AUDIT_LOGOUT, "")
+	cookie, err := context.Cookie(SESSION_COOKIE_NAME)
+	if err == nil {
+		err = yana.RevokeSession(cookie.Value)
+		if err != nil {
+			fmt.Println("Error in /logout:", err)
+		}
+	}
+	setSessionCookie(context, "")
+	// Logging out also means that this device shouldn't be remembered anymore
+	cookie, err = context.Cookie(REMEMBER_COOKIE_NAME)
+	if err == nil {
+		err = yana.RevokeRememberToken(cookie.Value)
+		if err != nil {
+			fmt.Println("Error in /logout:", err)
+		}
+	}
+	setRememberCookie(context, "")
 	return context.Render(200, "static/logout.html", pongo2.Context{})
 }
 
 func getRegister(context echo.Context) error {
+	if yana.GetRegistrationMode() == yana.REGISTRATION_CLOSED {
+		return renderError(context, http.StatusForbidden, "Registration is closed on this server.")
+	}
 	pongoContext := pongo2.Context{}
 	if context.Request().Header.Get("error") == "DBConnectionFailure" {
 		pongoContext = pongo2.Context{"error": "DBConnectionFailure"}
 	} // else { ... TODO
-	return context.Render(200, "static/register.html", pongoContext)
+	return renderRegister(context, 200, pongoContext)
+}
+
+// Adds the invitation code field in invite-only mode, filled in from the link in the invitation
+func renderRegister(context echo.Context, status int, pongoContext pongo2.Context) error {
+	pongoContext["isInviteOnly"] = yana.GetRegistrationMode() == yana.REGISTRATION_INVITE_ONLY
+	pongoContext["invitationCode"] = context.QueryParam("invite")
+	if context.Request().Method == http.MethodPost {
+		pongoContext["invitationCode"] = context.FormValue("invitationCode")
+	}
+	return context.Render(status, "static/register.html", pongoContext)
 }
 
 func getWelcome(context echo.Context) error {
@@ -117,17 +368,14 @@ func getWelcome(context echo.Context) error {
 }
 
 func getEditNote(context echo.Context) error {
-	if !isLoggedIn(context) {
-		return context.Redirect(http.StatusMovedPermanently, "/welcome")
-	}
 	postgresqlNoteId := context.QueryParam("noteId")
 	if postgresqlNoteId == "" {
 		return context.Redirect(http.StatusMovedPermanently, "/index")
 	}
-	note, err := yana.GetNoteFromNoteId(postgresqlNoteId)
-	if err != nil {
-		//context.Response().Header().Set("Error", "CouldNotFindNoteFromId")
-		//return context.Redirect(http.StatusMovedPermanently, "/index")
+	user, _ := getUser(context)
+	note, err := yana.GetNoteFromNoteId(user.UserId, postgresqlNoteId)
+	if status := noteAccessStatus(err); status != 0 {
+		return renderError(context, status, "This note doesn't exist or isn't yours.")
 	}
 	// if this is not converted to a string, this creates a runtime error
 	// due to invalid memory address or nil pointer dereference if there
@@ -150,35 +398,106 @@ func getEditNote(context echo.Context) error {
 	return context.Render(200, "static/note.html", pongoContext)
 }
 
+func getDevices(context echo.Context) error {
+	user, _ := getUser(context)
+	devices, err := yana.GetRememberedDevices(user.UserId)
+	pongoContext := pongo2.Context{"devices": devices, "noDevices": len(devices) == 0}
+	if err != nil {
+		fmt.Println("Error in /devices:", err)
+		pongoContext["errorMessage"] = err.Error()
+	}
+	return context.Render(200, "static/devices.html", pongoContext)
+}
+
 // ------------ POST ------------
 
+// Returns the message for a YanaError with the code TooManyAttempts
+func tooManyAttemptsMessage(err error) string {
+	var lockedErr yana.LockedError
+	if errors.As(err, &lockedErr) {
+		return fmt.Sprintf("Too many attempts, try again in %d minutes.", lockedErr.MinutesLeft())
+	}
+	return "Too many attempts, try again later."
+}
+
 func postRegister(context echo.Context) error {
+	registrationMode := yana.GetRegistrationMode()
+	if registrationMode == yana.REGISTRATION_CLOSED {
+		return renderError(context, http.StatusForbidden, "Registration is closed on this server.")
+	}
+	err := yana.CheckRegistrationThrottle(context.RealIP())
+	if yana.GetYanaErrorCode(err) == yana.TooManyAttempts {
+		return renderRegister(context, http.StatusTooManyRequests, pongo2.Context{"errorMessage": tooManyAttemptsMessage(err)})
+	} else if err != nil {
+		fmt.Println("Error in POST /register:", err)
+	}
+	err = yana.RecordRegistrationAttempt(context.RealIP())
+	if err != nil {
+		fmt.Println("Error in POST /register:", err)
+	}
+	invitationId := ""
+	if registrationMode == yana.REGISTRATION_INVITE_ONLY {
+		invitationId, err = yana.ReserveInvitation(context.FormValue("invitationCode"))
+		if err == yana.ErrInvitationNotFound {
+			return renderRegister(context, http.StatusForbidden, pongo2.Context{"errorMessage": "The invitation code is wrong, has expired or was already used."})
+		} else if err != nil {
+			fmt.Println("Error in POST /register:", err)
+			return renderRegister(context, http.StatusInternalServerError, pongo2.Context{"errorMessage": "The invitation code couldn't be checked."})
+		}
+	}
+	// The use of the invitation is only kept if the account was created
+	releaseInvitation := func() {
+		if invitationId == "" {
+			return
+		}
+		err := yana.ReleaseInvitation(invitationId)
+		if err != nil {
+			fmt.Println("Error in POST /register:", err)
+		}
+	}
 	userId, err := yana.CreateNewUser(context.FormValue("email"), context.FormValue("name"), context.FormValue("password"))
 	if err != nil {
+		releaseInvitation()
 		// TODO: Maybe implement custom errors to return here to string to tell the user what the problem was?
 		context.Response().Header().Set("error", "DBConnectionFailure")
 		// Return to register but with error
 		return context.Redirect(http.StatusMovedPermanently, "/register")
 	}
 	if userId == "" {
+		releaseInvitation()
 		context.Response().Header().Set("error", "UserAlreadyExists")
 		// Return to register but say that user with email already exists
 		return context.Redirect(http.StatusMovedPermanently, "/register")
 	}
 	err = yana.NewBucket(userId)
 	if err != nil {
+		fmt.Println("Error in POST /register:", err)
+		removeErr := yana.RemoveNewUser(userId)
+		if removeErr != nil {
+			fmt.Println("Error in POST /register:", removeErr)
+		}
+		releaseInvitation()
 		context.Response().Header().Set("error", "CouldNotCreateBucket")
 		// Return to register but say that bucket couldn't be created
 		return context.Redirect(http.StatusMovedPermanently, "/register")
 	}
-	addCookieToContext(&context, USER_ID_COOKIE_NAME, userId)
+	recordAudit(context, yana.AuditEvent{Event: yana.AUDIT_ACCOUNT_CREATED, ActorId: userId, UserId: userId, Details: invitationId})
+	err = yana.SendEmailVerification(userId)
+	if err != nil {
+		// The user can ask for a new mail on /index
+		fmt.Println("Error in POST /register:", err)
+	}
+	err = logIn(context, userId)
+	if err != nil {
+		context.Response().Header().Set("error", "DBConnectionFailure")
+		return context.Redirect(http.StatusMovedPermanently, "/login")
+	}
 	return context.Redirect(http.StatusMovedPermanently, "/")
 }
 
 func postCreateNote(context echo.Context) error {
-	// The user should absolutely be logged in if POST /create-note is called
-	cookie, _ := context.Cookie(USER_ID_COOKIE_NAME)
-	_, err := yana.NewNote(cookie.Value, context.FormValue("title"), context.FormValue("content"))
+	user, _ := getUser(context)
+	err := yana.NewNote(user.UserId, context.FormValue("title"), context.FormValue("content"))
 	if err != nil {
 		pongoContext := pongo2.Context{
 			"isNewNote":    true,
@@ -195,27 +514,78 @@ func postCreateNote(context echo.Context) error {
 }
 
 func postLogin(context echo.Context) error {
-	isOk, yanaErr := yana.IsLoginOk(context.FormValue("email"), context.FormValue("password"))
+	userid, yanaErr := yana.IsLoginOk(context.FormValue("email"), context.FormValue("password"), context.RealIP())
 	errCodeName := "errorCodeNamePlaceholder" // TODO
 	if yanaErr.Err != nil {
+		recordLoginFailure(context, context.FormValue("email"), yanaErr)
 		switch yanaErr.Code {
+		case yana.TooManyAttempts:
+			return renderLogin(context, http.StatusTooManyRequests, pongo2.Context{"errorMessage": tooManyAttemptsMessage(yanaErr)})
+		case yana.AccountDisabled:
+			return renderLogin(context, http.StatusForbidden, pongo2.Context{"errorMessage": "Your account has been disabled by an administrator."})
+		case yana.PasswordResetRequired:
+			return renderLogin(context, http.StatusForbidden, pongo2.Context{"errorMessage": "An administrator asked you to choose a new password. Use the link in your mail or \"Forgot your password?\"."})
 		default:
 			// TODO
 		}
 		context.Response().Header().Set("error", errCodeName)
 		return context.Redirect(http.StatusMovedPermanently, "/login")
 	}
-	if !isOk {
+	if userid == "" {
 		context.Response().Header().Set("error", "userDoesNotExist")
 		return context.Redirect(http.StatusMovedPermanently, "/login")
 	}
-	userid, err := yana.GetUserIDFromEmail(context.FormValue("email"))
+	isRemembered := context.FormValue("remember") == "on"
+	redirectTo, err := continueLogin(context, userid, isRemembered, "password")
 	if err != nil {
 		context.Response().Header().Set("error", errCodeName)
 		return context.Redirect(http.StatusMovedPermanently, "/login")
 	}
-	addCookieToContext(&context, USER_ID_COOKIE_NAME, userid)
-	return context.Redirect(http.StatusMovedPermanently, "/")
+	return context.Redirect(http.StatusSeeOther, redirectTo)
+}
+
+// For logins where the first factor was fine: Sends the user to /login-two-factor if they have 2FA enabled,
+// otherwise they are logged in right away.
+// method says how the first factor was checked, for the audit log.
+// Returns string: where the user should be redirected to
+func continueLogin(context echo.Context, userid string, isRemembered bool, method string) (string, error) {
+	isTwoFactorEnabled, err := yana.IsTwoFactorEnabled(userid)
+	if err != nil {
+		return "", err
+	}
+	if isTwoFactorEnabled {
+		// The session is only created after the code was checked in /login-two-factor
+		pendingToken, err := yana.CreatePendingLogin(userid, isRemembered)
+		if err != nil {
+			return "", err
+		}
+		setAuthCookie(context, PENDING_LOGIN_COOKIE_NAME, pendingToken, int(yana.PENDING_LOGIN_LIFETIME.Seconds()))
+		return "/login-two-factor", nil
+	}
+	err = finishLogin(context, userid, isRemembered, method)
+	if err != nil {
+		return "", err
+	}
+	return "/", nil
+}
+
+// The last step of every login: Creates the session and the remember token if wanted
+func finishLogin(context echo.Context, userid string, isRemembered bool, method string) error {
+	err := logIn(context, userid)
+	if err != nil {
+		return err
+	}
+	recordAudit(context, yana.AuditEvent{Event: yana.AUDIT_LOGIN_SUCCEEDED, ActorId: userid, UserId: userid, Details: method})
+	if isRemembered {
+		rememberToken, err := yana.IssueRememberToken(userid, context.Request().UserAgent())
+		if err != nil {
+			// Not worth failing the login over, the user just has to log in again next time
+			fmt.Println("Error in finishLogin:", err)
+		} else {
+			setRememberCookie(context, rememberToken)
+		}
+	}
+	return nil
 }
 
 func postEditNote(context echo.Context) error {
@@ -226,14 +596,16 @@ func postEditNote(context echo.Context) error {
 		Problem: I realised that it isn't possible to modify the content of an existing file too
 		(except for appending something to the end of a file). So yana.UpdateNote() is forced to
 		create a completely new file either way.
+		Update: Putting an object with the same name replaces it at once, so only a new title needs a new file.
 	*/
-	// The user should absolutely be logged in if POST /edit-note is called
-	// so not checking for an error at context.Cookie
-	userId, _ := context.Cookie(USER_ID_COOKIE_NAME)
+	user, _ := getUser(context)
 	noteId := context.FormValue("noteId")
 	newTitle := context.FormValue("title")
 	newContent := context.FormValue("content")
-	_, err := yana.UpdateNote(userId.Value, noteId, newTitle, newContent)
+	_, err := yana.UpdateNote(user.UserId, noteId, newTitle, newContent)
+	if status := noteAccessStatus(err); status != 0 {
+		return renderError(context, status, "This note doesn't exist or isn't yours.")
+	}
 	if err != nil {
 		pongoContext := pongo2.Context{
 			"isNewNote":    false,
@@ -249,6 +621,15 @@ func postEditNote(context echo.Context) error {
 	return context.Redirect(http.StatusMovedPermanently, fmt.Sprintf("/edit-note?noteId=%s&isSuccesful=%s", noteId, "true"))
 }
 
+func postRevokeDevice(context echo.Context) error {
+	user, _ := getUser(context)
+	err := yana.RevokeRememberedDevice(user.UserId, context.FormValue("familyId"))
+	if err != nil {
+		fmt.Println("Error in POST /revoke-device:", err)
+	}
+	return context.Redirect(http.StatusMovedPermanently, "/devices")
+}
+
 // ------------ DELETE ------------
 
 // FIXME: The note stays visible in /index after deletion.
@@ -258,49 +639,128 @@ func deleteDeleteNote(context echo.Context) error {
 	jsonMap := make(map[string]interface{})
 	err := json.NewDecoder(context.Request().Body).Decode(&jsonMap)
 	if err != nil {
-		fmt.Printf("Error in deleteDeleteNote: %w", err)
+		fmt.Printf("Error in deleteDeleteNote: %v", err)
 		fmt.Println("Should load back to root")
 		return context.Redirect(http.StatusMovedPermanently, "/")
 	}
-	var noteId string = jsonMap["noteId"].(string)
-	err = yana.DeleteNoteFromNoteId(noteId)
+	noteId, _ := jsonMap["noteId"].(string)
+	user, _ := getUser(context)
+	err = yana.DeleteNoteFromNoteId(user.UserId, noteId)
+	if status := noteAccessStatus(err); status != 0 {
+		return context.JSON(status, map[string]string{"error": http.StatusText(status)})
+	}
 	if err != nil {
-		fmt.Printf("Could get noteId but failed deleting note: %w", err)
+		fmt.Printf("Could get noteId but failed deleting note: %v", err)
+	} else {
+		recordOwnAudit(context, yana.AUDIT_NOTE_DELETED, noteId)
 	}
 	fmt.Println("Should load back to index")
 	return context.Redirect(http.StatusMovedPermanently, "/")
 }
 
 func initRoutes(e *echo.Echo) {
+	e.Use(apiTokenMiddleware)
+	if yana.GetProxyAuthConfig().Enabled {
+		e.Use(proxyAuthMiddleware)
+	} else {
+		e.Use(sessionMiddleware)
+	}
+	e.Use(csrfMiddleware)
 	e.Static("/", "static")
 
 	e.GET("/", getRoot)
-	e.GET("/index", getIndex)
-	e.GET("/create-note", getCreateNote)
+	e.GET("/index", getIndex, requireLogin)
+	e.GET("/create-note", getCreateNote, requireLogin, restrictUnverified(canUnverifiedCreateNotes))
 	e.GET("/login", getLogin)
 	e.GET("/register", getRegister)
 	e.GET("/welcome", getWelcome)
 	e.GET("/logout", getLogout)
-	e.GET("/edit-note", getEditNote)
+	e.GET("/edit-note", getEditNote, requireLogin)
+	e.GET("/devices", getDevices, requireLogin)
 
 	e.POST("/login", postLogin)
-	e.POST("/create-note", postCreateNote)
+	e.POST("/create-note", postCreateNote, requireLogin, restrictUnverified(canUnverifiedCreateNotes))
 	e.POST("/register", postRegister)
-	e.POST("/edit-note", postEditNote)
+	e.POST("/edit-note", postEditNote, requireLogin, restrictUnverified(canUnverifiedEditNotes))
+	e.POST("/revoke-device", postRevokeDevice, requireLogin)
+
+	initTwoFactorRoutes(e)
+	initPasskeyRoutes(e)
+	initPasswordResetRoutes(e)
+	initEmailVerificationRoutes(e)
+	initOIDCRoutes(e)
+	initMagicLinkRoutes(e)
+	initAPITokenRoutes(e)
+	initSettingsRoutes(e)
+	initAccountDeletionRoutes(e)
+	initAdminRoutes(e)
+	initAuditLogRoutes(e)
+	initInvitationRoutes(e)
 
 	// edit-note and delete-note are called from javascript in index.html
 	// because that unfortunately makes the most sense
 
-	e.DELETE("/delete-note", deleteDeleteNote)
+	e.DELETE("/delete-note", deleteDeleteNote, requireLogin, restrictUnverified(canUnverifiedEditNotes))
+}
+
+// X-Forwarded-For is only believed from the trustedproxies in config/proxyauth.yml,
+// otherwise anyone could avoid the per IP login limits by sending it
+func newIPExtractor() echo.IPExtractor {
+	trustedNets := yana.GetProxyAuthConfig().GetTrustedNets()
+	if len(trustedNets) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	// echo trusts loopback and private addresses by default, only the configured ones should count
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, trustedNet := range trustedNets {
+		options = append(options, echo.TrustIPRange(trustedNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// Opens the database pool and the note storage once for the whole process, see yana.Init()
+func initYana(isAutoMigrating bool) error {
+	db, err := yana.OpenDatabase()
+	if err != nil {
+		return err
+	}
+	store, err := yana.NewContentStore()
+	if err != nil {
+		db.Close()
+		return err
+	}
+	yana.Init(db, store)
+	if !isAutoMigrating || !yana.GetServerConfig().AutoMigrate {
+		return nil
+	}
+	appliedMigrations, err := yana.MigrateUp()
+	printMigrations("Applied", appliedMigrations)
+	return err
 }
 
 func main() {
+	// "migrate" decides itself what to apply
+	err := initYana(len(os.Args) < 2 || os.Args[1] != "migrate")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't start YANAgo:", err)
+		os.Exit(1)
+	}
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	err = yana.CheckAuthenticatorConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't start YANAgo:", err)
+		os.Exit(1)
+	}
+
 	renderer := Renderer{
 		Debug: false,
 	}
 
 	echoServer := echo.New()
 	echoServer.Renderer = renderer
+	echoServer.IPExtractor = newIPExtractor()
 
 	// ChatGPT generated with a few edits by me
 	echoServer.HTTPErrorHandler = func(err error, context echo.Context) {
@@ -314,5 +774,7 @@ func main() {
 	}
 
 	initRoutes(echoServer)
+	yana.StartAccountDeletionFinisher()
+	yana.StartUnfinishedNoteFinisher()
 	echoServer.Logger.Fatal(echoServer.Start(":1323"))
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"yana.go/yana"
+)
+
+func renderSettings(context echo.Context, status int, pongoContext pongo2.Context) error {
+	sessionUser, _ := getUser(context)
+	user, err := yana.GetUserFromUserID(sessionUser.UserId)
+	if err != nil {
+		fmt.Println("Error in renderSettings:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't load your settings.")
+	}
+	usage, err := yana.GetStorageUsage(user.UserId)
+	if err != nil {
+		fmt.Println("Error in renderSettings:", err)
+	} else {
+		pongoContext["usage"] = usage
+	}
+	auditEvents, err := yana.GetAuditEvents(yana.AuditFilter{UserId: user.UserId, Limit: yana.RECENT_AUDIT_EVENTS})
+	if err != nil {
+		fmt.Println("Error in renderSettings:", err)
+	}
+	pongoContext["auditEvents"] = auditEvents
+	pongoContext["user"] = user
+	pongoContext["canInvite"] = yana.CanInvite(user)
+	return context.Render(status, "static/settings.html", pongoContext)
+}
+
+// ------------ GET ------------
+
+func getSettings(context echo.Context) error {
+	pongoContext := pongo2.Context{}
+	if context.QueryParam("passwordChanged") == "true" {
+		pongoContext["successMessage"] = "Your password has been changed and you have been logged out on every other device."
+	}
+	return renderSettings(context, 200, pongoContext)
+}
+
+// ------------ POST ------------
+
+func postUpdateName(context echo.Context) error {
+	user, _ := getUser(context)
+	err := yana.UpdateFullName(user.UserId, context.FormValue("name"))
+	if err != nil {
+		fmt.Println("Error in POST /update-name:", err)
+		return renderSettings(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "Your name couldn't be changed."})
+	}
+	return renderSettings(context, 200, pongo2.Context{"successMessage": "Your name has been changed."})
+}
+
+func postUpdateEmail(context echo.Context) error {
+	user, _ := getUser(context)
+	err := yana.UpdateEmail(user.UserId, context.FormValue("password"), context.FormValue("email"))
+	if yana.GetYanaErrorCode(err) == yana.PasswordsNotEqual {
+		return renderSettings(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "The password was wrong, your email wasn't changed."})
+	} else if err == yana.ErrEmailTaken {
+		return renderSettings(context, http.StatusConflict, pongo2.Context{"errorMessage": "There already is an account with this email."})
+	} else if err != nil {
+		fmt.Println("Error in POST /update-email:", err)
+		return renderSettings(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "Your email couldn't be changed. Is it a valid address?"})
+	}
+	recordOwnAudit(context, yana.AUDIT_EMAIL_CHANGED, "")
+	return renderSettings(context, 200, pongo2.Context{"successMessage": "Your email has been changed. Please confirm it with the link we sent to the new address."})
+}
+
+func postChangePassword(context echo.Context) error {
+	user, _ := getUser(context)
+	newPassword := context.FormValue("newPassword")
+	if newPassword == "" || newPassword != context.FormValue("newPasswordRepeated") {
+		return renderSettings(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "The new passwords are empty or don't match."})
+	}
+	err := yana.ChangePassword(user.UserId, context.FormValue("currentPassword"), newPassword)
+	if yana.GetYanaErrorCode(err) == yana.PasswordsNotEqual {
+		return renderSettings(context, http.StatusUnprocessableEntity, pongo2.Context{"errorMessage": "The current password was wrong, your password wasn't changed."})
+	} else if err != nil {
+		fmt.Println("Error in POST /change-password:", err)
+		return renderSettings(context, http.StatusInternalServerError, pongo2.Context{"errorMessage": "Your password couldn't be changed."})
+	}
+	recordOwnAudit(context, yana.AUDIT_PASSWORD_CHANGED, "")
+	// Every other device has to log in with the new password, this one gets a fresh session
+	err = yana.RevokeAllSessionsOfUser(user.UserId)
+	if err != nil {
+		fmt.Println("Error in POST /change-password:", err)
+	}
+	err = yana.RevokeAllRememberTokensOfUser(user.UserId)
+	if err != nil {
+		fmt.Println("Error in POST /change-password:", err)
+	}
+	setRememberCookie(context, "")
+	err = logIn(context, user.UserId)
+	if err != nil {
+		fmt.Println("Error in POST /change-password:", err)
+		return context.Redirect(http.StatusSeeOther, "/login")
+	}
+	return context.Redirect(http.StatusSeeOther, "/settings?passwordChanged=true")
+}
+
+func initSettingsRoutes(e *echo.Echo) {
+	e.GET("/settings", getSettings, requireLogin)
+
+	e.POST("/update-name", postUpdateName, requireLogin)
+	e.POST("/update-email", postUpdateEmail, requireLogin)
+	e.POST("/change-password", postChangePassword, requireLogin)
+}
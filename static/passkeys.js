@@ -0,0 +1,84 @@
+// WebAuthn sends binary data, but the server speaks JSON with base64url strings.
+// These helpers convert between the two for navigator.credentials.create() and .get()
+
+function base64urlToBuffer(value) {
+    const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    const padded = base64 + "=".repeat((4 - base64.length % 4) % 4);
+    const binary = atob(padded);
+    const bytes = new Uint8Array(binary.length);
+    for (let i = 0; i < binary.length; i++) {
+        bytes[i] = binary.charCodeAt(i);
+    }
+    return bytes.buffer;
+}
+
+function bufferToBase64url(buffer) {
+    const bytes = new Uint8Array(buffer);
+    let binary = "";
+    for (const byte of bytes) {
+        binary += String.fromCharCode(byte);
+    }
+    return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+async function postJSON(url, csrfToken, body) {
+    const response = await fetch(url, {
+        method: "POST",
+        body: body === undefined ? undefined : JSON.stringify(body),
+        headers: {
+            "Content-Type": "application/json",
+            "X-CSRF-Token": csrfToken,
+        },
+    });
+    const json = await response.json();
+    if (!response.ok) {
+        throw new Error(json.error || "Something went wrong");
+    }
+    return json;
+}
+
+async function registerPasskey(csrfToken, name) {
+    const options = await postJSON("/begin-passkey-registration", csrfToken);
+    const publicKey = options.publicKey;
+    publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+    publicKey.user.id = base64urlToBuffer(publicKey.user.id);
+    (publicKey.excludeCredentials || []).forEach(credential => {
+        credential.id = base64urlToBuffer(credential.id);
+    });
+
+    const credential = await navigator.credentials.create({ publicKey: publicKey });
+    const result = await postJSON("/finish-passkey-registration?name=" + encodeURIComponent(name), csrfToken, {
+        id: credential.id,
+        rawId: bufferToBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+            attestationObject: bufferToBase64url(credential.response.attestationObject),
+            transports: credential.response.getTransports ? credential.response.getTransports() : [],
+        },
+    });
+    window.location.href = result.redirect;
+}
+
+async function loginWithPasskey(csrfToken, isRemembered) {
+    const options = await postJSON("/begin-passkey-login", csrfToken);
+    const publicKey = options.publicKey;
+    publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+    (publicKey.allowCredentials || []).forEach(credential => {
+        credential.id = base64urlToBuffer(credential.id);
+    });
+
+    const credential = await navigator.credentials.get({ publicKey: publicKey });
+    const result = await postJSON("/finish-passkey-login?remember=" + (isRemembered ? "on" : ""), csrfToken, {
+        id: credential.id,
+        rawId: bufferToBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+            authenticatorData: bufferToBase64url(credential.response.authenticatorData),
+            signature: bufferToBase64url(credential.response.signature),
+            userHandle: credential.response.userHandle ? bufferToBase64url(credential.response.userHandle) : null,
+        },
+    });
+    window.location.href = result.redirect;
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/flosch/pongo2"
+	"github.com/labstack/echo/v4"
+	"github.com/skip2/go-qrcode"
+	"yana.go/yana"
+)
+
+const QR_CODE_SIZE = 256 // in pixels
+
+// Returns the QR code as a data URI so it can be used as the src of an <img> directly
+func toQRCodeDataURI(content string) (string, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, QR_CODE_SIZE)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+func renderTwoFactorEnrollment(context echo.Context, status int, enrollment yana.TwoFactorEnrollment, errorMessage string) error {
+	qrCode, err := toQRCodeDataURI(enrollment.URI)
+	if err != nil {
+		fmt.Println("Error in renderTwoFactorEnrollment:", err)
+	}
+	return context.Render(status, "static/two-factor.html", pongo2.Context{
+		"isEnrolling":  true,
+		"secret":       enrollment.Secret,
+		"uri":          enrollment.URI,
+		"qrCode":       qrCode,
+		"errorMessage": errorMessage,
+	})
+}
+
+// ------------ GET ------------
+
+func getTwoFactor(context echo.Context) error {
+	user, _ := getUser(context)
+	isEnabled, err := yana.IsTwoFactorEnabled(user.UserId)
+	if err != nil {
+		fmt.Println("Error in /two-factor:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't load your two-factor settings.")
+	}
+	pongoContext := pongo2.Context{"isEnabled": isEnabled}
+	if isEnabled {
+		unusedCodes, err := yana.CountUnusedRecoveryCodes(user.UserId)
+		if err != nil {
+			fmt.Println("Error in /two-factor:", err)
+		}
+		pongoContext["unusedRecoveryCodes"] = unusedCodes
+	}
+	return context.Render(200, "static/two-factor.html", pongoContext)
+}
+
+func getLoginTwoFactor(context echo.Context) error {
+	_, err := context.Cookie(PENDING_LOGIN_COOKIE_NAME)
+	if err != nil {
+		return context.Redirect(http.StatusSeeOther, "/login")
+	}
+	return context.Render(200, "static/login-two-factor.html", pongo2.Context{})
+}
+
+// ------------ POST ------------
+
+func postEnableTwoFactor(context echo.Context) error {
+	user, _ := getUser(context)
+	enrollment, err := yana.BeginTwoFactorEnrollment(user.UserId, user.Email)
+	if errors.Is(err, yana.ErrNoSecretKey) {
+		return renderError(context, http.StatusInternalServerError, "Two-factor authentication isn't set up on this server (secretkey is missing in config/server.yml).")
+	} else if err != nil {
+		fmt.Println("Error in POST /enable-two-factor:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't start enabling two-factor authentication.")
+	}
+	return renderTwoFactorEnrollment(context, 200, enrollment, "")
+}
+
+func postConfirmTwoFactor(context echo.Context) error {
+	user, _ := getUser(context)
+	recoveryCodes, err := yana.ConfirmTwoFactorEnrollment(user.UserId, context.FormValue("code"))
+	if yana.GetYanaErrorCode(err) == yana.WrongTwoFactorCode {
+		enrollment, err := yana.GetTwoFactorEnrollment(user.UserId, user.Email)
+		if err != nil {
+			return context.Redirect(http.StatusSeeOther, "/two-factor")
+		}
+		return renderTwoFactorEnrollment(context, http.StatusUnprocessableEntity, enrollment, "The code was wrong, please try again.")
+	} else if err != nil {
+		fmt.Println("Error in POST /confirm-two-factor:", err)
+		return context.Redirect(http.StatusSeeOther, "/two-factor")
+	}
+	recordOwnAudit(context, yana.AUDIT_TWO_FACTOR_ENABLED, "")
+	return context.Render(200, "static/two-factor.html", pongo2.Context{"isEnabled": true, "recoveryCodes": recoveryCodes})
+}
+
+func postDisableTwoFactor(context echo.Context) error {
+	user, _ := getUser(context)
+	err := yana.DisableTwoFactor(user.UserId, context.FormValue("password"))
+	if yana.GetYanaErrorCode(err) == yana.PasswordsNotEqual {
+		return context.Render(http.StatusUnprocessableEntity, "static/two-factor.html", pongo2.Context{
+			"isEnabled":    true,
+			"errorMessage": "The password was wrong, two-factor authentication is still enabled.",
+		})
+	} else if err != nil {
+		fmt.Println("Error in POST /disable-two-factor:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't disable two-factor authentication.")
+	}
+	recordOwnAudit(context, yana.AUDIT_TWO_FACTOR_DISABLED, "")
+	return context.Redirect(http.StatusSeeOther, "/two-factor")
+}
+
+// The second step of the login, after the password was correct in POST /login
+func postLoginTwoFactor(context echo.Context) error {
+	cookie, err := context.Cookie(PENDING_LOGIN_COOKIE_NAME)
+	if err != nil {
+		return context.Redirect(http.StatusSeeOther, "/login")
+	}
+	userid, isRemembered, err := yana.UsePendingLogin(cookie.Value)
+	if err != nil {
+		if err != yana.ErrPendingLoginNotFound {
+			fmt.Println("Error in POST /login-two-factor:", err)
+		}
+		setAuthCookie(context, PENDING_LOGIN_COOKIE_NAME, "", 0)
+		return renderLogin(context, http.StatusUnauthorized, pongo2.Context{
+			"errorMessage": "Your login has expired or there were too many wrong codes. Please log in again.",
+		})
+	}
+	isOk, err := yana.VerifyTwoFactorCode(userid, context.FormValue("code"))
+	if err != nil {
+		fmt.Println("Error in POST /login-two-factor:", err)
+	}
+	if !isOk {
+		recordAudit(context, yana.AuditEvent{Event: yana.AUDIT_LOGIN_FAILED, UserId: userid, Details: "wrong two-factor code"})
+		return context.Render(http.StatusUnauthorized, "static/login-two-factor.html", pongo2.Context{"errorMessage": "The code was wrong, please try again."})
+	}
+	err = yana.DeletePendingLogin(cookie.Value)
+	if err != nil {
+		fmt.Println("Error in POST /login-two-factor:", err)
+	}
+	setAuthCookie(context, PENDING_LOGIN_COOKIE_NAME, "", 0)
+	err = finishLogin(context, userid, isRemembered, "two-factor code")
+	if err != nil {
+		fmt.Println("Error in POST /login-two-factor:", err)
+		return renderError(context, http.StatusInternalServerError, "Couldn't log you in, please try again.")
+	}
+	return context.Redirect(http.StatusSeeOther, "/")
+}
+
+func initTwoFactorRoutes(e *echo.Echo) {
+	e.GET("/two-factor", getTwoFactor, requireLogin)
+	e.GET("/login-two-factor", getLoginTwoFactor)
+
+	e.POST("/enable-two-factor", postEnableTwoFactor, requireLogin)
+	e.POST("/confirm-two-factor", postConfirmTwoFactor, requireLogin)
+	e.POST("/disable-two-factor", postDisableTwoFactor, requireLogin)
+	e.POST("/login-two-factor", postLoginTwoFactor)
+}
@@ -0,0 +1,253 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * Deleting an account happens in two steps so that it can't get stuck halfway:
+ *
+ * 1. In one transaction, the user's notes are counted, an account_deletion row is added and the user_ row is removed.
+ *    Its foreign keys remove everything that lets someone log in as the user (sessions, tokens, passkeys, ...) with it.
+ *    The IP addresses and user agents of the user's audit log events are emptied.
+ *    From here on the account is gone for the user, even if the rest fails.
+ * 2. Every object in the user's bucket, the bucket and the note rows are removed
+ *    and the account_deletion row is marked as finished.
+ *
+ * If the content store (e.g. MinIO) fails in step 2, FinishPendingAccountDeletions() tries again later.
+ * The account_deletion row doubles as the receipt and only contains the random id of the user, no personal data.
+ */
+
+// Returned by GetAccountDeletionReceipt() if there is no deletion with this id
+var ErrAccountDeletionNotFound = errors.New("account deletion not found")
+
+type AccountDeletionReceipt struct {
+	Id             string
+	RequestedAtUTC time.Time
+	FinishedAtUTC  sql.NullTime // Not valid while MinIO still has data of the user
+	NotesDeleted   int
+	ObjectsDeleted int
+}
+
+func (receipt AccountDeletionReceipt) IsFinished() bool {
+	return receipt.FinishedAtUTC.Valid
+}
+
+// Deletes the account of userid after checking its password.
+// Accounts without a password (see provisioning.go) confirm with their email instead.
+// Returns the receipt even if MinIO failed, in that case it isn't finished yet.
+// Returns a YanaError with the code PasswordsNotEqual if the confirmation is wrong.
+func DeleteAccount(userid string, confirmation string) (AccountDeletionReceipt, error) {
+	user, err := GetUserFromUserID(userid)
+	if err != nil {
+		return AccountDeletionReceipt{}, fmt.Errorf("yana.DeleteAccount() -> Couldn't get user: %w", err)
+	}
+	isConfirmed := confirmation == user.Email
+	if user.HasPassword {
+		isConfirmed, err = IsPasswordCorrect(userid, confirmation)
+		if err != nil {
+			return AccountDeletionReceipt{}, fmt.Errorf("yana.DeleteAccount() -> Couldn't check password: %w", err)
+		}
+	}
+	if !isConfirmed {
+		return AccountDeletionReceipt{}, YanaError{Code: PasswordsNotEqual, Err: fmt.Errorf("yana.DeleteAccount() -> Confirmation is wrong")}
+	}
+
+	return deleteAccount(user)
+}
+
+// Removes the account without asking for a confirmation, see DeleteAccount() and AdminDeleteUser()
+func deleteAccount(user User) (AccountDeletionReceipt, error) {
+	receiptId, err := startAccountDeletion(user)
+	if err != nil {
+		return AccountDeletionReceipt{}, err
+	}
+	err = finishAccountDeletion(receiptId, user.UserId)
+	if err != nil {
+		fmt.Println("yana.deleteAccount() -> Couldn't finish deletion, it will be tried again later:", err)
+	}
+	return GetAccountDeletionReceipt(receiptId)
+}
+
+// Step 1, see above. Returns the id of the receipt
+func startAccountDeletion(user User) (string, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.startAccountDeletion() -> Couldn't get database: %w", err)
+	}
+	transaction, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("yana.startAccountDeletion() -> Couldn't begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	// Counted now because the user can't add or remove notes anymore once the transaction is committed
+	var notesDeleted int
+	err = transaction.QueryRow(`SELECT COUNT(*) FROM note WHERE bucketname = $1 AND state = $2`, user.UserId, NOTE_STATE_COMMITTED).Scan(&notesDeleted)
+	if err != nil {
+		return "", fmt.Errorf("yana.startAccountDeletion() -> Count query on note wasn't succesful: %w", err)
+	}
+	receiptId := generateUserID()
+	query := `INSERT INTO account_deletion (id, user_id, requested_at_utc, notes_deleted, objects_deleted) VALUES ($1, $2, $3, $4, 0)`
+	_, err = transaction.Exec(query, receiptId, user.UserId, time.Now().UTC(), notesDeleted)
+	if err != nil {
+		return "", fmt.Errorf("yana.startAccountDeletion() -> Insert query wasn't succesful: %w", err)
+	}
+	// The user's addresses and browsers are personal data as well. Events that others did to the account keep theirs
+	_, err = transaction.Exec(`UPDATE audit_log SET ip = '', useragent = '' WHERE actor_id = $1 OR (actor_id IS NULL AND user_id = $2)`,
+		user.UserId, user.UserId)
+	if err != nil {
+		return "", fmt.Errorf("yana.startAccountDeletion() -> Update query on audit_log wasn't succesful: %w", err)
+	}
+	// The throttle keys contain the email
+	_, err = transaction.Exec(`DELETE FROM login_throttle WHERE key = $1`, accountThrottleKey(user.Email))
+	if err != nil {
+		return "", fmt.Errorf("yana.startAccountDeletion() -> Delete query on login_throttle wasn't succesful: %w", err)
+	}
+	_, err = transaction.Exec(`DELETE FROM login_throttle_event WHERE key = $1`, accountThrottleKey(user.Email))
+	if err != nil {
+		return "", fmt.Errorf("yana.startAccountDeletion() -> Delete query on login_throttle_event wasn't succesful: %w", err)
+	}
+	// The foreign keys of every other table with a user_id (see migrations/*/0002_constraints.up.sql) remove its rows as well
+	_, err = transaction.Exec(`DELETE FROM user_ WHERE id = $1`, user.UserId)
+	if err != nil {
+		return "", fmt.Errorf("yana.startAccountDeletion() -> Delete query on user_ wasn't succesful: %w", err)
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return "", fmt.Errorf("yana.startAccountDeletion() -> Couldn't commit transaction: %w", err)
+	}
+	return receiptId, nil
+}
+
+// Step 2, see above. Can be called as often as needed, the bucket of a user is named after their id
+func finishAccountDeletion(receiptId string, userid string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.finishAccountDeletion() -> Couldn't get database: %w", err)
+	}
+	objectsDeleted, err := emptyAndRemoveBucket(userid)
+	if err != nil {
+		// The next try only finds the objects that are left, so the ones removed so far are counted now
+		_, updateErr := db.Exec(`UPDATE account_deletion SET objects_deleted = objects_deleted + $1 WHERE id = $2`, objectsDeleted, receiptId)
+		if updateErr != nil {
+			fmt.Println("yana.finishAccountDeletion() -> Couldn't count removed objects:", updateErr)
+		}
+		return fmt.Errorf("yana.finishAccountDeletion() -> Couldn't remove bucket: %w", err)
+	}
+	// The notes were counted in step 1, note has no foreign key to user_ so the rows are still there
+	_, err = db.Exec(`DELETE FROM note WHERE bucketname = $1`, userid)
+	if err != nil {
+		return fmt.Errorf("yana.finishAccountDeletion() -> Delete query wasn't succesful: %w", err)
+	}
+	query := `UPDATE account_deletion SET finished_at_utc = $1, objects_deleted = objects_deleted + $2 WHERE id = $3`
+	_, err = db.Exec(query, time.Now().UTC(), objectsDeleted, receiptId)
+	if err != nil {
+		return fmt.Errorf("yana.finishAccountDeletion() -> Update query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+// Removes every object in bucketName and then the bucket.
+// A bucket that doesn't exist (anymore) counts as removed.
+// Returns int: how many objects were removed
+func emptyAndRemoveBucket(bucketName string) (int, error) {
+	store, err := getContentStore()
+	if err != nil {
+		return 0, fmt.Errorf("yana.emptyAndRemoveBucket() -> Couldn't get content store: %w", err)
+	}
+	contentInfos, err := store.List(bucketName)
+	if err != nil {
+		return 0, fmt.Errorf("yana.emptyAndRemoveBucket() -> Couldn't list objects: %w", err)
+	}
+	objectsDeleted := 0
+	for _, contentInfo := range contentInfos {
+		err = store.Delete(bucketName, contentInfo.Key)
+		if err != nil {
+			return objectsDeleted, fmt.Errorf("yana.emptyAndRemoveBucket() -> Couldn't remove object: %w", err)
+		}
+		objectsDeleted++
+	}
+	err = store.DeleteNamespace(bucketName)
+	if err != nil {
+		return objectsDeleted, fmt.Errorf("yana.emptyAndRemoveBucket() -> Couldn't remove bucket: %w", err)
+	}
+	return objectsDeleted, nil
+}
+
+// Tries again to finish every deletion that failed in MinIO before
+func FinishPendingAccountDeletions() error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.FinishPendingAccountDeletions() -> Couldn't get database: %w", err)
+	}
+	rows, err := db.Query(`SELECT id, user_id FROM account_deletion WHERE finished_at_utc IS NULL`)
+	if err != nil {
+		return fmt.Errorf("yana.FinishPendingAccountDeletions() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	type pendingDeletion struct {
+		receiptId string
+		userid    string
+	}
+	var pendingDeletions []pendingDeletion
+	for rows.Next() {
+		var deletion pendingDeletion
+		err = rows.Scan(&deletion.receiptId, &deletion.userid)
+		if err != nil {
+			return fmt.Errorf("yana.FinishPendingAccountDeletions() -> Couldn't scan row: %w", err)
+		}
+		pendingDeletions = append(pendingDeletions, deletion)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("yana.FinishPendingAccountDeletions() -> Couldn't read rows: %w", err)
+	}
+
+	var lastErr error
+	for _, deletion := range pendingDeletions {
+		err = finishAccountDeletion(deletion.receiptId, deletion.userid)
+		if err != nil {
+			lastErr = fmt.Errorf("yana.FinishPendingAccountDeletions() -> Couldn't finish deletion %s: %w", deletion.receiptId, err)
+			fmt.Println(lastErr)
+		}
+	}
+	return lastErr
+}
+
+// Runs FinishPendingAccountDeletions() now and then every AccountDeletionRetryMinutes until the server stops
+func StartAccountDeletionFinisher() {
+	interval := time.Duration(GetServerConfig().AccountDeletionRetryMinutes) * time.Minute
+	go func() {
+		for {
+			FinishPendingAccountDeletions()
+			if interval <= 0 {
+				return
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func GetAccountDeletionReceipt(receiptId string) (AccountDeletionReceipt, error) {
+	if uuid.Validate(receiptId) != nil {
+		return AccountDeletionReceipt{}, ErrAccountDeletionNotFound
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return AccountDeletionReceipt{}, fmt.Errorf("yana.GetAccountDeletionReceipt() -> Couldn't get database: %w", err)
+	}
+	var receipt AccountDeletionReceipt
+	query := `SELECT id, requested_at_utc, finished_at_utc, notes_deleted, objects_deleted FROM account_deletion WHERE id = $1`
+	err = db.QueryRow(query, receiptId).Scan(&receipt.Id, &receipt.RequestedAtUTC, &receipt.FinishedAtUTC, &receipt.NotesDeleted, &receipt.ObjectsDeleted)
+	if err == sql.ErrNoRows {
+		return AccountDeletionReceipt{}, ErrAccountDeletionNotFound
+	} else if err != nil {
+		return AccountDeletionReceipt{}, fmt.Errorf("yana.GetAccountDeletionReceipt() -> Select query wasn't succesful: %w", err)
+	}
+	return receipt, nil
+}
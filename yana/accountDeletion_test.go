@@ -0,0 +1,61 @@
+package yana
+
+import (
+	"testing"
+	"time"
+)
+
+// Every table with a user_id that has to be empty for the user after step 1
+var accountDeletionTestTables = []string{
+	"session", "remember_token", "pending_login", "recovery_code", "webauthn_credential", "webauthn_ceremony",
+	"password_reset", "oidc_identity", "magic_link", "api_token", "invitation",
+}
+
+func TestDeleteAccount(t *testing.T) {
+	db := newTestSQLite(t)
+	user := newTestUser(t, getRepository())
+	err := NewBucket(user.UserId)
+	if err != nil {
+		t.Fatalf("NewBucket() = %v", err)
+	}
+	for _, name := range []string{"First", "Second"} {
+		err = NewNote(user.UserId, name, name)
+		if err != nil {
+			t.Fatalf("NewNote(%q) = %v", name, err)
+		}
+	}
+	// A note that is being deleted is already gone for the user and isn't counted
+	newTestNote(t, getRepository(), user.UserId, "Deleting", NOTE_STATE_DELETING)
+	_, err = CreateSession(user.UserId)
+	if err != nil {
+		t.Fatalf("CreateSession() = %v", err)
+	}
+	_, err = CreateAPIToken(user.UserId, "Token", []string{SCOPE_NOTES_READ}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateAPIToken() = %v", err)
+	}
+
+	receipt, err := deleteAccount(user)
+	if err != nil {
+		t.Fatalf("deleteAccount() = %v", err)
+	}
+	if !receipt.IsFinished() || receipt.NotesDeleted != 2 || receipt.ObjectsDeleted != 2 {
+		t.Errorf("deleteAccount() = %+v, want a finished receipt with 2 notes and 2 objects", receipt)
+	}
+
+	for _, table := range append(accountDeletionTestTables, "note") {
+		column := "user_id"
+		if table == "note" {
+			column = "bucketname"
+		}
+		var count int
+		err = db.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE `+column+` = $1`, user.UserId).Scan(&count)
+		if err != nil || count != 0 {
+			t.Errorf("Rows of the deleted user in %s = %d, %v, want 0", table, count, err)
+		}
+	}
+	_, err = GetUserFromUserID(user.UserId)
+	if err == nil {
+		t.Errorf("GetUserFromUserID() of deleted user = nil, want an error")
+	}
+}
@@ -0,0 +1,336 @@
+package yana
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+/*
+ * Admins can see every account in /admin and disable, reset or delete them.
+ * The first admin is created with the create-admin command (see cli.go),
+ * after that admins can make other users admins in /admin.
+ */
+
+const (
+	ROLE_USER  = "user"
+	ROLE_ADMIN = "admin"
+)
+
+var ROLES = []string{ROLE_USER, ROLE_ADMIN}
+
+// How long the status page waits for the database and the note storage before it calls them down
+const SYSTEM_STATUS_TIMEOUT = 5 * time.Second
+
+var serverStartedAtUTC = time.Now().UTC()
+
+// One row of the user list in /admin
+type UserOverview struct {
+	User
+	NoteCount    int          // From the note table
+	Usage        StorageUsage // From the content store
+	IsUsageKnown bool         // false if the content store couldn't be asked
+}
+
+type ServiceStatus struct {
+	IsUp    bool
+	Error   string
+	Latency time.Duration
+	Version string // Only for the database
+}
+
+func (status ServiceStatus) LatencyMilliseconds() int64 {
+	return status.Latency.Milliseconds()
+}
+
+type SystemStatus struct {
+	Database                ServiceStatus
+	DatabaseName            string // database in config/server.yml, e.g. "postgresql"
+	Storage                 ServiceStatus
+	StorageName             string // storage in config/server.yml, e.g. "minio"
+	UserCount               int
+	NoteCount               int
+	BucketCount             int
+	PendingAccountDeletions int
+	StartedAtUTC            time.Time
+	GoVersion               string
+}
+
+const PASSWORD_RESET_REQUIRED_MAIL = `Hello %s,
+
+an administrator of YANAgo has asked you to choose a new password for your account.
+You have been logged out everywhere and can't log in with your old password anymore.
+Open this link to choose a new password:
+
+%s
+
+The link works once and expires in %d minutes. If it has expired, use "Forgot your password?" on the login page.
+`
+
+func isRoleValid(role string) bool {
+	for _, validRole := range ROLES {
+		if role == validRole {
+			return true
+		}
+	}
+	return false
+}
+
+// Lists every account with the number of its notes and the size of its bucket.
+// Accounts whose bucket can't be read are still listed, with IsUsageKnown = false.
+func GetUsersOverview() ([]UserOverview, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetUsersOverview() -> Couldn't get database: %w", err)
+	}
+	query := `SELECT user_.id, user_.email, user_.fullname, user_.email_verified, user_.encryptedpassword != '',
+		user_.created_at_utc, user_.role, user_.is_disabled, COUNT(note.id)
+		FROM user_ LEFT JOIN note ON note.bucketname = user_.id
+		GROUP BY user_.id ORDER BY user_.created_at_utc NULLS FIRST, user_.email`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetUsersOverview() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	var users []UserOverview
+	for rows.Next() {
+		var user UserOverview
+		err = rows.Scan(&user.UserId, &user.Email, &user.FullName, &user.IsEmailVerified, &user.HasPassword,
+			&user.CreatedAtUTC, &user.Role, &user.IsDisabled, &user.NoteCount)
+		if err != nil {
+			return nil, fmt.Errorf("yana.GetUsersOverview() -> Couldn't scan row: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("yana.GetUsersOverview() -> Couldn't read rows: %w", err)
+	}
+
+	for i := range users {
+		usage, err := GetStorageUsage(users[i].UserId)
+		if err != nil {
+			fmt.Println("yana.GetUsersOverview() -> Couldn't get storage usage:", err)
+			continue
+		}
+		users[i].Usage = usage
+		users[i].IsUsageKnown = true
+	}
+	return users, nil
+}
+
+// Disabled accounts are logged out everywhere and can't log in or use their API tokens until they are enabled again
+func SetUserDisabled(userid string, isDisabled bool) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.SetUserDisabled() -> Couldn't get database: %w", err)
+	}
+	transaction, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("yana.SetUserDisabled() -> Couldn't begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	result, err := transaction.Exec(`UPDATE user_ SET is_disabled = $1 WHERE id = $2`, isDisabled, userid)
+	if err != nil {
+		return fmt.Errorf("yana.SetUserDisabled() -> Update query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("yana.SetUserDisabled() -> Couldn't get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return YanaError{Code: UserNotFound, Err: fmt.Errorf("yana.SetUserDisabled() -> Couldn't find user")}
+	}
+	if isDisabled {
+		for _, table := range []string{"session", "remember_token", "pending_login", "magic_link"} {
+			_, err = transaction.Exec(`DELETE FROM `+table+` WHERE user_id = $1`, userid)
+			if err != nil {
+				return fmt.Errorf("yana.SetUserDisabled() -> Couldn't delete from %s: %w", table, err)
+			}
+		}
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return fmt.Errorf("yana.SetUserDisabled() -> Couldn't commit transaction: %w", err)
+	}
+	return nil
+}
+
+func SetUserRole(userid string, role string) error {
+	if !isRoleValid(role) {
+		return fmt.Errorf("yana.SetUserRole() -> %q is not a role", role)
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.SetUserRole() -> Couldn't get database: %w", err)
+	}
+	result, err := db.Exec(`UPDATE user_ SET role = $1 WHERE id = $2`, role, userid)
+	if err != nil {
+		return fmt.Errorf("yana.SetUserRole() -> Update query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("yana.SetUserRole() -> Couldn't get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return YanaError{Code: UserNotFound, Err: fmt.Errorf("yana.SetUserRole() -> Couldn't find user")}
+	}
+	return nil
+}
+
+// Logs the user out everywhere and mails them a reset link.
+// Until they have chosen a new password, logging in with the old one fails with PasswordResetRequired.
+func ForcePasswordReset(userid string) error {
+	user, err := GetUserFromUserID(userid)
+	if err != nil {
+		return fmt.Errorf("yana.ForcePasswordReset() -> Couldn't get user: %w", err)
+	}
+	if !user.HasPassword {
+		return fmt.Errorf("yana.ForcePasswordReset() -> The account has no password")
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.ForcePasswordReset() -> Couldn't get database: %w", err)
+	}
+	transaction, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("yana.ForcePasswordReset() -> Couldn't begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	_, err = transaction.Exec(`UPDATE user_ SET password_reset_required = TRUE WHERE id = $1`, userid)
+	if err != nil {
+		return fmt.Errorf("yana.ForcePasswordReset() -> Update query wasn't succesful: %w", err)
+	}
+	for _, table := range []string{"session", "remember_token", "pending_login"} {
+		_, err = transaction.Exec(`DELETE FROM `+table+` WHERE user_id = $1`, userid)
+		if err != nil {
+			return fmt.Errorf("yana.ForcePasswordReset() -> Couldn't delete from %s: %w", table, err)
+		}
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return fmt.Errorf("yana.ForcePasswordReset() -> Couldn't commit transaction: %w", err)
+	}
+
+	link, err := createPasswordResetLink(db, userid)
+	if err != nil {
+		return fmt.Errorf("yana.ForcePasswordReset() -> Couldn't create link: %w", err)
+	}
+	err = GetMailer().Send(Mail{
+		To:      user.Email,
+		Subject: "Please choose a new YANAgo password",
+		Body:    fmt.Sprintf(PASSWORD_RESET_REQUIRED_MAIL, user.FullName, link, int(PASSWORD_RESET_LIFETIME.Minutes())),
+	})
+	if err != nil {
+		return fmt.Errorf("yana.ForcePasswordReset() -> Couldn't send mail: %w", err)
+	}
+	return nil
+}
+
+// Deletes the account the same way DeleteAccount() does, but without asking for its password
+func AdminDeleteUser(userid string) (AccountDeletionReceipt, error) {
+	user, err := GetUserFromUserID(userid)
+	if err != nil {
+		return AccountDeletionReceipt{}, fmt.Errorf("yana.AdminDeleteUser() -> Couldn't get user: %w", err)
+	}
+	return deleteAccount(user)
+}
+
+// Never returns an error: whatever is down is shown as down
+func GetSystemStatus() SystemStatus {
+	status := SystemStatus{
+		StartedAtUTC: serverStartedAtUTC,
+		GoVersion:    runtime.Version(),
+		DatabaseName: GetServerConfig().Database,
+		StorageName:  GetServerConfig().Storage,
+	}
+	timeoutContext, cancel := context.WithTimeout(yanaContext, SYSTEM_STATUS_TIMEOUT)
+	defer cancel()
+
+	start := time.Now()
+	db, err := getDatabase()
+	if err != nil {
+		status.Database.Error = err.Error()
+	} else {
+		status.Database.Version, err = getRepository().Version(timeoutContext)
+		status.Database.Latency = time.Since(start)
+		if err != nil {
+			status.Database.Error = err.Error()
+		} else {
+			status.Database.IsUp = true
+			query := `SELECT (SELECT COUNT(*) FROM user_), (SELECT COUNT(*) FROM note WHERE state = 'committed'),
+				(SELECT COUNT(*) FROM account_deletion WHERE finished_at_utc IS NULL)`
+			err = db.QueryRowContext(timeoutContext, query).Scan(&status.UserCount, &status.NoteCount, &status.PendingAccountDeletions)
+			if err != nil {
+				fmt.Println("yana.GetSystemStatus() -> Couldn't count rows:", err)
+			}
+		}
+	}
+
+	start = time.Now()
+	store, err := getContentStore()
+	if err != nil {
+		status.Storage.Error = err.Error()
+		return status
+	}
+	// The stores don't take a context, so the timeout is kept here instead
+	type listResult struct {
+		namespaces []string
+		err        error
+	}
+	resultChannel := make(chan listResult, 1)
+	go func() {
+		namespaces, err := store.ListNamespaces()
+		resultChannel <- listResult{namespaces, err}
+	}()
+	select {
+	case result := <-resultChannel:
+		status.Storage.Latency = time.Since(start)
+		if result.err != nil {
+			status.Storage.Error = result.err.Error()
+			return status
+		}
+		status.Storage.IsUp = true
+		status.BucketCount = len(result.namespaces)
+	case <-timeoutContext.Done():
+		status.Storage.Error = "no answer within " + SYSTEM_STATUS_TIMEOUT.String()
+	}
+	return status
+}
+
+// Used by the create-admin command to set up the first admin.
+// An existing account is made an admin and keeps its password.
+// A new account gets a verified email and a bucket, its password is set with the returned link.
+// Returns string: a password reset link, empty if the account existed already
+func BootstrapAdmin(email string, fullname string) (string, error) {
+	userid, err := GetUserIDFromEmail(email)
+	if err != nil {
+		return "", fmt.Errorf("yana.BootstrapAdmin() -> Couldn't look up email: %w", err)
+	}
+	if userid != "" {
+		return "", SetUserRole(userid, ROLE_ADMIN)
+	}
+
+	// Nobody knows this password, the admin chooses their own with the link
+	password, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", fmt.Errorf("yana.BootstrapAdmin() -> Couldn't generate password: %w", err)
+	}
+	userid, err = CreateNewUser(email, fullname, password)
+	if err != nil {
+		return "", fmt.Errorf("yana.BootstrapAdmin() -> Couldn't create user: %w", err)
+	}
+	err = NewBucket(userid)
+	if err != nil {
+		return "", fmt.Errorf("yana.BootstrapAdmin() -> Couldn't create bucket: %w", err)
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.BootstrapAdmin() -> Couldn't get database: %w", err)
+	}
+	_, err = db.Exec(`UPDATE user_ SET role = $1, email_verified = TRUE WHERE id = $2`, ROLE_ADMIN, userid)
+	if err != nil {
+		return "", fmt.Errorf("yana.BootstrapAdmin() -> Update query wasn't succesful: %w", err)
+	}
+	return createPasswordResetLink(db, userid)
+}
@@ -0,0 +1,148 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+ * Personal API tokens let scripts use YANAgo without a browser.
+ * They are sent as "Authorization: Bearer <token>" and only their hash is stored in api_token.
+ * Every token has a list of scopes that decide which routes it can be used for.
+ */
+
+const (
+	SCOPE_NOTES_READ  = "notes:read"
+	SCOPE_NOTES_WRITE = "notes:write"
+	SCOPE_ACCOUNT     = "account"
+)
+
+var API_TOKEN_SCOPES = []string{SCOPE_NOTES_READ, SCOPE_NOTES_WRITE, SCOPE_ACCOUNT}
+
+// Makes the tokens easy to recognize, e.g. for secret scanners
+const API_TOKEN_PREFIX = "yana_"
+
+const API_TOKEN_NAME_MAX_LEN = 100
+
+// Returned by AuthenticateAPIToken() if the token is unknown or expired
+var ErrAPITokenNotFound = errors.New("API token not found or expired")
+
+type APIToken struct {
+	Id            string
+	Name          string
+	Scopes        []string
+	CreatedAtUTC  time.Time
+	ExpiresAtUTC  sql.NullTime // Not valid if the token never expires
+	LastUsedAtUTC sql.NullTime
+}
+
+func (apiToken APIToken) HasScope(scope string) bool {
+	return slices.Contains(apiToken.Scopes, scope)
+}
+
+// Returns string: the token itself, which is only shown to the user once.
+// lifetime == 0 means the token never expires
+func CreateAPIToken(userid string, name string, scopes []string, lifetime time.Duration) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > API_TOKEN_NAME_MAX_LEN {
+		return "", fmt.Errorf("yana.CreateAPIToken() -> The name has to be between 1 and %d characters long", API_TOKEN_NAME_MAX_LEN)
+	}
+	if len(scopes) == 0 {
+		return "", fmt.Errorf("yana.CreateAPIToken() -> A token needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(API_TOKEN_SCOPES, scope) {
+			return "", fmt.Errorf("yana.CreateAPIToken() -> Unknown scope %q", scope)
+		}
+	}
+	token, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", fmt.Errorf("yana.CreateAPIToken() -> Couldn't generate token: %w", err)
+	}
+	token = API_TOKEN_PREFIX + token
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.CreateAPIToken() -> Couldn't get database: %w", err)
+	}
+	now := time.Now().UTC()
+	expiresAt := sql.NullTime{Time: now.Add(lifetime), Valid: lifetime != 0}
+	query := `INSERT INTO api_token (id, user_id, name, tokenhash, scopes, created_at_utc, expires_at_utc) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = db.Exec(query, generateUserID(), userid, name, hashToken(token), strings.Join(scopes, " "), now, expiresAt)
+	if err != nil {
+		return "", fmt.Errorf("yana.CreateAPIToken() -> Insert query wasn't succesful: %w", err)
+	}
+	return token, nil
+}
+
+// Returns the user the token belongs to and the token itself (for its scopes) and marks it as used
+func AuthenticateAPIToken(token string) (User, APIToken, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return User{}, APIToken{}, fmt.Errorf("yana.AuthenticateAPIToken() -> Couldn't get database: %w", err)
+	}
+	var user User
+	var apiToken APIToken
+	var scopes string
+	now := time.Now().UTC()
+	// Looked up first and updated afterwards because SQLite can't return columns of user_ from an UPDATE ... FROM
+	query := `SELECT user_.id, user_.email, user_.fullname, user_.email_verified, user_.role,
+		api_token.id, api_token.name, api_token.scopes, api_token.created_at_utc, api_token.expires_at_utc
+		FROM api_token JOIN user_ ON user_.id = api_token.user_id WHERE api_token.tokenhash = $2 AND NOT user_.is_disabled
+		AND (api_token.expires_at_utc IS NULL OR api_token.expires_at_utc > $1)`
+	err = db.QueryRow(query, now, hashToken(token)).Scan(&user.UserId, &user.Email, &user.FullName, &user.IsEmailVerified, &user.Role,
+		&apiToken.Id, &apiToken.Name, &scopes, &apiToken.CreatedAtUTC, &apiToken.ExpiresAtUTC)
+	if err == sql.ErrNoRows {
+		return User{}, APIToken{}, ErrAPITokenNotFound
+	} else if err != nil {
+		return User{}, APIToken{}, fmt.Errorf("yana.AuthenticateAPIToken() -> Select query wasn't succesful: %w", err)
+	}
+	_, err = db.Exec(`UPDATE api_token SET last_used_at_utc = $1 WHERE id = $2`, now, apiToken.Id)
+	if err != nil {
+		return User{}, APIToken{}, fmt.Errorf("yana.AuthenticateAPIToken() -> Update query wasn't succesful: %w", err)
+	}
+	apiToken.Scopes = strings.Fields(scopes)
+	apiToken.LastUsedAtUTC = sql.NullTime{Time: now, Valid: true}
+	return user, apiToken, nil
+}
+
+func GetAPITokens(userid string) ([]APIToken, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetAPITokens() -> Couldn't get database: %w", err)
+	}
+	query := `SELECT id, name, scopes, created_at_utc, expires_at_utc, last_used_at_utc FROM api_token WHERE user_id = $1 ORDER BY created_at_utc DESC`
+	rows, err := db.Query(query, userid)
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetAPITokens() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	var apiTokens []APIToken
+	for rows.Next() {
+		var apiToken APIToken
+		var scopes string
+		err = rows.Scan(&apiToken.Id, &apiToken.Name, &scopes, &apiToken.CreatedAtUTC, &apiToken.ExpiresAtUTC, &apiToken.LastUsedAtUTC)
+		if err != nil {
+			return nil, fmt.Errorf("yana.GetAPITokens() -> Couldn't scan row: %w", err)
+		}
+		apiToken.Scopes = strings.Fields(scopes)
+		apiTokens = append(apiTokens, apiToken)
+	}
+	return apiTokens, rows.Err()
+}
+
+// userid is checked too, so that users can only revoke their own tokens
+func RevokeAPIToken(userid string, tokenId string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.RevokeAPIToken() -> Couldn't get database: %w", err)
+	}
+	_, err = db.Exec(`DELETE FROM api_token WHERE id = $1 AND user_id = $2`, tokenId, userid)
+	if err != nil {
+		return fmt.Errorf("yana.RevokeAPIToken() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
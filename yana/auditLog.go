@@ -0,0 +1,204 @@
+package yana
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+/*
+ * Security relevant events are appended to the audit_log table, which a trigger keeps from being changed
+ * (see migrations/). Users see the events of their own account in /settings, admins can search and export
+ * every event in /admin/audit-log.
+ * Rows contain user ids but never emails or passwords. Only the IP address and user agent say more about the user,
+ * so deleting an account empties them in the user's events (see startAccountDeletion()), which is the one change
+ * the trigger allows. The rest of the rows is kept after an account is deleted.
+ */
+
+const (
+	AUDIT_LOGIN_SUCCEEDED       = "login.succeeded" // Details: how the user logged in
+	AUDIT_LOGIN_FAILED          = "login.failed"    // Details: why
+	AUDIT_LOGOUT                = "logout"
+	AUDIT_ACCOUNT_CREATED       = "account.created" // Details: the id of the invitation, if one was needed
+	AUDIT_ACCOUNT_DELETED       = "account.deleted" // Details: the receipt id
+	AUDIT_PASSWORD_CHANGED      = "password.changed"
+	AUDIT_PASSWORD_RESET        = "password.reset"
+	AUDIT_EMAIL_CHANGED         = "email.changed"
+	AUDIT_TWO_FACTOR_ENABLED    = "twofactor.enabled"
+	AUDIT_TWO_FACTOR_DISABLED   = "twofactor.disabled"
+	AUDIT_PASSKEY_ADDED         = "passkey.added"
+	AUDIT_PASSKEY_REMOVED       = "passkey.removed"
+	AUDIT_API_TOKEN_CREATED     = "apitoken.created" // Details: the name and scopes of the token
+	AUDIT_API_TOKEN_REVOKED     = "apitoken.revoked"
+	AUDIT_NOTE_DELETED          = "note.deleted"       // Details: the id of the note
+	AUDIT_INVITATION_CREATED    = "invitation.created" // Details: how often and how long it can be used
+	AUDIT_INVITATION_REVOKED    = "invitation.revoked"
+	AUDIT_ADMIN_USER_DISABLED   = "admin.user_disabled"
+	AUDIT_ADMIN_USER_ENABLED    = "admin.user_enabled"
+	AUDIT_ADMIN_ROLE_CHANGED    = "admin.role_changed" // Details: the new role
+	AUDIT_ADMIN_PASSWORD_FORCED = "admin.password_reset_forced"
+	AUDIT_ADMIN_USER_DELETED    = "admin.user_deleted" // Details: the receipt id
+)
+
+// Every event, for the filter in /admin/audit-log
+var AUDIT_EVENTS = []string{
+	AUDIT_LOGIN_SUCCEEDED, AUDIT_LOGIN_FAILED, AUDIT_LOGOUT,
+	AUDIT_ACCOUNT_CREATED, AUDIT_ACCOUNT_DELETED,
+	AUDIT_PASSWORD_CHANGED, AUDIT_PASSWORD_RESET, AUDIT_EMAIL_CHANGED,
+	AUDIT_TWO_FACTOR_ENABLED, AUDIT_TWO_FACTOR_DISABLED, AUDIT_PASSKEY_ADDED, AUDIT_PASSKEY_REMOVED,
+	AUDIT_API_TOKEN_CREATED, AUDIT_API_TOKEN_REVOKED,
+	AUDIT_NOTE_DELETED,
+	AUDIT_INVITATION_CREATED, AUDIT_INVITATION_REVOKED,
+	AUDIT_ADMIN_USER_DISABLED, AUDIT_ADMIN_USER_ENABLED, AUDIT_ADMIN_ROLE_CHANGED, AUDIT_ADMIN_PASSWORD_FORCED, AUDIT_ADMIN_USER_DELETED,
+}
+
+// How many events /settings shows
+const RECENT_AUDIT_EVENTS = 20
+
+// Longer user agents are cut off
+const AUDIT_USER_AGENT_MAX_LEN = 512
+
+type AuditEvent struct {
+	Id        int64     `json:"id"`
+	AtUTC     time.Time `json:"at_utc"`
+	Event     string    `json:"event"`
+	ActorId   string    `json:"actor_id,omitempty"` // Who did it, empty if nobody was logged in (e.g. a failed login)
+	UserId    string    `json:"user_id,omitempty"`  // Whose account it happened to, empty if there is no such account
+	IPAddress string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Details   string    `json:"details,omitempty"`
+}
+
+// Empty fields aren't filtered by. Limit 0 means every event
+type AuditFilter struct {
+	Event     string
+	UserId    string // Matches the actor and the affected user
+	IPAddress string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+func nullIfEmpty(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// Errors are only printed: an action shouldn't fail because it couldn't be logged
+func RecordAuditEvent(event AuditEvent) {
+	db, err := getDatabase()
+	if err != nil {
+		fmt.Println("yana.RecordAuditEvent() -> Couldn't get database:", err)
+		return
+	}
+	if len(event.UserAgent) > AUDIT_USER_AGENT_MAX_LEN {
+		event.UserAgent = event.UserAgent[:AUDIT_USER_AGENT_MAX_LEN]
+	}
+	query := `INSERT INTO audit_log (at_utc, event, actor_id, user_id, ip, useragent, details) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = db.Exec(query, time.Now().UTC(), event.Event, nullIfEmpty(event.ActorId), nullIfEmpty(event.UserId),
+		event.IPAddress, event.UserAgent, event.Details)
+	if err != nil {
+		fmt.Println("yana.RecordAuditEvent() -> Insert query wasn't succesful:", err)
+	}
+}
+
+// Newest events first
+func queryAuditEvents(db *sql.DB, filter AuditFilter) (*sql.Rows, error) {
+	var conditions []string
+	var args []any
+	// Every ? gets its own placeholder, numbered like in the other queries
+	addCondition := func(condition string, conditionArgs ...any) {
+		for _, arg := range conditionArgs {
+			args = append(args, arg)
+			condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(args)), 1)
+		}
+		conditions = append(conditions, condition)
+	}
+	if filter.Event != "" {
+		addCondition("event = ?", filter.Event)
+	}
+	if filter.UserId != "" {
+		addCondition("(CAST(actor_id AS TEXT) = ? OR CAST(user_id AS TEXT) = ?)", filter.UserId, filter.UserId)
+	}
+	if filter.IPAddress != "" {
+		addCondition("ip = ?", filter.IPAddress)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("at_utc >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("at_utc < ?", filter.Until)
+	}
+	query := `SELECT id, at_utc, event, actor_id, user_id, ip, useragent, details FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	return db.Query(query, args...)
+}
+
+func scanAuditEvent(rows *sql.Rows) (AuditEvent, error) {
+	var event AuditEvent
+	var actorId, userId sql.NullString
+	err := rows.Scan(&event.Id, &event.AtUTC, &event.Event, &actorId, &userId, &event.IPAddress, &event.UserAgent, &event.Details)
+	event.ActorId = actorId.String
+	event.UserId = userId.String
+	return event, err
+}
+
+func GetAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetAuditEvents() -> Couldn't get database: %w", err)
+	}
+	rows, err := queryAuditEvents(db, filter)
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetAuditEvents() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	var events []AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("yana.GetAuditEvents() -> Couldn't scan row: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("yana.GetAuditEvents() -> Couldn't read rows: %w", err)
+	}
+	return events, nil
+}
+
+// Writes one JSON object per line, without loading every event into memory first
+func ExportAuditEvents(filter AuditFilter, writer io.Writer) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.ExportAuditEvents() -> Couldn't get database: %w", err)
+	}
+	rows, err := queryAuditEvents(db, filter)
+	if err != nil {
+		return fmt.Errorf("yana.ExportAuditEvents() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	encoder := json.NewEncoder(writer)
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return fmt.Errorf("yana.ExportAuditEvents() -> Couldn't scan row: %w", err)
+		}
+		err = encoder.Encode(event)
+		if err != nil {
+			return fmt.Errorf("yana.ExportAuditEvents() -> Couldn't write event: %w", err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("yana.ExportAuditEvents() -> Couldn't read rows: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package yana
+
+import (
+	"testing"
+)
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	db := newTestSQLite(t)
+	RecordAuditEvent(AuditEvent{Event: AUDIT_LOGIN_SUCCEEDED, ActorId: generateUserID(), IPAddress: "192.0.2.1", UserAgent: "Browser"})
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"changing the event", `UPDATE audit_log SET event = 'logout'`},
+		{"changing the user", `UPDATE audit_log SET actor_id = NULL, ip = '', useragent = ''`},
+		{"changing only the ip", `UPDATE audit_log SET ip = '192.0.2.2'`},
+		{"deleting", `DELETE FROM audit_log`},
+	}
+	for _, test := range tests {
+		_, err := db.Exec(test.query)
+		if err == nil {
+			t.Errorf("%s = nil, want an error", test.name)
+		}
+	}
+	events, err := GetAuditEvents(AuditFilter{})
+	if err != nil || len(events) != 1 || events[0].Event != AUDIT_LOGIN_SUCCEEDED || events[0].IPAddress != "192.0.2.1" {
+		t.Errorf("GetAuditEvents() = %+v, %v, want the event unchanged", events, err)
+	}
+}
+
+// The user's addresses and user agents are removed with the account, the events themselves stay
+func TestAccountDeletionEmptiesAuditLogAddresses(t *testing.T) {
+	newTestSQLite(t)
+	user := newTestUser(t, getRepository())
+	err := NewBucket(user.UserId)
+	if err != nil {
+		t.Fatalf("NewBucket() = %v", err)
+	}
+	adminId := generateUserID()
+	otherId := generateUserID()
+	RecordAuditEvent(AuditEvent{Event: AUDIT_LOGIN_SUCCEEDED, ActorId: user.UserId, UserId: user.UserId, IPAddress: "192.0.2.1", UserAgent: "User"})
+	RecordAuditEvent(AuditEvent{Event: AUDIT_LOGIN_FAILED, UserId: user.UserId, IPAddress: "192.0.2.2", UserAgent: "User"})
+	RecordAuditEvent(AuditEvent{Event: AUDIT_ADMIN_USER_DISABLED, ActorId: adminId, UserId: user.UserId, IPAddress: "198.51.100.1", UserAgent: "Admin"})
+	RecordAuditEvent(AuditEvent{Event: AUDIT_LOGIN_SUCCEEDED, ActorId: otherId, UserId: otherId, IPAddress: "198.51.100.2", UserAgent: "Other"})
+
+	_, err = deleteAccount(user)
+	if err != nil {
+		t.Fatalf("deleteAccount() = %v", err)
+	}
+
+	events, err := GetAuditEvents(AuditFilter{})
+	if err != nil || len(events) != 4 {
+		t.Fatalf("GetAuditEvents() = %d events, %v, want all 4", len(events), err)
+	}
+	for _, event := range events {
+		isUsersOwn := event.ActorId == user.UserId || (event.ActorId == "" && event.UserId == user.UserId)
+		if isUsersOwn && (event.IPAddress != "" || event.UserAgent != "") {
+			t.Errorf("%s of the deleted user = %q, %q, want no ip and user agent", event.Event, event.IPAddress, event.UserAgent)
+		}
+		if !isUsersOwn && (event.IPAddress == "" || event.UserAgent == "") {
+			t.Errorf("%s of %s = %q, %q, want the ip and user agent kept", event.Event, event.ActorId, event.IPAddress, event.UserAgent)
+		}
+	}
+}
+
+func TestGetAuditEventsFilters(t *testing.T) {
+	newTestSQLite(t)
+	userid := generateUserID()
+	adminId := generateUserID()
+	RecordAuditEvent(AuditEvent{Event: AUDIT_LOGIN_SUCCEEDED, ActorId: userid, UserId: userid, IPAddress: "192.0.2.1"})
+	RecordAuditEvent(AuditEvent{Event: AUDIT_ADMIN_USER_DISABLED, ActorId: adminId, UserId: userid, IPAddress: "198.51.100.1"})
+	RecordAuditEvent(AuditEvent{Event: AUDIT_LOGIN_SUCCEEDED, ActorId: adminId, UserId: adminId, IPAddress: "198.51.100.1"})
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   int
+	}{
+		{"nothing", AuditFilter{}, 3},
+		{"user as actor or affected user", AuditFilter{UserId: userid}, 2},
+		{"user and event", AuditFilter{UserId: userid, Event: AUDIT_LOGIN_SUCCEEDED}, 1},
+		{"event, user and ip", AuditFilter{Event: AUDIT_ADMIN_USER_DISABLED, UserId: adminId, IPAddress: "198.51.100.1"}, 1},
+		{"ip", AuditFilter{IPAddress: "198.51.100.1"}, 2},
+		{"limit", AuditFilter{Limit: 1}, 1},
+	}
+	for _, test := range tests {
+		events, err := GetAuditEvents(test.filter)
+		if err != nil || len(events) != test.want {
+			t.Errorf("GetAuditEvents() filtered by %s = %d events, %v, want %d", test.name, len(events), err, test.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package yana
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+ * IsLoginOk() doesn't check passwords itself but asks an Authenticator.
+ * The default one checks the password hash in user_, see ldap.go for the other one.
+ */
+
+// Checks login (usually the email) and password.
+// Wrong logins have to be returned as a YanaError with the code UserNotFound or PasswordsNotEqual
+// so that they count towards the login limits. Every other error means the check itself failed.
+type Authenticator interface {
+	// Returns string: the userid of the local user_ row
+	Authenticate(login string, password string) (string, error)
+}
+
+// Checks the password hash in user_. Works with every database, the name is older than SQLite support
+type PostgreSQLAuthenticator struct{}
+
+var authenticator Authenticator
+var authenticatorOnce sync.Once
+
+// Returns the LDAP authenticator if it's enabled in config/ldap.yml, otherwise the PostgreSQL one
+func GetAuthenticator() Authenticator {
+	authenticatorOnce.Do(func() {
+		authenticator = PostgreSQLAuthenticator{}
+		config, err := readLDAPConfig(LDAP_CONFIG_PATH)
+		if err != nil || !config.Enabled {
+			return
+		}
+		ldapAuthenticator := &LDAPAuthenticator{Config: config}
+		if config.AllowLocalLogins {
+			ldapAuthenticator.Fallback = authenticator
+		}
+		authenticator = ldapAuthenticator
+	})
+	return authenticator
+}
+
+// Returns an error if config/ldap.yml exists but can't be used.
+// GetAuthenticator() would silently use the PostgreSQL passwords instead, so the server shouldn't start with it
+func CheckAuthenticatorConfig() error {
+	_, err := readLDAPConfig(LDAP_CONFIG_PATH)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("yana.CheckAuthenticatorConfig() -> Invalid LDAP config: %w", err)
+	}
+	return nil
+}
+
+func SetAuthenticator(newAuthenticator Authenticator) {
+	authenticatorOnce.Do(func() {})
+	authenticator = newAuthenticator
+}
+
+func (PostgreSQLAuthenticator) Authenticate(email string, password string) (string, error) {
+	userid, storedHash, err := getRepository().GetPasswordHashByEmail(email)
+	if GetYanaErrorCode(err) == UserNotFound || (err == nil && storedHash == "") {
+		return "", YanaError{Code: UserNotFound, Err: fmt.Errorf("yana.PostgreSQLAuthenticator.Authenticate() -> Couldn't find user")}
+	} else if err != nil {
+		return "", YanaError{Code: QueryFailed, Err: fmt.Errorf("yana.PostgreSQLAuthenticator.Authenticate() -> Couldn't execute query: %w", err)}
+	}
+	isMatching, needsRehash, err := verifyPassword(password, storedHash)
+	if err != nil {
+		return "", YanaError{Code: InvalidPasswordHash, Err: fmt.Errorf("yana.PostgreSQLAuthenticator.Authenticate() -> Couldn't verify password: %w", err)}
+	}
+	if !isMatching {
+		return "", YanaError{Code: PasswordsNotEqual, Err: fmt.Errorf("yana.PostgreSQLAuthenticator.Authenticate() -> Passwords are not equal")}
+	}
+	if needsRehash {
+		// The login itself was fine, so a failed rehash is only logged and tried again next time
+		err = updatePasswordHash(userid, password)
+		if err != nil {
+			fmt.Println("yana.PostgreSQLAuthenticator.Authenticate() -> Couldn't rehash password:", err)
+		}
+	}
+	return userid, nil
+}
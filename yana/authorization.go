@@ -0,0 +1,44 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Every note lives in the bucket that is named after the id of its owner,
+// so a note belongs to a user if its bucketname is the user's id.
+func isNoteOwnedBy(userid string, note PostgreSQLNote) bool {
+	return userid != "" && note.Bucketname == userid
+}
+
+// Returns the metadata of a note only if it belongs to userid.
+// Otherwise a YanaError with the code NoteNotFound or NoteForbidden is returned.
+func getOwnedPostgreSQLNote(userid, noteId string) (PostgreSQLNote, error) {
+	// Postgres would complain about the syntax of the UUID instead of just not finding it
+	_, err := uuid.Parse(noteId)
+	if err != nil {
+		return PostgreSQLNote{}, YanaError{Code: NoteNotFound, Err: fmt.Errorf("yana.getOwnedPostgreSQLNote() -> %q is not a valid note id", noteId)}
+	}
+	note, err := getPostgreSQLNoteFromNoteId(noteId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PostgreSQLNote{}, YanaError{Code: NoteNotFound, Err: fmt.Errorf("yana.getOwnedPostgreSQLNote() -> Note %q doesn't exist", noteId)}
+	} else if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.getOwnedPostgreSQLNote() -> Couldn't get note: %w", err)
+	}
+	if !isNoteOwnedBy(userid, note) {
+		return PostgreSQLNote{}, YanaError{Code: NoteForbidden, Err: fmt.Errorf("yana.getOwnedPostgreSQLNote() -> Note %q doesn't belong to the user", noteId)}
+	}
+	return note, nil
+}
+
+// Returns the code of the YanaError inside err, or NoError if there is none
+func GetYanaErrorCode(err error) int {
+	var yanaErr YanaError
+	if errors.As(err, &yanaErr) {
+		return yanaErr.Code
+	}
+	return NoError
+}
@@ -0,0 +1,94 @@
+package yana
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+ * The content of the notes is kept in a ContentStore, the metadata (ids, creation times) in PostgreSQL.
+ * Every user has their own namespace, named after their id, with one object per note, named after the note.
+ * Which store is used is set with storage in config/server.yml:
+ *
+ * - "minio" (default): one bucket per namespace, see config/minio.yml
+ * - "filesystem": one directory per namespace below storagepath, for small installs without MinIO
+ * - "memory": nothing is saved, everything is gone after a restart. Only meant for development and tests
+ */
+
+const (
+	STORAGE_MINIO      = "minio"
+	STORAGE_FILESYSTEM = "filesystem"
+	STORAGE_MEMORY     = "memory"
+)
+
+// Returned by Get() and Stat() if the namespace or the object don't exist
+var ErrContentNotFound = errors.New("content not found")
+
+// Returned by Put() if the namespace doesn't exist
+var ErrNamespaceNotFound = errors.New("namespace not found")
+
+type ContentInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+type ContentStore interface {
+	// Fails if the namespace already exists
+	CreateNamespace(namespace string) error
+	// Only works on empty namespaces. A namespace that doesn't exist counts as deleted
+	DeleteNamespace(namespace string) error
+	ListNamespaces() ([]string, error)
+	// Replaces the object if it already exists
+	Put(namespace, key string, content []byte) error
+	Get(namespace, key string) ([]byte, error)
+	Stat(namespace, key string) (ContentInfo, error)
+	// An object that doesn't exist counts as deleted
+	Delete(namespace, key string) error
+	// Every object in the namespace, sorted by key. Empty if the namespace doesn't exist
+	List(namespace string) ([]ContentInfo, error)
+}
+
+// Creates the store that is set in config/server.yml. Like the database, it's meant to be created once
+// at startup and handed to Init()
+func NewContentStore() (ContentStore, error) {
+	config := GetServerConfig()
+	var store ContentStore
+	var err error
+	switch config.Storage {
+	case STORAGE_MINIO:
+		store, err = newMinIOContentStore(MINIO_CONFIG_PATH)
+	case STORAGE_FILESYSTEM:
+		store, err = NewFilesystemContentStore(config.StoragePath)
+	case STORAGE_MEMORY:
+		store = NewMemoryContentStore()
+	default:
+		err = fmt.Errorf("unknown storage %q", config.Storage)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("yana.NewContentStore() -> Couldn't create %s store: %w", config.Storage, err)
+	}
+	return store, nil
+}
+
+var contentStore ContentStore
+var contentStoreMutex sync.RWMutex
+
+// Set by Init()
+func getContentStore() (ContentStore, error) {
+	contentStoreMutex.RLock()
+	defer contentStoreMutex.RUnlock()
+	if contentStore == nil {
+		return nil, ErrNotInitialized
+	}
+	return contentStore, nil
+}
+
+// Replaces the store from Init(), e.g. with a MemoryContentStore in tests
+func SetContentStore(store ContentStore) {
+	contentStoreMutex.Lock()
+	defer contentStoreMutex.Unlock()
+	contentStore = store
+}
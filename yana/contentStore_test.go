@@ -0,0 +1,288 @@
+package yana
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Every test runs against every ContentStore that works without a server
+var contentStoreTests = []struct {
+	name string
+	test func(t *testing.T, store ContentStore)
+}{
+	{"PutAndGet", testPutAndGet},
+	{"Overwrite", testOverwrite},
+	{"PutWithoutNamespace", testPutWithoutNamespace},
+	{"MissingContent", testMissingContent},
+	{"DeleteMissingKey", testDeleteMissingKey},
+	{"ListIsSorted", testListIsSorted},
+	{"LongAndUnusualNames", testLongAndUnusualNames},
+	{"DeleteNamespace", testDeleteNamespace},
+}
+
+var contentStoreBackends = []struct {
+	name string
+	open func(t *testing.T) ContentStore
+}{
+	{"Memory", func(t *testing.T) ContentStore { return NewMemoryContentStore() }},
+	{"Filesystem", func(t *testing.T) ContentStore { return newTestFilesystemContentStore(t) }},
+}
+
+func TestContentStore(t *testing.T) {
+	for _, backend := range contentStoreBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, storeTest := range contentStoreTests {
+				t.Run(storeTest.name, func(t *testing.T) {
+					storeTest.test(t, backend.open(t))
+				})
+			}
+		})
+	}
+}
+
+func newTestFilesystemContentStore(t *testing.T) *FilesystemContentStore {
+	t.Helper()
+	store, err := NewFilesystemContentStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemContentStore() = %v", err)
+	}
+	return store
+}
+
+func createTestNamespace(t *testing.T, store ContentStore, namespace string) {
+	t.Helper()
+	err := store.CreateNamespace(namespace)
+	if err != nil {
+		t.Fatalf("CreateNamespace(%q) = %v", namespace, err)
+	}
+}
+
+func putTestContent(t *testing.T, store ContentStore, namespace, key, content string) {
+	t.Helper()
+	err := store.Put(namespace, key, []byte(content))
+	if err != nil {
+		t.Fatalf("Put(%q, %q) = %v", namespace, key, err)
+	}
+}
+
+func listTestKeys(t *testing.T, store ContentStore, namespace string) []string {
+	t.Helper()
+	infos, err := store.List(namespace)
+	if err != nil {
+		t.Fatalf("List(%q) = %v", namespace, err)
+	}
+	keys := []string{}
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	return keys
+}
+
+func testPutAndGet(t *testing.T, store ContentStore) {
+	createTestNamespace(t, store, "bucket")
+	putTestContent(t, store, "bucket", "Note", "Hello")
+
+	content, err := store.Get("bucket", "Note")
+	if err != nil || string(content) != "Hello" {
+		t.Errorf("Get() = %q, %v, want \"Hello\"", content, err)
+	}
+	info, err := store.Stat("bucket", "Note")
+	if err != nil || info.Key != "Note" || info.Size != 5 || info.LastModified.IsZero() {
+		t.Errorf("Stat() = %+v, %v, want Note with 5 bytes", info, err)
+	}
+}
+
+func testOverwrite(t *testing.T, store ContentStore) {
+	createTestNamespace(t, store, "bucket")
+	putTestContent(t, store, "bucket", "Note", "A longer first version")
+	putTestContent(t, store, "bucket", "Note", "Second")
+
+	content, err := store.Get("bucket", "Note")
+	if err != nil || string(content) != "Second" {
+		t.Errorf("Get() after overwrite = %q, %v, want \"Second\"", content, err)
+	}
+	keys := listTestKeys(t, store, "bucket")
+	if len(keys) != 1 {
+		t.Errorf("List() after overwrite = %q, want only Note", keys)
+	}
+}
+
+func testPutWithoutNamespace(t *testing.T, store ContentStore) {
+	err := store.Put("missing", "Note", []byte("Hello"))
+	if err != ErrNamespaceNotFound {
+		t.Errorf("Put() into missing namespace = %v, want ErrNamespaceNotFound", err)
+	}
+}
+
+func testMissingContent(t *testing.T, store ContentStore) {
+	createTestNamespace(t, store, "bucket")
+
+	_, err := store.Get("bucket", "Missing")
+	if err != ErrContentNotFound {
+		t.Errorf("Get() of missing key = %v, want ErrContentNotFound", err)
+	}
+	_, err = store.Stat("bucket", "Missing")
+	if err != ErrContentNotFound {
+		t.Errorf("Stat() of missing key = %v, want ErrContentNotFound", err)
+	}
+	_, err = store.Get("missing", "Note")
+	if err != ErrContentNotFound {
+		t.Errorf("Get() from missing namespace = %v, want ErrContentNotFound", err)
+	}
+	infos, err := store.List("missing")
+	if err != nil || len(infos) != 0 {
+		t.Errorf("List() of missing namespace = %+v, %v, want nothing", infos, err)
+	}
+}
+
+func testDeleteMissingKey(t *testing.T, store ContentStore) {
+	createTestNamespace(t, store, "bucket")
+	putTestContent(t, store, "bucket", "Note", "Hello")
+
+	err := store.Delete("bucket", "Note")
+	if err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	_, err = store.Get("bucket", "Note")
+	if err != ErrContentNotFound {
+		t.Errorf("Get() after Delete() = %v, want ErrContentNotFound", err)
+	}
+	err = store.Delete("bucket", "Note")
+	if err != nil {
+		t.Errorf("Delete() of missing key = %v, want nil", err)
+	}
+	err = store.Delete("missing", "Note")
+	if err != nil {
+		t.Errorf("Delete() from missing namespace = %v, want nil", err)
+	}
+}
+
+func testListIsSorted(t *testing.T, store ContentStore) {
+	createTestNamespace(t, store, "bucket")
+	createTestNamespace(t, store, "other")
+	for _, key := range []string{"b", "C", "a", "B"} {
+		putTestContent(t, store, "bucket", key, key)
+	}
+	putTestContent(t, store, "other", "Other", "Other")
+
+	keys := listTestKeys(t, store, "bucket")
+	want := []string{"B", "C", "a", "b"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("List() = %q, want %q", keys, want)
+	}
+}
+
+func testLongAndUnusualNames(t *testing.T, store ContentStore) {
+	createTestNamespace(t, store, "bucket")
+	names := []string{
+		"My Note",
+		"100% ~done~ =)",
+		"Ünïcödé ✓",
+		// The longest names that every store here can save
+		strings.Repeat("a", FILESYSTEM_KEY_MAX_LEN),
+		strings.Repeat("Mixed Case ", FILESYSTEM_KEY_MAX_LEN/11),
+		strings.Repeat("ü", FILESYSTEM_KEY_MAX_LEN/2),
+	}
+	for _, name := range names {
+		if !isFilenameOk(name) {
+			t.Fatalf("isFilenameOk(%q) = false", name)
+		}
+		putTestContent(t, store, "bucket", name, name)
+		content, err := store.Get("bucket", name)
+		if err != nil || string(content) != name {
+			t.Errorf("Get(%q) = %q, %v", name, content, err)
+		}
+	}
+	keys := listTestKeys(t, store, "bucket")
+	if len(keys) != len(names) {
+		t.Fatalf("List() = %q, want %d keys", keys, len(names))
+	}
+	for _, name := range names {
+		isListed := false
+		for _, key := range keys {
+			isListed = isListed || key == name
+		}
+		if !isListed {
+			t.Errorf("List() doesn't contain %q", name)
+		}
+	}
+}
+
+func testDeleteNamespace(t *testing.T, store ContentStore) {
+	createTestNamespace(t, store, "bucket")
+	err := store.CreateNamespace("bucket")
+	if err == nil {
+		t.Errorf("CreateNamespace() of existing namespace = nil, want an error")
+	}
+	putTestContent(t, store, "bucket", "Note", "Hello")
+	err = store.DeleteNamespace("bucket")
+	if err == nil {
+		t.Errorf("DeleteNamespace() of non-empty namespace = nil, want an error")
+	}
+
+	err = store.Delete("bucket", "Note")
+	if err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	err = store.DeleteNamespace("bucket")
+	if err != nil {
+		t.Fatalf("DeleteNamespace() = %v", err)
+	}
+	namespaces, err := store.ListNamespaces()
+	if err != nil || len(namespaces) != 0 {
+		t.Errorf("ListNamespaces() = %q, %v, want nothing", namespaces, err)
+	}
+	err = store.DeleteNamespace("bucket")
+	if err != nil {
+		t.Errorf("DeleteNamespace() of missing namespace = %v, want nil", err)
+	}
+}
+
+// Temporary files of a Put() that crashed stay in the directory but must never be listed
+func TestFilesystemContentStoreSkipsTempFiles(t *testing.T) {
+	store := newTestFilesystemContentStore(t)
+	createTestNamespace(t, store, "bucket")
+	putTestContent(t, store, "bucket", "b", "b")
+	putTestContent(t, store, "bucket", "a", "a")
+	namespacePath, err := store.namespacePath("bucket")
+	if err != nil {
+		t.Fatalf("namespacePath() = %v", err)
+	}
+	err = os.WriteFile(filepath.Join(namespacePath, FILESYSTEM_TEMP_PREFIX+"12345"), []byte("half a note"), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+
+	keys := listTestKeys(t, store, "bucket")
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("List() = %q, want [a b]", keys)
+	}
+	// A note whose name starts like a temporary file is still listed
+	putTestContent(t, store, "bucket", FILESYSTEM_TEMP_PREFIX+"12345", "~")
+	content, err := store.Get("bucket", FILESYSTEM_TEMP_PREFIX+"12345")
+	if err != nil || !bytes.Equal(content, []byte("~")) {
+		t.Errorf("Get() of a note named like a temporary file = %q, %v", content, err)
+	}
+	keys = listTestKeys(t, store, "bucket")
+	if strings.Join(keys, ",") != "a,b,"+FILESYSTEM_TEMP_PREFIX+"12345" {
+		t.Errorf("List() = %q, want [a b ~12345]", keys)
+	}
+}
+
+// Names that don't fit even base32 encoded are refused when they are put instead of being cut off
+func TestFilesystemContentStoreRefusesTooLongKeys(t *testing.T) {
+	store := newTestFilesystemContentStore(t)
+	createTestNamespace(t, store, "bucket")
+	name := strings.Repeat("ü", FILESYSTEM_KEY_MAX_LEN/2+1)
+	err := store.Put("bucket", name, []byte("Hello"))
+	if err == nil {
+		t.Errorf("Put() of a %d byte key = nil, want an error", len(name))
+	}
+	keys := listTestKeys(t, store, "bucket")
+	if len(keys) != 0 {
+		t.Errorf("List() after refused Put() = %q, want nothing", keys)
+	}
+}
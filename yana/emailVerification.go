@@ -0,0 +1,104 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+/*
+ * New accounts start with email_verified = FALSE in user_ and get a mail with a link
+ * to /verify-email. The hash of the link's token and its expiry are stored in user_ too,
+ * so there is only ever one working link per account.
+ * What unverified accounts aren't allowed to do is set in config/server.yml.
+ */
+
+// Returned by VerifyEmail() if the token is unknown, expired or was used already
+var ErrEmailVerificationNotFound = errors.New("verification link not found, expired or already used")
+
+// Used as the Err of a YanaError with the code TooManyAttempts if a new link was asked for too soon
+var ErrVerificationMailTooSoon = errors.New("a verification mail was sent a moment ago")
+
+const EMAIL_VERIFICATION_MAIL = `Hello %s,
+
+welcome to YANAgo! Please confirm your email address by opening this link:
+
+%s
+
+The link expires in %d hours. If it has expired, log in and ask for a new one.
+If you didn't create an account, you can ignore this mail.
+`
+
+// Mails a new verification link to the user and makes every older link stop working.
+// Does nothing if the email is already verified.
+func SendEmailVerification(userid string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.SendEmailVerification() -> Couldn't get database: %w", err)
+	}
+	var email string
+	var fullname string
+	var isVerified bool
+	var sentAt sql.NullTime
+	query := `SELECT email, fullname, email_verified, email_verification_sent_at_utc FROM user_ WHERE id = $1`
+	err = db.QueryRow(query, userid).Scan(&email, &fullname, &isVerified, &sentAt)
+	if err != nil {
+		return fmt.Errorf("yana.SendEmailVerification() -> Select query wasn't succesful: %w", err)
+	}
+	if isVerified {
+		return nil
+	}
+	config := GetServerConfig()
+	now := time.Now().UTC()
+	resendAfter := time.Duration(config.EmailVerificationResendMinutes) * time.Minute
+	if sentAt.Valid && now.Before(sentAt.Time.Add(resendAfter)) {
+		return YanaError{Code: TooManyAttempts, Err: ErrVerificationMailTooSoon}
+	}
+
+	token, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return fmt.Errorf("yana.SendEmailVerification() -> Couldn't generate token: %w", err)
+	}
+	lifetime := time.Duration(config.EmailVerificationLifetimeHours) * time.Hour
+	query = `UPDATE user_ SET email_verification_tokenhash = $1, email_verification_expires_at_utc = $2, email_verification_sent_at_utc = $3 WHERE id = $4`
+	_, err = db.Exec(query, hashToken(token), now.Add(lifetime), now, userid)
+	if err != nil {
+		return fmt.Errorf("yana.SendEmailVerification() -> Update query wasn't succesful: %w", err)
+	}
+
+	link := buildLink("/verify-email?token=" + url.QueryEscape(token))
+	err = GetMailer().Send(Mail{
+		To:      email,
+		Subject: "Confirm your YANAgo email address",
+		Body:    fmt.Sprintf(EMAIL_VERIFICATION_MAIL, fullname, link, config.EmailVerificationLifetimeHours),
+	})
+	if err != nil {
+		return fmt.Errorf("yana.SendEmailVerification() -> Couldn't send mail: %w", err)
+	}
+	return nil
+}
+
+// Marks the email of the token's user as verified.
+// Returns ErrEmailVerificationNotFound if the token can't be used.
+func VerifyEmail(token string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.VerifyEmail() -> Couldn't get database: %w", err)
+	}
+	query := `UPDATE user_ SET email_verified = TRUE, email_verification_tokenhash = NULL, email_verification_expires_at_utc = NULL
+		WHERE email_verification_tokenhash = $1 AND email_verification_expires_at_utc > $2`
+	result, err := db.Exec(query, hashToken(token), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("yana.VerifyEmail() -> Update query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("yana.VerifyEmail() -> Couldn't get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrEmailVerificationNotFound
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package yana
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+const SECRET_KEY_LEN = 32 // AES-256
+
+var ErrNoSecretKey = errors.New("secretkey in config/server.yml is missing or isn't 32 base64 encoded bytes")
+
+func getSecretKey() ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(GetServerConfig().SecretKey)
+	if err != nil || len(key) != SECRET_KEY_LEN {
+		return nil, ErrNoSecretKey
+	}
+	return key, nil
+}
+
+func newSecretCipher() (cipher.AEAD, error) {
+	key, err := getSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypts secrets that have to be stored in PostgreSQL but can't be hashed
+// because they are needed in plaintext again (like TOTP secrets).
+// Returns base64(nonce + ciphertext)
+func encryptSecret(plaintext string) (string, error) {
+	aead, err := newSecretCipher()
+	if err != nil {
+		return "", fmt.Errorf("yana.encryptSecret() -> Couldn't create cipher: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("yana.encryptSecret() -> Couldn't generate nonce: %w", err)
+	}
+	ciphertext := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func decryptSecret(encoded string) (string, error) {
+	aead, err := newSecretCipher()
+	if err != nil {
+		return "", fmt.Errorf("yana.decryptSecret() -> Couldn't create cipher: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("yana.decryptSecret() -> Couldn't decode secret: %w", err)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return "", fmt.Errorf("yana.decryptSecret() -> Secret is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("yana.decryptSecret() -> Couldn't decrypt secret (was secretkey changed?): %w", err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,244 @@
+package yana
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Most filesystems don't allow longer file names
+const FILESYSTEM_NAME_MAX_LEN = 255
+
+// In bytes. Longer keys can't be saved in a FilesystemContentStore, even though other stores take up to FILENAME_MAX_LEN
+const FILESYSTEM_KEY_MAX_LEN = (FILESYSTEM_NAME_MAX_LEN - len(FILESYSTEM_BASE32_PREFIX)) * 5 / 8
+
+// Temporary files start with this. It's escaped in every name, so no object can be mistaken for one
+const FILESYSTEM_TEMP_PREFIX = "~"
+
+// Names that would be too long escaped are saved as this followed by their lower case base32 instead.
+// '=' is escaped in every other name as well
+const FILESYSTEM_BASE32_PREFIX = "="
+
+var filesystemBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// A namespace is a directory below root, a key is a file in it.
+// Files are written to a temporary file first and then renamed, so a crash never leaves half a note behind.
+type FilesystemContentStore struct {
+	root string
+}
+
+// Creates root if it doesn't exist yet
+func NewFilesystemContentStore(root string) (*FilesystemContentStore, error) {
+	err := os.MkdirAll(root, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("yana.NewFilesystemContentStore() -> Couldn't create %q: %w", root, err)
+	}
+	return &FilesystemContentStore{root: root}, nil
+}
+
+// Note names can contain every character except / and NUL (see isFilenameOk()), and some filesystems
+// don't tell upper and lower case apart. Everything but lower case letters, digits, '.', '-' and '_'
+// is escaped like in URLs, e.g. "My Note" becomes "%4Dy%20%4Eote".
+// That can make a name three times longer, so if it doesn't fit into FILESYSTEM_NAME_MAX_LEN
+// it's base32 encoded instead, which only makes it 1.6 times longer. FILESYSTEM_KEY_MAX_LEN is the longest name that still fits.
+func escapeFilesystemName(name string) (string, error) {
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("yana.escapeFilesystemName() -> %q isn't allowed", name)
+	}
+	var escaped strings.Builder
+	for i := 0; i < len(name); i++ {
+		character := name[i]
+		isAllowed := (character >= 'a' && character <= 'z') || (character >= '0' && character <= '9') ||
+			character == '.' || character == '-' || character == '_'
+		if isAllowed {
+			escaped.WriteByte(character)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", character)
+		}
+	}
+	if escaped.Len() <= FILESYSTEM_NAME_MAX_LEN {
+		return escaped.String(), nil
+	}
+	encoded := FILESYSTEM_BASE32_PREFIX + strings.ToLower(filesystemBase32.EncodeToString([]byte(name)))
+	if len(encoded) > FILESYSTEM_NAME_MAX_LEN {
+		return "", fmt.Errorf("yana.escapeFilesystemName() -> Name is too long for the filesystem, it can be at most %d bytes long", FILESYSTEM_KEY_MAX_LEN)
+	}
+	return encoded, nil
+}
+
+func unescapeFilesystemName(name string) (string, error) {
+	encoded, isEncoded := strings.CutPrefix(name, FILESYSTEM_BASE32_PREFIX)
+	if isEncoded {
+		decoded, err := filesystemBase32.DecodeString(strings.ToUpper(encoded))
+		return string(decoded), err
+	}
+	return url.PathUnescape(name)
+}
+
+func (store *FilesystemContentStore) namespacePath(namespace string) (string, error) {
+	escapedNamespace, err := escapeFilesystemName(namespace)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(store.root, escapedNamespace), nil
+}
+
+func (store *FilesystemContentStore) objectPath(namespace, key string) (string, error) {
+	namespacePath, err := store.namespacePath(namespace)
+	if err != nil {
+		return "", err
+	}
+	escapedKey, err := escapeFilesystemName(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(namespacePath, escapedKey), nil
+}
+
+func (store *FilesystemContentStore) CreateNamespace(namespace string) error {
+	namespacePath, err := store.namespacePath(namespace)
+	if err != nil {
+		return fmt.Errorf("yana.FilesystemContentStore.CreateNamespace() -> %w", err)
+	}
+	err = os.Mkdir(namespacePath, 0o700)
+	if err != nil {
+		return fmt.Errorf("yana.FilesystemContentStore.CreateNamespace() -> Couldn't create directory: %w", err)
+	}
+	return nil
+}
+
+func (store *FilesystemContentStore) DeleteNamespace(namespace string) error {
+	namespacePath, err := store.namespacePath(namespace)
+	if err != nil {
+		return fmt.Errorf("yana.FilesystemContentStore.DeleteNamespace() -> %w", err)
+	}
+	err = os.Remove(namespacePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("yana.FilesystemContentStore.DeleteNamespace() -> Couldn't remove directory: %w", err)
+	}
+	return nil
+}
+
+func (store *FilesystemContentStore) ListNamespaces() ([]string, error) {
+	entries, err := os.ReadDir(store.root)
+	if err != nil {
+		return nil, fmt.Errorf("yana.FilesystemContentStore.ListNamespaces() -> Couldn't read directory: %w", err)
+	}
+	var namespaces []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		namespace, err := unescapeFilesystemName(entry.Name())
+		if err != nil {
+			continue // Not made by this store
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, nil
+}
+
+func (store *FilesystemContentStore) Put(namespace, key string, content []byte) error {
+	path, err := store.objectPath(namespace, key)
+	if err != nil {
+		return fmt.Errorf("yana.FilesystemContentStore.Put() -> %w", err)
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(path), FILESYSTEM_TEMP_PREFIX+"*")
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNamespaceNotFound
+	} else if err != nil {
+		return fmt.Errorf("yana.FilesystemContentStore.Put() -> Couldn't create temporary file: %w", err)
+	}
+	defer os.Remove(tempFile.Name()) // Only does something if the rename didn't happen
+	_, err = tempFile.Write(content)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	closeErr := tempFile.Close()
+	if err != nil || closeErr != nil {
+		return fmt.Errorf("yana.FilesystemContentStore.Put() -> Couldn't write temporary file: %w", errors.Join(err, closeErr))
+	}
+	err = os.Rename(tempFile.Name(), path)
+	if err != nil {
+		return fmt.Errorf("yana.FilesystemContentStore.Put() -> Couldn't rename temporary file: %w", err)
+	}
+	return nil
+}
+
+func (store *FilesystemContentStore) Get(namespace, key string) ([]byte, error) {
+	path, err := store.objectPath(namespace, key)
+	if err != nil {
+		return nil, fmt.Errorf("yana.FilesystemContentStore.Get() -> %w", err)
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrContentNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("yana.FilesystemContentStore.Get() -> Couldn't read file: %w", err)
+	}
+	return content, nil
+}
+
+func (store *FilesystemContentStore) Stat(namespace, key string) (ContentInfo, error) {
+	path, err := store.objectPath(namespace, key)
+	if err != nil {
+		return ContentInfo{}, fmt.Errorf("yana.FilesystemContentStore.Stat() -> %w", err)
+	}
+	fileInfo, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ContentInfo{}, ErrContentNotFound
+	} else if err != nil {
+		return ContentInfo{}, fmt.Errorf("yana.FilesystemContentStore.Stat() -> Couldn't stat file: %w", err)
+	}
+	return ContentInfo{Key: key, Size: fileInfo.Size(), LastModified: fileInfo.ModTime().UTC()}, nil
+}
+
+func (store *FilesystemContentStore) Delete(namespace, key string) error {
+	path, err := store.objectPath(namespace, key)
+	if err != nil {
+		return fmt.Errorf("yana.FilesystemContentStore.Delete() -> %w", err)
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("yana.FilesystemContentStore.Delete() -> Couldn't remove file: %w", err)
+	}
+	return nil
+}
+
+func (store *FilesystemContentStore) List(namespace string) ([]ContentInfo, error) {
+	namespacePath, err := store.namespacePath(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("yana.FilesystemContentStore.List() -> %w", err)
+	}
+	entries, err := os.ReadDir(namespacePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("yana.FilesystemContentStore.List() -> Couldn't read directory: %w", err)
+	}
+	var infos []ContentInfo
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), FILESYSTEM_TEMP_PREFIX) {
+			continue
+		}
+		key, err := unescapeFilesystemName(entry.Name())
+		if err != nil {
+			continue // Not made by this store
+		}
+		fileInfo, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // Deleted while listing
+		} else if err != nil {
+			return nil, fmt.Errorf("yana.FilesystemContentStore.List() -> Couldn't stat file: %w", err)
+		}
+		infos = append(infos, ContentInfo{Key: key, Size: fileInfo.Size(), LastModified: fileInfo.ModTime().UTC()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
@@ -0,0 +1,173 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ * How /register works is set with registration in config/server.yml:
+ * everyone can register, nobody can, or only people with an invitation code.
+ * Invitation codes are made by admins and (if userscaninvite is on) by every user in /invitations.
+ * Like every other token, only the hash of a code is saved, so it is only shown once.
+ */
+
+const (
+	REGISTRATION_OPEN        = "open"
+	REGISTRATION_CLOSED      = "closed"
+	REGISTRATION_INVITE_ONLY = "invite-only"
+)
+
+// In bytes, before encoding. Shorter than the other tokens because people type them in
+const INVITATION_CODE_LEN = 12
+
+// What users can choose from in /invitations
+var INVITATION_MAX_USES = []int{1, 5, 10, 25}
+var INVITATION_LIFETIME_DAYS = []int{1, 7, 30}
+
+// Returned by ReserveInvitation() if the code is unknown, has expired or was used up
+var ErrInvitationNotFound = errors.New("invitation not found, expired or used up")
+
+type Invitation struct {
+	Id           string
+	CreatedBy    string
+	MaxUses      int
+	Uses         int
+	CreatedAtUTC time.Time
+	ExpiresAtUTC time.Time
+}
+
+func (invitation Invitation) IsUsable() bool {
+	return invitation.Uses < invitation.MaxUses && time.Now().UTC().Before(invitation.ExpiresAtUTC)
+}
+
+func GetRegistrationMode() string {
+	switch mode := GetServerConfig().Registration; mode {
+	case REGISTRATION_CLOSED, REGISTRATION_INVITE_ONLY:
+		return mode
+	}
+	return REGISTRATION_OPEN
+}
+
+// Whether user may create invitation codes right now
+func CanInvite(user User) bool {
+	if GetRegistrationMode() != REGISTRATION_INVITE_ONLY {
+		return false
+	}
+	return user.IsAdmin() || GetServerConfig().UsersCanInvite
+}
+
+func isIntInList(value int, list []int) bool {
+	for _, listValue := range list {
+		if value == listValue {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns string: the code, which isn't stored anywhere and has to be shown to the user now
+func CreateInvitation(userid string, maxUses int, lifetimeDays int) (string, error) {
+	if !isIntInList(maxUses, INVITATION_MAX_USES) || !isIntInList(lifetimeDays, INVITATION_LIFETIME_DAYS) {
+		return "", fmt.Errorf("yana.CreateInvitation() -> %d uses or %d days aren't allowed", maxUses, lifetimeDays)
+	}
+	code, err := generateToken(INVITATION_CODE_LEN)
+	if err != nil {
+		return "", fmt.Errorf("yana.CreateInvitation() -> Couldn't generate code: %w", err)
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.CreateInvitation() -> Couldn't get database: %w", err)
+	}
+	now := time.Now().UTC()
+	query := `INSERT INTO invitation (id, codehash, user_id, max_uses, uses, created_at_utc, expires_at_utc) VALUES ($1, $2, $3, $4, 0, $5, $6)`
+	_, err = db.Exec(query, generateUserID(), hashToken(code), userid, maxUses, now, now.AddDate(0, 0, lifetimeDays))
+	if err != nil {
+		return "", fmt.Errorf("yana.CreateInvitation() -> Insert query wasn't succesful: %w", err)
+	}
+	return code, nil
+}
+
+// The link that can be sent to the invited people, /register fills in the code from it
+func BuildInvitationLink(code string) string {
+	return buildLink("/register?invite=" + url.QueryEscape(code))
+}
+
+// Takes one use of the invitation before the account is created, so that two registrations
+// can't both use its last use. If the registration fails, ReleaseInvitation() gives it back.
+// Returns string: the id of the invitation
+func ReserveInvitation(code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return "", ErrInvitationNotFound
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.ReserveInvitation() -> Couldn't get database: %w", err)
+	}
+	var invitationId string
+	query := `UPDATE invitation SET uses = uses + 1 WHERE codehash = $1 AND uses < max_uses AND expires_at_utc > $2 RETURNING id`
+	err = db.QueryRow(query, hashToken(code), time.Now().UTC()).Scan(&invitationId)
+	if err == sql.ErrNoRows {
+		return "", ErrInvitationNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("yana.ReserveInvitation() -> Update query wasn't succesful: %w", err)
+	}
+	return invitationId, nil
+}
+
+func ReleaseInvitation(invitationId string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.ReleaseInvitation() -> Couldn't get database: %w", err)
+	}
+	_, err = db.Exec(`UPDATE invitation SET uses = uses - 1 WHERE id = $1 AND uses > 0`, invitationId)
+	if err != nil {
+		return fmt.Errorf("yana.ReleaseInvitation() -> Update query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+// Every invitation userid has made, newest first
+func GetInvitations(userid string) ([]Invitation, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetInvitations() -> Couldn't get database: %w", err)
+	}
+	query := `SELECT id, user_id, max_uses, uses, created_at_utc, expires_at_utc FROM invitation WHERE user_id = $1 ORDER BY created_at_utc DESC`
+	rows, err := db.Query(query, userid)
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetInvitations() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	var invitations []Invitation
+	for rows.Next() {
+		var invitation Invitation
+		err = rows.Scan(&invitation.Id, &invitation.CreatedBy, &invitation.MaxUses, &invitation.Uses, &invitation.CreatedAtUTC, &invitation.ExpiresAtUTC)
+		if err != nil {
+			return nil, fmt.Errorf("yana.GetInvitations() -> Couldn't scan row: %w", err)
+		}
+		invitations = append(invitations, invitation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("yana.GetInvitations() -> Couldn't read rows: %w", err)
+	}
+	return invitations, nil
+}
+
+// Only the user that made the invitation can revoke it
+func RevokeInvitation(userid string, invitationId string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.RevokeInvitation() -> Couldn't get database: %w", err)
+	}
+	_, err = db.Exec(`DELETE FROM invitation WHERE id = $1 AND user_id = $2`, invitationId, userid)
+	if err != nil {
+		return fmt.Errorf("yana.RevokeInvitation() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
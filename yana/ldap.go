@@ -0,0 +1,158 @@
+package yana
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"gopkg.in/yaml.v3"
+)
+
+const LDAP_CONFIG_PATH = "config/ldap.yml"
+
+// Replaced with the escaped login in UserDNTemplate and UserFilter
+const LDAP_LOGIN_PLACEHOLDER = "{login}"
+
+type LDAPConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	URL                string `yaml:"url"` // ldap:// or ldaps://
+	StartTLS           bool   `yaml:"starttls"`
+	InsecureSkipVerify bool   `yaml:"insecureskipverify"`
+	// If BindDN is set, the user is searched with this account first.
+	// Otherwise UserDNTemplate is used to build the DN of the user directly.
+	BindDN           string `yaml:"binddn"`
+	BindPassword     string `yaml:"bindpassword"`
+	UserDNTemplate   string `yaml:"userdntemplate"`
+	BaseDN           string `yaml:"basedn"`
+	UserFilter       string `yaml:"userfilter"`
+	EmailAttribute   string `yaml:"emailattribute"`
+	NameAttribute    string `yaml:"nameattribute"`
+	AllowLocalLogins bool   `yaml:"allowlocallogins"` // Try the PostgreSQL password if the user isn't in the directory, needs BindDN
+}
+
+// Binds as the user to check their password, then maps the directory entry to a user_ row by email.
+// The row and the bucket are created on the first login.
+type LDAPAuthenticator struct {
+	Config   LDAPConfig
+	Fallback Authenticator // Used if the user isn't found in the directory, can be nil
+}
+
+func readLDAPConfig(path string) (LDAPConfig, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return LDAPConfig{}, err
+	}
+
+	config := LDAPConfig{}
+	err = yaml.Unmarshal(file, &config)
+	if err != nil {
+		return LDAPConfig{}, fmt.Errorf("in file %q: %w", path, err)
+	}
+	if config.Enabled && config.AllowLocalLogins && config.BindDN == "" {
+		return LDAPConfig{}, fmt.Errorf("in file %q: allowlocallogins needs binddn to tell unknown users from wrong passwords", path)
+	}
+	return config, nil
+}
+
+func (ldapAuthenticator *LDAPAuthenticator) connect() (*ldap.Conn, error) {
+	config := ldapAuthenticator.Config
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	conn, err := ldap.DialURL(config.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't connect to %q: %w", config.URL, err)
+	}
+	if config.StartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS wasn't succesful: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// Returns the entry of login with the email and name attributes or nil if there is none
+func (ldapAuthenticator *LDAPAuthenticator) searchUser(conn *ldap.Conn, login string) (*ldap.Entry, error) {
+	config := ldapAuthenticator.Config
+	filter := strings.ReplaceAll(config.UserFilter, LDAP_LOGIN_PLACEHOLDER, ldap.EscapeFilter(login))
+	request := ldap.NewSearchRequest(config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, 0, false, filter, []string{config.EmailAttribute, config.NameAttribute}, nil)
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, fmt.Errorf("Search wasn't succesful: %w", err)
+	}
+	// More than one entry means the filter is too loose, so nobody is logged in rather than the wrong person
+	if len(result.Entries) != 1 {
+		return nil, nil
+	}
+	return result.Entries[0], nil
+}
+
+func (ldapAuthenticator *LDAPAuthenticator) Authenticate(login string, password string) (string, error) {
+	userid, err := ldapAuthenticator.authenticate(login, password)
+	if GetYanaErrorCode(err) == UserNotFound && ldapAuthenticator.Fallback != nil {
+		return ldapAuthenticator.Fallback.Authenticate(login, password)
+	}
+	return userid, err
+}
+
+func (ldapAuthenticator *LDAPAuthenticator) authenticate(login string, password string) (string, error) {
+	// An empty password would be an unauthenticated bind, which most servers accept
+	if login == "" || password == "" {
+		return "", YanaError{Code: PasswordsNotEqual, Err: fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> Empty login or password")}
+	}
+	config := ldapAuthenticator.Config
+	conn, err := ldapAuthenticator.connect()
+	if err != nil {
+		return "", fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> %w", err)
+	}
+	defer conn.Close()
+
+	var entry *ldap.Entry
+	userDN := strings.ReplaceAll(config.UserDNTemplate, LDAP_LOGIN_PLACEHOLDER, ldap.EscapeDN(login))
+	if config.BindDN != "" {
+		err = conn.Bind(config.BindDN, config.BindPassword)
+		if err != nil {
+			return "", fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> Couldn't bind with binddn: %w", err)
+		}
+		entry, err = ldapAuthenticator.searchUser(conn, login)
+		if err != nil {
+			return "", fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> %w", err)
+		}
+		if entry == nil {
+			return "", YanaError{Code: UserNotFound, Err: fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> Couldn't find user")}
+		}
+		userDN = entry.DN
+	}
+
+	err = conn.Bind(userDN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return "", YanaError{Code: PasswordsNotEqual, Err: fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> Wrong password or unknown user")}
+	} else if err != nil {
+		return "", fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> Couldn't bind as user: %w", err)
+	}
+	if entry == nil {
+		// Without binddn, the attributes are read as the user
+		entry, err = ldapAuthenticator.searchUser(conn, login)
+		if err != nil {
+			return "", fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> %w", err)
+		}
+		if entry == nil {
+			return "", YanaError{Code: UserNotFound, Err: fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> Couldn't read own entry")}
+		}
+	}
+
+	email := entry.GetAttributeValue(config.EmailAttribute)
+	if email == "" {
+		return "", fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> The user has no %s attribute", config.EmailAttribute)
+	}
+	userid, err := FindOrProvisionExternalUser(email, entry.GetAttributeValue(config.NameAttribute))
+	if err == ErrExternalSignupClosed {
+		return "", YanaError{Code: UserNotFound, Err: fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> No account for %s: %w", email, err)}
+	} else if err != nil {
+		return "", fmt.Errorf("yana.LDAPAuthenticator.Authenticate() -> Couldn't map user to user_: %w", err)
+	}
+	return userid, nil
+}
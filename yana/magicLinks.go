@@ -0,0 +1,117 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+/*
+ * Passwordless login with a link that is mailed to the user.
+ * The link only works in the browser that asked for it: that browser gets a second token in a cookie
+ * and the hashes of both tokens are saved together in the magic_link table.
+ * So someone who only sees the mail (or the link in a log) can't log in with it.
+ */
+
+const MAGIC_LINK_LIFETIME = 15 * time.Minute
+
+// Returned by UseMagicLink() if the link is unknown, expired, used already or opened in a different browser
+var ErrMagicLinkNotFound = errors.New("login link not found, expired, already used or opened in a different browser")
+
+const MAGIC_LINK_MAIL = `Hello %s,
+
+open this link to log into YANAgo:
+
+%s
+
+The link works once, only in the browser you asked for it in, and expires in %d minutes.
+If you didn't ask for it, you can ignore this mail.
+`
+
+// Mails a login link to email if there is an account with it.
+// Returns string: the token for the browser cookie. It's returned for unknown addresses too,
+// so the response doesn't reveal who has an account. For the same reason, a mail that can't be sent is only logged.
+// Every call counts towards the limit of ipAddress, see throttle.go
+func RequestMagicLink(email string, isRemembered bool, ipAddress string) (string, error) {
+	browserToken, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", fmt.Errorf("yana.RequestMagicLink() -> Couldn't generate browser token: %w", err)
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return "", YanaError{Code: ConnectionFailed, Err: fmt.Errorf("yana.RequestMagicLink() -> Couldn't get database: %w", err)}
+	}
+	throttleKey := magicLinkThrottleKey(ipAddress)
+	err = checkThrottle(db, throttleKey)
+	if _, isLocked := err.(LockedError); isLocked {
+		return "", YanaError{Code: TooManyAttempts, Err: err}
+	} else if err != nil {
+		return "", err
+	}
+	err = recordThrottleFailure(db, throttleKey, GetServerConfig().MagicLinkMaxMailsPerIP)
+	if err != nil {
+		fmt.Println("yana.RequestMagicLink() -> Couldn't record attempt:", err)
+	}
+
+	var userid string
+	var fullname string
+	err = db.QueryRow(`SELECT id, fullname FROM user_ WHERE email = $1`, email).Scan(&userid, &fullname)
+	if err == sql.ErrNoRows {
+		return browserToken, nil
+	} else if err != nil {
+		return "", YanaError{Code: QueryFailed, Err: fmt.Errorf("yana.RequestMagicLink() -> Select query wasn't succesful: %w", err)}
+	}
+
+	token, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", fmt.Errorf("yana.RequestMagicLink() -> Couldn't generate token: %w", err)
+	}
+	now := time.Now().UTC()
+	_, err = db.Exec(`DELETE FROM magic_link WHERE expires_at_utc < $1`, now)
+	if err != nil {
+		fmt.Println("yana.RequestMagicLink() -> Couldn't delete expired links:", err)
+	}
+	query := `INSERT INTO magic_link (tokenhash, browserhash, user_id, is_remembered, expires_at_utc) VALUES ($1, $2, $3, $4, $5)`
+	_, err = db.Exec(query, hashToken(token), hashToken(browserToken), userid, isRemembered, now.Add(MAGIC_LINK_LIFETIME))
+	if err != nil {
+		return "", fmt.Errorf("yana.RequestMagicLink() -> Insert query wasn't succesful: %w", err)
+	}
+
+	link := buildLink("/magic-link?token=" + url.QueryEscape(token))
+	err = GetMailer().Send(Mail{
+		To:      email,
+		Subject: "Your YANAgo login link",
+		Body:    fmt.Sprintf(MAGIC_LINK_MAIL, fullname, link, int(MAGIC_LINK_LIFETIME.Minutes())),
+	})
+	if err != nil {
+		// An error would tell that there is an account with this email, so it looks the same as an unknown one
+		fmt.Println("yana.RequestMagicLink() -> Couldn't send mail:", err)
+	}
+	return browserToken, nil
+}
+
+// Uses up the link. browserToken is the one from the cookie of the browser that opened it.
+// Since the link was mailed to the user, their email counts as verified afterwards.
+// Returns the userid and whether "Remember me" was checked
+func UseMagicLink(token string, browserToken string) (string, bool, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return "", false, fmt.Errorf("yana.UseMagicLink() -> Couldn't get database: %w", err)
+	}
+	var userid string
+	var isRemembered bool
+	query := `DELETE FROM magic_link WHERE tokenhash = $1 AND browserhash = $2 AND expires_at_utc > $3 RETURNING user_id, is_remembered`
+	err = db.QueryRow(query, hashToken(token), hashToken(browserToken), time.Now().UTC()).Scan(&userid, &isRemembered)
+	if err == sql.ErrNoRows {
+		return "", false, ErrMagicLinkNotFound
+	} else if err != nil {
+		return "", false, fmt.Errorf("yana.UseMagicLink() -> Delete query wasn't succesful: %w", err)
+	}
+	_, err = db.Exec(`UPDATE user_ SET email_verified = TRUE WHERE id = $1`, userid)
+	if err != nil {
+		fmt.Println("yana.UseMagicLink() -> Couldn't mark email as verified:", err)
+	}
+	return userid, isRemembered, nil
+}
@@ -0,0 +1,113 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func newMagicLinkTestUser(t *testing.T, email string) string {
+	t.Helper()
+	user := User{UserId: generateUserID(), Email: email, FullName: "Magic User", CreatedAtUTC: sql.NullTime{Time: time.Now().UTC(), Valid: true}}
+	err := getRepository().InsertUser(user, "")
+	if err != nil {
+		t.Fatalf("InsertUser() = %v", err)
+	}
+	return user.UserId
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	newTestSQLite(t)
+	mailPath := useTestFileMailer(t)
+	userid := newMagicLinkTestUser(t, "magic@example.com")
+
+	browserToken, err := RequestMagicLink("magic@example.com", true, "192.0.2.1")
+	if err != nil || browserToken == "" {
+		t.Fatalf("RequestMagicLink() = %q, %v", browserToken, err)
+	}
+	token := readTokenFromMails(t, mailPath, "magic@example.com", "/magic-link")
+
+	// Someone who only has the link can't use it, and trying doesn't use it up
+	otherBrowserToken, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		t.Fatalf("generateToken() = %v", err)
+	}
+	_, _, err = UseMagicLink(token, otherBrowserToken)
+	if err != ErrMagicLinkNotFound {
+		t.Errorf("UseMagicLink() in another browser = %v, want ErrMagicLinkNotFound", err)
+	}
+	_, _, err = UseMagicLink(token, "")
+	if err != ErrMagicLinkNotFound {
+		t.Errorf("UseMagicLink() without browser cookie = %v, want ErrMagicLinkNotFound", err)
+	}
+
+	loggedInUserid, isRemembered, err := UseMagicLink(token, browserToken)
+	if err != nil || loggedInUserid != userid || !isRemembered {
+		t.Fatalf("UseMagicLink() = %q, %v, %v, want %q and remembered", loggedInUserid, isRemembered, err, userid)
+	}
+	user, err := GetUserFromUserID(userid)
+	if err != nil || !user.IsEmailVerified {
+		t.Errorf("GetUserFromUserID() after UseMagicLink() = %+v, %v, want a verified email", user, err)
+	}
+
+	// The link only works once
+	_, _, err = UseMagicLink(token, browserToken)
+	if err != ErrMagicLinkNotFound {
+		t.Errorf("UseMagicLink() with used link = %v, want ErrMagicLinkNotFound", err)
+	}
+}
+
+func TestMagicLinkExpires(t *testing.T) {
+	db := newTestSQLite(t)
+	mailPath := useTestFileMailer(t)
+	newMagicLinkTestUser(t, "magic@example.com")
+	browserToken, err := RequestMagicLink("magic@example.com", false, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("RequestMagicLink() = %v", err)
+	}
+	token := readTokenFromMails(t, mailPath, "magic@example.com", "/magic-link")
+
+	_, err = db.Exec(`UPDATE magic_link SET expires_at_utc = $1`, time.Now().UTC().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Update query = %v", err)
+	}
+	_, _, err = UseMagicLink(token, browserToken)
+	if err != ErrMagicLinkNotFound {
+		t.Errorf("UseMagicLink() with expired link = %v, want ErrMagicLinkNotFound", err)
+	}
+}
+
+// Unknown addresses get a browser token too, so the response doesn't reveal who has an account
+func TestMagicLinkOfUnknownEmail(t *testing.T) {
+	newTestSQLite(t)
+	mailPath := useTestFileMailer(t)
+	browserToken, err := RequestMagicLink("nobody@example.com", false, "192.0.2.1")
+	if err != nil || browserToken == "" {
+		t.Errorf("RequestMagicLink() of unknown email = %q, %v, want a browser token", browserToken, err)
+	}
+	_, err = os.Stat(mailPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("Stat() of mail file = %v, want no mail", err)
+	}
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(mail Mail) error {
+	return errors.New("the mail server is down")
+}
+
+// A mail that can't be sent must look the same as an unknown address
+func TestMagicLinkWithFailingMailer(t *testing.T) {
+	newTestSQLite(t)
+	SetMailer(failingMailer{})
+	t.Cleanup(func() { SetMailer(&FileMailer{}) })
+	newMagicLinkTestUser(t, "magic@example.com")
+
+	browserToken, err := RequestMagicLink("magic@example.com", false, "192.0.2.1")
+	if err != nil || browserToken == "" {
+		t.Errorf("RequestMagicLink() with failing mailer = %q, %v, want a browser token", browserToken, err)
+	}
+}
@@ -0,0 +1,167 @@
+package yana
+
+import (
+	"fmt"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const MAIL_CONFIG_PATH = "config/mail.yml"
+
+const (
+	MAILER_SMTP = "smtp"
+	MAILER_FILE = "file"
+)
+
+type MailConfig struct {
+	Mailer   string `yaml:"mailer"` // MAILER_SMTP or MAILER_FILE
+	From     string `yaml:"from"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	File     string `yaml:"file"` // Only for MAILER_FILE, an empty string prints the mails instead
+}
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string // Plain text
+}
+
+// Everything that can deliver a Mail.
+// Use SetMailer() to replace the one from config/mail.yml, e.g. in tests.
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+// Sends mails through an SMTP server.
+// STARTTLS is used automatically if the server supports it.
+type SMTPMailer struct {
+	From     string
+	Host     string
+	Port     int
+	User     string
+	Password string
+}
+
+// Appends every mail to a file (or prints it if Path is empty) instead of sending it.
+// Meant for local development, where the links in the mails can be copied from there.
+type FileMailer struct {
+	From  string
+	Path  string
+	mutex sync.Mutex
+}
+
+var mailer Mailer
+var mailerOnce sync.Once
+
+func readMailConfig(path string) (MailConfig, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return MailConfig{}, err
+	}
+
+	config := MailConfig{}
+	err = yaml.Unmarshal(file, &config)
+	if err != nil {
+		return MailConfig{}, fmt.Errorf("in file %q: %w", path, err)
+	}
+	return config, nil
+}
+
+func newMailer(config MailConfig) (Mailer, error) {
+	switch config.Mailer {
+	case MAILER_SMTP:
+		return &SMTPMailer{From: config.From, Host: config.Host, Port: config.Port, User: config.User, Password: config.Password}, nil
+	case MAILER_FILE:
+		return &FileMailer{From: config.From, Path: config.File}, nil
+	}
+	return nil, fmt.Errorf("unknown mailer %q, has to be %q or %q", config.Mailer, MAILER_SMTP, MAILER_FILE)
+}
+
+// Returns the mailer from config/mail.yml.
+// If it can't be read, mails are only printed so that nothing is lost while developing.
+func GetMailer() Mailer {
+	mailerOnce.Do(func() {
+		config, err := readMailConfig(MAIL_CONFIG_PATH)
+		if err == nil {
+			mailer, err = newMailer(config)
+		}
+		if err != nil {
+			fmt.Println("yana.GetMailer() -> Couldn't read mail config, printing mails instead:", err)
+			mailer = &FileMailer{}
+		}
+	})
+	return mailer
+}
+
+func SetMailer(newMailer Mailer) {
+	mailerOnce.Do(func() {})
+	mailer = newMailer
+}
+
+// Header injection isn't possible because every header value is stripped of line breaks
+func formatMail(from string, mail Mail) []byte {
+	removeLineBreaks := strings.NewReplacer("\r", "", "\n", "")
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", removeLineBreaks.Replace(from))
+	fmt.Fprintf(&builder, "To: %s\r\n", removeLineBreaks.Replace(mail.To))
+	fmt.Fprintf(&builder, "Subject: %s\r\n", removeLineBreaks.Replace(mail.Subject))
+	fmt.Fprintf(&builder, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	builder.WriteString("\r\n")
+	return []byte(builder.String())
+}
+
+func (smtpMailer *SMTPMailer) Send(mail Mail) error {
+	address := fmt.Sprintf("%s:%d", smtpMailer.Host, smtpMailer.Port)
+	var auth smtp.Auth
+	if smtpMailer.User != "" {
+		auth = smtp.PlainAuth("", smtpMailer.User, smtpMailer.Password, smtpMailer.Host)
+	}
+	// The envelope only wants the address without the name
+	sender, err := netmail.ParseAddress(smtpMailer.From)
+	if err != nil {
+		return fmt.Errorf("yana.SMTPMailer.Send() -> Invalid from address %q: %w", smtpMailer.From, err)
+	}
+	err = smtp.SendMail(address, auth, sender.Address, []string{mail.To}, formatMail(smtpMailer.From, mail))
+	if err != nil {
+		return fmt.Errorf("yana.SMTPMailer.Send() -> Couldn't send mail: %w", err)
+	}
+	return nil
+}
+
+func (fileMailer *FileMailer) Send(mail Mail) error {
+	fileMailer.mutex.Lock()
+	defer fileMailer.mutex.Unlock()
+	formatted := formatMail(fileMailer.From, mail)
+	if fileMailer.Path == "" {
+		fmt.Printf("yana.FileMailer.Send() -> Not sending this mail:\n%s\n", formatted)
+		return nil
+	}
+	file, err := os.OpenFile(fileMailer.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("yana.FileMailer.Send() -> Couldn't open %q: %w", fileMailer.Path, err)
+	}
+	defer file.Close()
+	_, err = file.Write(append(formatted, '\n'))
+	if err != nil {
+		return fmt.Errorf("yana.FileMailer.Send() -> Couldn't write mail: %w", err)
+	}
+	return nil
+}
+
+// Returns an absolute link to path (which has to start with "/") based on baseurl in config/server.yml
+func buildLink(path string) string {
+	return strings.TrimSuffix(GetServerConfig().BaseURL, "/") + path
+}
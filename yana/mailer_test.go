@@ -0,0 +1,63 @@
+package yana
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Sends every mail of the test to a FileMailer and returns the path of its file
+func useTestFileMailer(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mails.txt")
+	SetMailer(&FileMailer{From: "YANAgo <yana@example.com>", Path: path})
+	t.Cleanup(func() { SetMailer(&FileMailer{}) })
+	return path
+}
+
+// Returns the token of the last link to linkPath (e.g. "/reset-password") in the mails to address
+func readTokenFromMails(t *testing.T, mailPath, address, linkPath string) string {
+	t.Helper()
+	content, err := os.ReadFile(mailPath)
+	if err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	}
+	token := ""
+	isToAddress := false
+	for _, line := range strings.Split(string(content), "\r\n") {
+		if strings.HasPrefix(line, "To: ") {
+			isToAddress = line == "To: "+address
+		}
+		if !isToAddress || !strings.HasPrefix(line, buildLink(linkPath+"?")) {
+			continue
+		}
+		link, err := url.Parse(line)
+		if err != nil {
+			t.Fatalf("url.Parse(%q) = %v", line, err)
+		}
+		token = link.Query().Get("token")
+	}
+	if token == "" {
+		t.Fatalf("No link to %s for %s in the mails:\n%s", linkPath, address, content)
+	}
+	return token
+}
+
+func TestFileMailerStripsLineBreaksFromHeaders(t *testing.T) {
+	mailPath := useTestFileMailer(t)
+	err := GetMailer().Send(Mail{To: "user@example.com\r\nBcc: evil@example.com", Subject: "Hello\nBcc: evil@example.com", Body: "Hi"})
+	if err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	content, err := os.ReadFile(mailPath)
+	if err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	}
+	for _, line := range strings.Split(string(content), "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Errorf("Mail has an injected header:\n%s", content)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package yana
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	content      []byte
+	lastModified time.Time
+}
+
+// Keeps everything in a map, so nothing survives a restart. Safe to use from several goroutines
+type MemoryContentStore struct {
+	mutex      sync.RWMutex
+	namespaces map[string]map[string]memoryObject
+}
+
+func NewMemoryContentStore() *MemoryContentStore {
+	return &MemoryContentStore{namespaces: map[string]map[string]memoryObject{}}
+}
+
+func (store *MemoryContentStore) CreateNamespace(namespace string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, isExisting := store.namespaces[namespace]; isExisting {
+		return fmt.Errorf("yana.MemoryContentStore.CreateNamespace() -> Namespace %q already exists", namespace)
+	}
+	store.namespaces[namespace] = map[string]memoryObject{}
+	return nil
+}
+
+func (store *MemoryContentStore) DeleteNamespace(namespace string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if len(store.namespaces[namespace]) > 0 {
+		return fmt.Errorf("yana.MemoryContentStore.DeleteNamespace() -> Namespace %q isn't empty", namespace)
+	}
+	delete(store.namespaces, namespace)
+	return nil
+}
+
+func (store *MemoryContentStore) ListNamespaces() ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	namespaces := make([]string, 0, len(store.namespaces))
+	for namespace := range store.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+func (store *MemoryContentStore) Put(namespace, key string, content []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	objects, isExisting := store.namespaces[namespace]
+	if !isExisting {
+		return ErrNamespaceNotFound
+	}
+	// Copied so that the caller can't change it afterwards
+	objects[key] = memoryObject{content: append([]byte(nil), content...), lastModified: time.Now().UTC()}
+	return nil
+}
+
+func (store *MemoryContentStore) Get(namespace, key string) ([]byte, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	object, isExisting := store.namespaces[namespace][key]
+	if !isExisting {
+		return nil, ErrContentNotFound
+	}
+	return append([]byte(nil), object.content...), nil
+}
+
+func (store *MemoryContentStore) Stat(namespace, key string) (ContentInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	object, isExisting := store.namespaces[namespace][key]
+	if !isExisting {
+		return ContentInfo{}, ErrContentNotFound
+	}
+	return ContentInfo{Key: key, Size: int64(len(object.content)), LastModified: object.lastModified}, nil
+}
+
+func (store *MemoryContentStore) Delete(namespace, key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.namespaces[namespace], key)
+	return nil
+}
+
+func (store *MemoryContentStore) List(namespace string) ([]ContentInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	var infos []ContentInfo
+	for key, object := range store.namespaces[namespace] {
+		infos = append(infos, ContentInfo{Key: key, Size: int64(len(object.content)), LastModified: object.lastModified})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
@@ -0,0 +1,315 @@
+package yana
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+ * Versioned schema migrations, embedded into the binary.
+ * Every migration is a pair of files in migrations/<database>/, e.g. 0002_constraints.up.sql and 0002_constraints.down.sql.
+ * The applied versions are kept in the schema_migrations table.
+ *
+ * With automigrate in config/server.yml (the default), the server applies missing migrations on startup.
+ * Otherwise run "go run . migrate" (see cli.go).
+ *
+ * Every migration runs in its own transaction, so a failed one leaves nothing behind.
+ * Several instances can start at once: on PostgreSQL they wait for an advisory lock,
+ * on SQLite every transaction takes the write lock right away (_txlock=immediate in sqlite.go)
+ * and the version is checked again inside of it.
+ *
+ * A migration that has been released must never be changed, add a new one instead.
+ */
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Any number works as long as nothing else in the database uses it for pg_advisory_lock()
+const MIGRATION_LOCK_ID int64 = 7_246_113_001
+
+// The first migration may have adopted tables that were created by hand, so reverting it could drop data it never created
+var ErrFirstMigrationNotForced = errors.New("reverting migration 1 drops every table including user_ and note, use -force if that's really wanted")
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	IsApplied    bool
+	AppliedAtUTC sql.NullTime
+}
+
+// Reads the migrations of database ("postgresql" or "sqlite") sorted by version
+func loadMigrations(database string) ([]Migration, error) {
+	directory := path.Join("migrations", database)
+	entries, err := fs.ReadDir(migrationFiles, directory)
+	if err != nil {
+		return nil, fmt.Errorf("yana.loadMigrations() -> Couldn't read %s: %w", directory, err)
+	}
+	migrationsByVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("yana.loadMigrations() -> %s/%s isn't named like 0001_name.up.sql", directory, entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("yana.loadMigrations() -> Invalid version in %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(migrationFiles, path.Join(directory, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("yana.loadMigrations() -> Couldn't read %s: %w", entry.Name(), err)
+		}
+		migration, isOk := migrationsByVersion[version]
+		if !isOk {
+			migration = &Migration{Version: version, Name: match[2]}
+			migrationsByVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("yana.loadMigrations() -> Version %d is used by %q and %q", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(migrationsByVersion))
+	for _, migration := range migrationsByVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("yana.loadMigrations() -> Migration %04d_%s needs an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Runs function on one connection while no other instance is migrating.
+// Creates schema_migrations first if it doesn't exist yet
+func withMigrationLock(db *sql.DB, function func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("yana.withMigrationLock() -> Couldn't get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if GetServerConfig().Database == DATABASE_POSTGRESQL {
+		_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, MIGRATION_LOCK_ID)
+		if err != nil {
+			return fmt.Errorf("yana.withMigrationLock() -> Couldn't get lock: %w", err)
+		}
+		defer func() {
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, MIGRATION_LOCK_ID)
+			if err != nil {
+				fmt.Println("yana.withMigrationLock() -> Couldn't release lock:", err)
+			}
+		}()
+	}
+
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at_utc TIMESTAMP NOT NULL
+	)`
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("yana.withMigrationLock() -> Couldn't create schema_migrations: %w", err)
+	}
+	return function(ctx, conn)
+}
+
+// Returns the versions in schema_migrations with the time they were applied
+func getAppliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at_utc FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("yana.getAppliedMigrations() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAtUTC time.Time
+		err = rows.Scan(&version, &appliedAtUTC)
+		if err != nil {
+			return nil, fmt.Errorf("yana.getAppliedMigrations() -> Couldn't scan row: %w", err)
+		}
+		applied[version] = appliedAtUTC
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("yana.getAppliedMigrations() -> Couldn't read rows: %w", err)
+	}
+	return applied, nil
+}
+
+// Applies (isUp) or reverts one migration in its own transaction.
+// Returns bool: false if another instance was faster and there was nothing to do
+func runMigration(ctx context.Context, conn *sql.Conn, migration Migration, isUp bool) (bool, error) {
+	transaction, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("yana.runMigration() -> Couldn't begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	var count int
+	err = transaction.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, migration.Version).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("yana.runMigration() -> Select query wasn't succesful: %w", err)
+	}
+	isApplied := count > 0
+	if isApplied == isUp {
+		return false, nil
+	}
+
+	if isUp {
+		_, err = transaction.ExecContext(ctx, migration.up)
+	} else {
+		_, err = transaction.ExecContext(ctx, migration.down)
+	}
+	if err != nil {
+		return false, fmt.Errorf("yana.runMigration() -> Migration %04d_%s wasn't succesful: %w", migration.Version, migration.Name, err)
+	}
+	if isUp {
+		query := `INSERT INTO schema_migrations (version, name, applied_at_utc) VALUES ($1, $2, $3)`
+		_, err = transaction.ExecContext(ctx, query, migration.Version, migration.Name, time.Now().UTC())
+	} else {
+		_, err = transaction.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		return false, fmt.Errorf("yana.runMigration() -> Couldn't update schema_migrations: %w", err)
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return false, fmt.Errorf("yana.runMigration() -> Couldn't commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// Applies every migration that isn't applied yet, oldest first.
+// Returns the migrations that were applied by this call
+func MigrateUp() ([]Migration, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.MigrateUp() -> Couldn't get database: %w", err)
+	}
+	migrations, err := loadMigrations(GetServerConfig().Database)
+	if err != nil {
+		return nil, fmt.Errorf("yana.MigrateUp() -> Couldn't load migrations: %w", err)
+	}
+	var appliedMigrations []Migration
+	err = withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		for _, migration := range migrations {
+			isApplied, err := runMigration(ctx, conn, migration, true)
+			if err != nil {
+				return err
+			}
+			if isApplied {
+				appliedMigrations = append(appliedMigrations, migration)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return appliedMigrations, fmt.Errorf("yana.MigrateUp() -> %w", err)
+	}
+	return appliedMigrations, nil
+}
+
+// Reverts the last steps applied migrations, newest first.
+// Stops with ErrFirstMigrationNotForced before migration 1 unless isForced.
+// Returns the migrations that were reverted by this call
+func MigrateDown(steps int, isForced bool) ([]Migration, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.MigrateDown() -> Couldn't get database: %w", err)
+	}
+	migrations, err := loadMigrations(GetServerConfig().Database)
+	if err != nil {
+		return nil, fmt.Errorf("yana.MigrateDown() -> Couldn't load migrations: %w", err)
+	}
+	migrationsByVersion := map[int]Migration{}
+	for _, migration := range migrations {
+		migrationsByVersion[migration.Version] = migration
+	}
+	var revertedMigrations []Migration
+	err = withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		for i := 0; i < steps && i < len(versions); i++ {
+			migration, isOk := migrationsByVersion[versions[i]]
+			if !isOk {
+				return fmt.Errorf("migration %d was applied by a newer version of YANAgo and can only be reverted by it", versions[i])
+			}
+			if migration.Version == 1 && !isForced {
+				return ErrFirstMigrationNotForced
+			}
+			isReverted, err := runMigration(ctx, conn, migration, false)
+			if err != nil {
+				return err
+			}
+			if isReverted {
+				revertedMigrations = append(revertedMigrations, migration)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return revertedMigrations, fmt.Errorf("yana.MigrateDown() -> %w", err)
+	}
+	return revertedMigrations, nil
+}
+
+// Returns every migration that is embedded and whether it's applied
+func GetMigrationStatus() ([]MigrationStatus, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetMigrationStatus() -> Couldn't get database: %w", err)
+	}
+	migrations, err := loadMigrations(GetServerConfig().Database)
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetMigrationStatus() -> Couldn't load migrations: %w", err)
+	}
+	var applied map[int]time.Time
+	err = withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err = getAppliedMigrations(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetMigrationStatus() -> %w", err)
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		appliedAtUTC, isApplied := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Migration:    migration,
+			IsApplied:    isApplied,
+			AppliedAtUTC: sql.NullTime{Time: appliedAtUTC, Valid: isApplied},
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,21 @@
+-- Removes everything, including every account and note
+
+DROP TABLE IF EXISTS invitation;
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_is_append_only();
+DROP TABLE IF EXISTS account_deletion;
+DROP TABLE IF EXISTS api_token;
+DROP TABLE IF EXISTS magic_link;
+DROP TABLE IF EXISTS oidc_identity;
+DROP TABLE IF EXISTS oidc_login;
+DROP TABLE IF EXISTS password_reset;
+DROP TABLE IF EXISTS webauthn_ceremony;
+DROP TABLE IF EXISTS webauthn_credential;
+DROP TABLE IF EXISTS pending_login;
+DROP TABLE IF EXISTS recovery_code;
+DROP TABLE IF EXISTS login_throttle_event;
+DROP TABLE IF EXISTS login_throttle;
+DROP TABLE IF EXISTS remember_token;
+DROP TABLE IF EXISTS session;
+DROP TABLE IF EXISTS user_;
+DROP TABLE IF EXISTS note;
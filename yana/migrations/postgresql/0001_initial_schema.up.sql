@@ -0,0 +1,182 @@
+-- Every table that used to be created by hand from README.md.
+-- IF NOT EXISTS because databases from before the migrations already have them.
+
+CREATE EXTENSION IF NOT EXISTS citext;
+
+CREATE TABLE IF NOT EXISTS note (
+    id UUID NOT NULL,
+    bucketname UUID NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_ (
+    id UUID NOT NULL,
+    fullname TEXT NOT NULL,
+    encryptedpassword TEXT NOT NULL,
+    email CITEXT NOT NULL
+);
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS totpsecret TEXT;
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS totp_last_used_step BIGINT NOT NULL DEFAULT 0;
+-- Accounts from before email verification count as verified
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE user_ ALTER COLUMN email_verified SET DEFAULT FALSE;
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS email_verification_tokenhash CHAR(64);
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS email_verification_expires_at_utc TIMESTAMP;
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS email_verification_sent_at_utc TIMESTAMP;
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS created_at_utc TIMESTAMP;
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS is_disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE user_ ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS session (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL,
+    csrftoken TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_seen_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS remember_token (
+    selector VARCHAR(32) PRIMARY KEY,
+    validatorhash CHAR(64) NOT NULL,
+    user_id UUID NOT NULL,
+    family_id UUID NOT NULL,
+    devicename TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_used_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL,
+    is_used BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS login_throttle (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at_utc TIMESTAMP NOT NULL,
+    locked_until_utc TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS login_throttle_event (
+    key TEXT NOT NULL,
+    event TEXT NOT NULL,
+    at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS recovery_code (
+    user_id UUID NOT NULL,
+    codehash CHAR(64) NOT NULL,
+    used_at_utc TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS pending_login (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL,
+    is_remembered BOOLEAN NOT NULL,
+    attempts INTEGER NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_credential (
+    id TEXT PRIMARY KEY,
+    user_id UUID NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    credential TEXT NOT NULL,
+    signcount BIGINT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_used_at_utc TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_ceremony (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id UUID,
+    sessiondata TEXT NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS password_reset (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oidc_login (
+    statehash CHAR(64) PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    codeverifier TEXT NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oidc_identity (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE TABLE IF NOT EXISTS magic_link (
+    tokenhash CHAR(64) PRIMARY KEY,
+    browserhash CHAR(64) NOT NULL,
+    user_id UUID NOT NULL,
+    is_remembered BOOLEAN NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_token (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    tokenhash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP,
+    last_used_at_utc TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS account_deletion (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    requested_at_utc TIMESTAMP NOT NULL,
+    finished_at_utc TIMESTAMP,
+    notes_deleted INTEGER NOT NULL,
+    objects_deleted INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    at_utc TIMESTAMP NOT NULL,
+    event TEXT NOT NULL,
+    actor_id UUID,
+    user_id UUID,
+    ip TEXT NOT NULL,
+    useragent TEXT NOT NULL,
+    details TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_user_id ON audit_log (user_id);
+
+CREATE OR REPLACE FUNCTION audit_log_is_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_no_changes ON audit_log;
+CREATE TRIGGER audit_log_no_changes BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_is_append_only();
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_is_append_only();
+
+CREATE TABLE IF NOT EXISTS invitation (
+    id UUID PRIMARY KEY,
+    codehash CHAR(64) NOT NULL UNIQUE,
+    user_id UUID NOT NULL,
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS invitation_user_id ON invitation (user_id);
@@ -0,0 +1,19 @@
+-- Removes the constraints of 0002
+
+ALTER TABLE session DROP CONSTRAINT session_user_id_fkey;
+ALTER TABLE remember_token DROP CONSTRAINT remember_token_user_id_fkey;
+ALTER TABLE pending_login DROP CONSTRAINT pending_login_user_id_fkey;
+ALTER TABLE recovery_code DROP CONSTRAINT recovery_code_user_id_fkey;
+ALTER TABLE webauthn_credential DROP CONSTRAINT webauthn_credential_user_id_fkey;
+ALTER TABLE webauthn_ceremony DROP CONSTRAINT webauthn_ceremony_user_id_fkey;
+ALTER TABLE password_reset DROP CONSTRAINT password_reset_user_id_fkey;
+ALTER TABLE oidc_identity DROP CONSTRAINT oidc_identity_user_id_fkey;
+ALTER TABLE magic_link DROP CONSTRAINT magic_link_user_id_fkey;
+ALTER TABLE api_token DROP CONSTRAINT api_token_user_id_fkey;
+ALTER TABLE invitation DROP CONSTRAINT invitation_user_id_fkey;
+
+ALTER TABLE recovery_code DROP CONSTRAINT recovery_code_pkey;
+ALTER TABLE user_ DROP CONSTRAINT user__email_key;
+ALTER TABLE user_ DROP CONSTRAINT user__pkey;
+ALTER TABLE note DROP CONSTRAINT note_bucketname_filename_key;
+ALTER TABLE note DROP CONSTRAINT note_pkey;
@@ -0,0 +1,32 @@
+-- The primary keys, unique constraints and foreign keys that were missing from the tables in README.md.
+-- note, account_deletion and audit_log have no foreign key to user_ because they outlive the user (see accountDeletion.go).
+-- Rows of users that don't exist anymore are removed first. If a note name or an email appears twice, this fails and nothing is changed.
+
+ALTER TABLE note ADD CONSTRAINT note_pkey PRIMARY KEY (id);
+ALTER TABLE note ADD CONSTRAINT note_bucketname_filename_key UNIQUE (bucketname, filename);
+ALTER TABLE user_ ADD CONSTRAINT user__pkey PRIMARY KEY (id);
+ALTER TABLE user_ ADD CONSTRAINT user__email_key UNIQUE (email);
+ALTER TABLE recovery_code ADD CONSTRAINT recovery_code_pkey PRIMARY KEY (user_id, codehash);
+
+DELETE FROM session WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE session ADD CONSTRAINT session_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM remember_token WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE remember_token ADD CONSTRAINT remember_token_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM pending_login WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE pending_login ADD CONSTRAINT pending_login_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM recovery_code WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE recovery_code ADD CONSTRAINT recovery_code_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM webauthn_credential WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE webauthn_credential ADD CONSTRAINT webauthn_credential_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM webauthn_ceremony WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE webauthn_ceremony ADD CONSTRAINT webauthn_ceremony_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM password_reset WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE password_reset ADD CONSTRAINT password_reset_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM oidc_identity WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE oidc_identity ADD CONSTRAINT oidc_identity_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM magic_link WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE magic_link ADD CONSTRAINT magic_link_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM api_token WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE api_token ADD CONSTRAINT api_token_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
+DELETE FROM invitation WHERE user_id NOT IN (SELECT id FROM user_);
+ALTER TABLE invitation ADD CONSTRAINT invitation_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_ (id) ON DELETE CASCADE;
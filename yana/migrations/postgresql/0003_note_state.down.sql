@@ -0,0 +1,6 @@
+-- Rows that aren't committed are removed, their objects stay in the content store
+
+DELETE FROM note WHERE state <> 'committed';
+DROP INDEX note_unfinished;
+ALTER TABLE note DROP COLUMN unfinished_since_utc;
+ALTER TABLE note DROP COLUMN state;
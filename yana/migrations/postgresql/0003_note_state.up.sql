@@ -0,0 +1,7 @@
+-- Notes are written to the database and the content store in steps, see noteWrites.go.
+-- state is 'committed' for finished notes, 'pending' while the object of a note is being put
+-- and 'deleting' while it is being removed.
+
+ALTER TABLE note ADD COLUMN state TEXT NOT NULL DEFAULT 'committed';
+ALTER TABLE note ADD COLUMN unfinished_since_utc TIMESTAMP;
+CREATE INDEX note_unfinished ON note (unfinished_since_utc) WHERE state <> 'committed';
@@ -0,0 +1,2 @@
+ALTER TABLE remember_token DROP COLUMN replaced_by;
+ALTER TABLE remember_token DROP COLUMN used_at_utc;
@@ -0,0 +1,14 @@
+-- Deleting an account empties the ip and useragent of the user's events, see startAccountDeletion().
+-- That is the only change the append-only trigger allows, every other column has to stay the same.
+
+CREATE OR REPLACE FUNCTION audit_log_is_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.ip = '' AND NEW.useragent = ''
+        AND NEW.id = OLD.id AND NEW.at_utc = OLD.at_utc AND NEW.event = OLD.event
+        AND NEW.actor_id IS NOT DISTINCT FROM OLD.actor_id AND NEW.user_id IS NOT DISTINCT FROM OLD.user_id
+        AND NEW.details = OLD.details THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
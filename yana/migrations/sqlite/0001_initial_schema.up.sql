@@ -0,0 +1,175 @@
+-- The same tables as 0001 for PostgreSQL.
+-- IF NOT EXISTS because databases from before the migrations already have them.
+-- UUIDs are TEXT, CITEXT is TEXT COLLATE NOCASE and the append-only trigger of audit_log uses RAISE().
+
+CREATE TABLE IF NOT EXISTS note (
+    id TEXT PRIMARY KEY,
+    bucketname TEXT NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_ (
+    id TEXT PRIMARY KEY,
+    fullname TEXT NOT NULL,
+    encryptedpassword TEXT NOT NULL,
+    email TEXT COLLATE NOCASE NOT NULL UNIQUE,
+    totpsecret TEXT,
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_last_used_step BIGINT NOT NULL DEFAULT 0,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    email_verification_tokenhash CHAR(64),
+    email_verification_expires_at_utc TIMESTAMP,
+    email_verification_sent_at_utc TIMESTAMP,
+    created_at_utc TIMESTAMP,
+    role TEXT NOT NULL DEFAULT 'user',
+    is_disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    password_reset_required BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS session (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    csrftoken TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_seen_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS remember_token (
+    selector VARCHAR(32) PRIMARY KEY,
+    validatorhash CHAR(64) NOT NULL,
+    user_id TEXT NOT NULL,
+    family_id TEXT NOT NULL,
+    devicename TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_used_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL,
+    is_used BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS login_throttle (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at_utc TIMESTAMP NOT NULL,
+    locked_until_utc TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS login_throttle_event (
+    key TEXT NOT NULL,
+    event TEXT NOT NULL,
+    at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS recovery_code (
+    user_id TEXT NOT NULL,
+    codehash CHAR(64) NOT NULL,
+    used_at_utc TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS pending_login (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    is_remembered BOOLEAN NOT NULL,
+    attempts INTEGER NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_credential (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    credential TEXT NOT NULL,
+    signcount BIGINT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_used_at_utc TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_ceremony (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT,
+    sessiondata TEXT NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS password_reset (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oidc_login (
+    statehash CHAR(64) PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    codeverifier TEXT NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oidc_identity (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE TABLE IF NOT EXISTS magic_link (
+    tokenhash CHAR(64) PRIMARY KEY,
+    browserhash CHAR(64) NOT NULL,
+    user_id TEXT NOT NULL,
+    is_remembered BOOLEAN NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_token (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    tokenhash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP,
+    last_used_at_utc TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS account_deletion (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    requested_at_utc TIMESTAMP NOT NULL,
+    finished_at_utc TIMESTAMP,
+    notes_deleted INTEGER NOT NULL,
+    objects_deleted INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    at_utc TIMESTAMP NOT NULL,
+    event TEXT NOT NULL,
+    actor_id TEXT,
+    user_id TEXT,
+    ip TEXT NOT NULL,
+    useragent TEXT NOT NULL,
+    details TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_user_id ON audit_log (user_id);
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_updates BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_deletes BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TABLE IF NOT EXISTS invitation (
+    id TEXT PRIMARY KEY,
+    codehash CHAR(64) NOT NULL UNIQUE,
+    user_id TEXT NOT NULL,
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS invitation_user_id ON invitation (user_id);
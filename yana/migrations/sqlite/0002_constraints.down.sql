@@ -0,0 +1,133 @@
+-- Rebuilds the tables without the foreign keys of 0002
+
+CREATE TABLE session_old (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    csrftoken TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_seen_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO session_old SELECT * FROM session;
+DROP TABLE session;
+ALTER TABLE session_old RENAME TO session;
+
+CREATE TABLE remember_token_old (
+    selector VARCHAR(32) PRIMARY KEY,
+    validatorhash CHAR(64) NOT NULL,
+    user_id TEXT NOT NULL,
+    family_id TEXT NOT NULL,
+    devicename TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_used_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL,
+    is_used BOOLEAN NOT NULL
+);
+INSERT INTO remember_token_old SELECT * FROM remember_token;
+DROP TABLE remember_token;
+ALTER TABLE remember_token_old RENAME TO remember_token;
+
+CREATE TABLE pending_login_old (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    is_remembered BOOLEAN NOT NULL,
+    attempts INTEGER NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO pending_login_old SELECT * FROM pending_login;
+DROP TABLE pending_login;
+ALTER TABLE pending_login_old RENAME TO pending_login;
+
+CREATE TABLE recovery_code_old (
+    user_id TEXT NOT NULL,
+    codehash CHAR(64) NOT NULL,
+    used_at_utc TIMESTAMP
+);
+INSERT INTO recovery_code_old SELECT * FROM recovery_code;
+DROP TABLE recovery_code;
+ALTER TABLE recovery_code_old RENAME TO recovery_code;
+
+CREATE TABLE webauthn_credential_old (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    credential TEXT NOT NULL,
+    signcount BIGINT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_used_at_utc TIMESTAMP
+);
+INSERT INTO webauthn_credential_old SELECT * FROM webauthn_credential;
+DROP TABLE webauthn_credential;
+ALTER TABLE webauthn_credential_old RENAME TO webauthn_credential;
+
+CREATE TABLE webauthn_ceremony_old (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT,
+    sessiondata TEXT NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO webauthn_ceremony_old SELECT * FROM webauthn_ceremony;
+DROP TABLE webauthn_ceremony;
+ALTER TABLE webauthn_ceremony_old RENAME TO webauthn_ceremony;
+
+CREATE TABLE password_reset_old (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO password_reset_old SELECT * FROM password_reset;
+DROP TABLE password_reset;
+ALTER TABLE password_reset_old RENAME TO password_reset;
+
+CREATE TABLE oidc_identity_old (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+INSERT INTO oidc_identity_old SELECT * FROM oidc_identity;
+DROP TABLE oidc_identity;
+ALTER TABLE oidc_identity_old RENAME TO oidc_identity;
+
+CREATE TABLE magic_link_old (
+    tokenhash CHAR(64) PRIMARY KEY,
+    browserhash CHAR(64) NOT NULL,
+    user_id TEXT NOT NULL,
+    is_remembered BOOLEAN NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO magic_link_old SELECT * FROM magic_link;
+DROP TABLE magic_link;
+ALTER TABLE magic_link_old RENAME TO magic_link;
+
+CREATE TABLE api_token_old (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    tokenhash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP,
+    last_used_at_utc TIMESTAMP
+);
+INSERT INTO api_token_old SELECT * FROM api_token;
+DROP TABLE api_token;
+ALTER TABLE api_token_old RENAME TO api_token;
+
+CREATE TABLE invitation_old (
+    id TEXT PRIMARY KEY,
+    codehash CHAR(64) NOT NULL UNIQUE,
+    user_id TEXT NOT NULL,
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO invitation_old SELECT * FROM invitation;
+DROP TABLE invitation;
+ALTER TABLE invitation_old RENAME TO invitation;
+
+CREATE INDEX invitation_user_id ON invitation (user_id);
+
+DROP INDEX note_bucketname_filename;
@@ -0,0 +1,137 @@
+-- Foreign keys from every table with a user_id to user_, so nothing is left behind when a user is removed.
+-- note, account_deletion and audit_log have none because they outlive the user (see accountDeletion.go).
+-- SQLite can't add constraints to existing tables, so the tables are rebuilt. Rows of users that don't exist anymore are dropped.
+-- The primary keys and the unique email are already part of 0001 here.
+
+CREATE TABLE session_new (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    csrftoken TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_seen_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO session_new SELECT * FROM session WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE session;
+ALTER TABLE session_new RENAME TO session;
+
+CREATE TABLE remember_token_new (
+    selector VARCHAR(32) PRIMARY KEY,
+    validatorhash CHAR(64) NOT NULL,
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    family_id TEXT NOT NULL,
+    devicename TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_used_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL,
+    is_used BOOLEAN NOT NULL
+);
+INSERT INTO remember_token_new SELECT * FROM remember_token WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE remember_token;
+ALTER TABLE remember_token_new RENAME TO remember_token;
+
+CREATE TABLE pending_login_new (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    is_remembered BOOLEAN NOT NULL,
+    attempts INTEGER NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO pending_login_new SELECT * FROM pending_login WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE pending_login;
+ALTER TABLE pending_login_new RENAME TO pending_login;
+
+CREATE TABLE recovery_code_new (
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    codehash CHAR(64) NOT NULL,
+    used_at_utc TIMESTAMP,
+    PRIMARY KEY (user_id, codehash)
+);
+INSERT INTO recovery_code_new SELECT * FROM recovery_code WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE recovery_code;
+ALTER TABLE recovery_code_new RENAME TO recovery_code;
+
+CREATE TABLE webauthn_credential_new (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    credential TEXT NOT NULL,
+    signcount BIGINT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    last_used_at_utc TIMESTAMP
+);
+INSERT INTO webauthn_credential_new SELECT * FROM webauthn_credential WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE webauthn_credential;
+ALTER TABLE webauthn_credential_new RENAME TO webauthn_credential;
+
+CREATE TABLE webauthn_ceremony_new (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT REFERENCES user_ (id) ON DELETE CASCADE,
+    sessiondata TEXT NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO webauthn_ceremony_new SELECT * FROM webauthn_ceremony WHERE user_id IS NULL OR user_id IN (SELECT id FROM user_);
+DROP TABLE webauthn_ceremony;
+ALTER TABLE webauthn_ceremony_new RENAME TO webauthn_ceremony;
+
+CREATE TABLE password_reset_new (
+    tokenhash CHAR(64) PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO password_reset_new SELECT * FROM password_reset WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE password_reset;
+ALTER TABLE password_reset_new RENAME TO password_reset;
+
+CREATE TABLE oidc_identity_new (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    created_at_utc TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+INSERT INTO oidc_identity_new SELECT * FROM oidc_identity WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE oidc_identity;
+ALTER TABLE oidc_identity_new RENAME TO oidc_identity;
+
+CREATE TABLE magic_link_new (
+    tokenhash CHAR(64) PRIMARY KEY,
+    browserhash CHAR(64) NOT NULL,
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    is_remembered BOOLEAN NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO magic_link_new SELECT * FROM magic_link WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE magic_link;
+ALTER TABLE magic_link_new RENAME TO magic_link;
+
+CREATE TABLE api_token_new (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    tokenhash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP,
+    last_used_at_utc TIMESTAMP
+);
+INSERT INTO api_token_new SELECT * FROM api_token WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE api_token;
+ALTER TABLE api_token_new RENAME TO api_token;
+
+CREATE TABLE invitation_new (
+    id TEXT PRIMARY KEY,
+    codehash CHAR(64) NOT NULL UNIQUE,
+    user_id TEXT NOT NULL REFERENCES user_ (id) ON DELETE CASCADE,
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL,
+    created_at_utc TIMESTAMP NOT NULL,
+    expires_at_utc TIMESTAMP NOT NULL
+);
+INSERT INTO invitation_new SELECT * FROM invitation WHERE user_id IN (SELECT id FROM user_);
+DROP TABLE invitation;
+ALTER TABLE invitation_new RENAME TO invitation;
+
+CREATE INDEX invitation_user_id ON invitation (user_id);
+
+CREATE UNIQUE INDEX note_bucketname_filename ON note (bucketname, filename);
@@ -0,0 +1,5 @@
+-- A token that was replaced a moment ago still resolves to its successor, see rememberTokens.go.
+-- used_at_utc is when it was replaced and replaced_by the selector of the successor.
+
+ALTER TABLE remember_token ADD COLUMN used_at_utc TIMESTAMP;
+ALTER TABLE remember_token ADD COLUMN replaced_by VARCHAR(32);
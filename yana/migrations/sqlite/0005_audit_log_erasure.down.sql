@@ -0,0 +1,5 @@
+DROP TRIGGER audit_log_no_updates;
+CREATE TRIGGER audit_log_no_updates BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,12 @@
+-- Deleting an account empties the ip and useragent of the user's events, see startAccountDeletion().
+-- That is the only change the append-only trigger allows, every other column has to stay the same.
+
+DROP TRIGGER audit_log_no_updates;
+CREATE TRIGGER audit_log_no_updates BEFORE UPDATE ON audit_log
+WHEN NOT (NEW.ip = '' AND NEW.useragent = ''
+    AND NEW.id = OLD.id AND NEW.at_utc = OLD.at_utc AND NEW.event = OLD.event
+    AND NEW.actor_id IS OLD.actor_id AND NEW.user_id IS OLD.user_id
+    AND NEW.details = OLD.details)
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
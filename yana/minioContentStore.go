@@ -0,0 +1,151 @@
+package yana
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"gopkg.in/yaml.v3"
+)
+
+type MinIOConfig struct {
+	Url       string `yaml:"url"`
+	AccessKey string `yaml:"accesskey"`
+	SecretKey string `yaml:"secretkey"`
+	UseSSL    bool   `yaml:"usessl"`
+}
+
+const MINIO_CONFIG_PATH = "config/minio.yml"
+
+func readMinIOConfig(path string) (MinIOConfig, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return MinIOConfig{}, err
+	}
+
+	config := MinIOConfig{}
+	err = yaml.Unmarshal(file, &config)
+	if err != nil {
+		return MinIOConfig{}, fmt.Errorf("Error in file %q: %w", path, err)
+	}
+	return config, err
+}
+
+// A namespace is a bucket, a key is the name of an object in it
+type MinIOContentStore struct {
+	client *minio.Client
+}
+
+func newMinIOContentStore(configPath string) (*MinIOContentStore, error) {
+	config, err := readMinIOConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("yana.newMinIOContentStore() -> Couldn't read minio config: %w", err)
+	}
+	options := &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+	}
+	client, err := minio.New(config.Url, options)
+	if err != nil {
+		return nil, fmt.Errorf("yana.newMinIOContentStore() -> Couldn't create minio client: %w", err)
+	}
+	return &MinIOContentStore{client: client}, nil
+}
+
+func isMinIONotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NoSuchBucket"
+}
+
+func (store *MinIOContentStore) CreateNamespace(namespace string) error {
+	err := store.client.MakeBucket(yanaContext, namespace, minio.MakeBucketOptions{})
+	if err != nil {
+		return fmt.Errorf("yana.MinIOContentStore.CreateNamespace() -> Couldn't create bucket: %w", err)
+	}
+	return nil
+}
+
+func (store *MinIOContentStore) DeleteNamespace(namespace string) error {
+	err := store.client.RemoveBucket(yanaContext, namespace)
+	if err != nil && !isMinIONotFound(err) {
+		return fmt.Errorf("yana.MinIOContentStore.DeleteNamespace() -> Couldn't remove bucket: %w", err)
+	}
+	return nil
+}
+
+func (store *MinIOContentStore) ListNamespaces() ([]string, error) {
+	buckets, err := store.client.ListBuckets(yanaContext)
+	if err != nil {
+		return nil, fmt.Errorf("yana.MinIOContentStore.ListNamespaces() -> Couldn't list buckets: %w", err)
+	}
+	namespaces := make([]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		namespaces = append(namespaces, bucket.Name)
+	}
+	return namespaces, nil
+}
+
+func (store *MinIOContentStore) Put(namespace, key string, content []byte) error {
+	_, err := store.client.PutObject(yanaContext, namespace, key, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+		return ErrNamespaceNotFound
+	} else if err != nil {
+		return fmt.Errorf("yana.MinIOContentStore.Put() -> Couldn't put object: %w", err)
+	}
+	return nil
+}
+
+func (store *MinIOContentStore) Get(namespace, key string) ([]byte, error) {
+	object, err := store.client.GetObject(yanaContext, namespace, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("yana.MinIOContentStore.Get() -> Couldn't get object: %w", err)
+	}
+	defer object.Close()
+	// GetObject() doesn't ask MinIO yet, so a missing object only shows up here
+	content, err := io.ReadAll(object)
+	if isMinIONotFound(err) {
+		return nil, ErrContentNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("yana.MinIOContentStore.Get() -> Couldn't read object: %w", err)
+	}
+	return content, nil
+}
+
+func (store *MinIOContentStore) Stat(namespace, key string) (ContentInfo, error) {
+	objectInfo, err := store.client.StatObject(yanaContext, namespace, key, minio.StatObjectOptions{})
+	if isMinIONotFound(err) {
+		return ContentInfo{}, ErrContentNotFound
+	} else if err != nil {
+		return ContentInfo{}, fmt.Errorf("yana.MinIOContentStore.Stat() -> Couldn't stat object: %w", err)
+	}
+	return ContentInfo{Key: objectInfo.Key, Size: objectInfo.Size, LastModified: objectInfo.LastModified}, nil
+}
+
+func (store *MinIOContentStore) Delete(namespace, key string) error {
+	err := store.client.RemoveObject(yanaContext, namespace, key, minio.RemoveObjectOptions{})
+	if err != nil && !isMinIONotFound(err) {
+		return fmt.Errorf("yana.MinIOContentStore.Delete() -> Couldn't remove object: %w", err)
+	}
+	return nil
+}
+
+func (store *MinIOContentStore) List(namespace string) ([]ContentInfo, error) {
+	// Stops the listing if it's left early
+	listContext, cancel := context.WithCancel(yanaContext)
+	defer cancel()
+	var infos []ContentInfo
+	// MinIO lists the objects sorted by key
+	for objectInfo := range store.client.ListObjects(listContext, namespace, minio.ListObjectsOptions{Recursive: true}) {
+		if isMinIONotFound(objectInfo.Err) {
+			return nil, nil
+		} else if objectInfo.Err != nil {
+			return nil, fmt.Errorf("yana.MinIOContentStore.List() -> Couldn't list objects: %w", objectInfo.Err)
+		}
+		infos = append(infos, ContentInfo{Key: objectInfo.Key, Size: objectInfo.Size, LastModified: objectInfo.LastModified})
+	}
+	return infos, nil
+}
@@ -0,0 +1,69 @@
+package yana
+
+import (
+	"fmt"
+	"time"
+)
+
+// The row of a note in the database. The name is older than SQLite support, see repository.go
+type PostgreSQLNote struct {
+	Id           string
+	Bucketname   string
+	Filename     string
+	CreatedAtUTC string // RFC 3339
+	State        string // NOTE_STATE_..., see noteWrites.go
+}
+
+func insertNewNoteInPostgreSQL(bucketName, filename string) (PostgreSQLNote, error) {
+	isNoteInDB, yanaErr := doesNoteWithSameNameExist(bucketName, filename)
+	if yanaErr.Err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("Error in yana.insertNewNoteInPostgreSQL() -> Note in user bucket with same name exists: %w", yanaErr.Err)
+	}
+	if isNoteInDB {
+		return PostgreSQLNote{}, fmt.Errorf("Error in yana.insertNewNoteInPostgreSQL() -> Note with same name is already in user's bucket")
+	}
+	return insertPendingNote(bucketName, filename)
+}
+
+// Reserves filename in bucketName before its object is put, see noteWrites.go
+func insertPendingNote(bucketName, filename string) (PostgreSQLNote, error) {
+	// The id and the time are made here and not by the database, so that every database can store them
+	note := PostgreSQLNote{
+		Id:           generateUserID(),
+		Bucketname:   bucketName,
+		Filename:     filename,
+		CreatedAtUTC: time.Now().UTC().Format(time.RFC3339Nano),
+		State:        NOTE_STATE_PENDING,
+	}
+	err := getRepository().InsertNoteMetadata(note)
+	if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("Error in yana.insertPendingNote() -> Insert query wasn't succesful: %w", err)
+	}
+	return note, nil
+}
+
+func getPostgreSQLNoteFromBucketAndNotename(bucketname, filename string) (PostgreSQLNote, error) {
+	note, err := getRepository().GetNoteMetadataByName(bucketname, filename)
+	if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("Error in yana.getPostgreSQLNoteFromBucketAndNotename() -> Select query wasn't succesful: %w", err)
+	}
+	return note, nil
+}
+
+// Returns an error that wraps sql.ErrNoRows if the note doesn't exist
+func getPostgreSQLNoteFromNoteId(postgresNoteId string) (PostgreSQLNote, error) {
+	note, err := getRepository().GetNoteMetadata(postgresNoteId)
+	if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("Error in yana.getPostgreSQLNoteFromNoteId() -> Select query wasn't succesful: %w", err)
+	}
+	return note, nil
+}
+
+// For editing an already existing note
+func doesOtherNoteWithSameNameExist(noteId, bucketName, filename string) (bool, error) {
+	isTaken, err := getRepository().IsNoteNameTaken(bucketName, filename, noteId)
+	if err != nil {
+		return false, fmt.Errorf("Error in yana.doesOtherNoteWithSameNameExist() -> Couldn't check if a different note with the same name already exists because '%w'", err)
+	}
+	return isTaken, nil
+}
@@ -0,0 +1,121 @@
+package yana
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+ * Every note is a row in the note table and an object in the content store, which can't be changed in one transaction.
+ * So that neither a crash nor a failing content store can lose a note or leave an object behind that no row knows about,
+ * every row has a state:
+ *
+ * - committed: the object is there. Only these notes are shown
+ * - pending: the object is being put. The row already reserves the name
+ * - deleting: the object is being removed, the row is removed after it
+ *
+ * A new note starts as a pending row, then its object is put and the row is committed.
+ * A new name is reserved with a pending row as well. Once the object is put under the new name, SwapNoteName()
+ * gives the name to the note in one transaction and turns the old name into a deleting row.
+ * New content under the same name simply replaces the object, which MinIO and the other stores do at once.
+ * Deleting a note marks its row as deleting before the object is removed.
+ *
+ * FinishUnfinishedNotes() removes the objects and rows of every deleting note and of every pending one
+ * that is older than NOTE_WRITE_TIMEOUT, i.e. whose request crashed or gave up.
+ */
+
+const (
+	NOTE_STATE_COMMITTED = "committed"
+	NOTE_STATE_PENDING   = "pending"
+	NOTE_STATE_DELETING  = "deleting"
+)
+
+// Far longer than putting an object takes, so that only writes whose request is gone are rolled back
+const NOTE_WRITE_TIMEOUT = 15 * time.Minute
+
+// Marks a pending note as committed. Returns ErrNoteMetadataNotFound if FinishUnfinishedNotes() has given up on it
+func commitNote(noteId string) error {
+	err := getRepository().SetNoteState(noteId, NOTE_STATE_PENDING, NOTE_STATE_COMMITTED)
+	if err != nil {
+		return fmt.Errorf("yana.commitNote() -> Couldn't commit note: %w", err)
+	}
+	return nil
+}
+
+// Removes the object of a deleting note and then its row.
+// If this fails, the note stays deleting and FinishUnfinishedNotes() tries again later
+func finishNoteDeletion(note PostgreSQLNote) error {
+	store, err := getContentStore()
+	if err != nil {
+		return fmt.Errorf("yana.finishNoteDeletion() -> Couldn't get content store: %w", err)
+	}
+	err = store.Delete(note.Bucketname, note.Filename)
+	if err != nil {
+		return fmt.Errorf("yana.finishNoteDeletion() -> Couldn't remove object: %w", err)
+	}
+	err = getRepository().DeleteNoteMetadata(note.Id)
+	if err != nil {
+		return fmt.Errorf("yana.finishNoteDeletion() -> Couldn't remove row: %w", err)
+	}
+	return nil
+}
+
+// Gives up on a pending note whose object couldn't be put or whose row couldn't be committed.
+// The put might have worked anyway (e.g. if only the response got lost), so the object is removed too
+func abandonPendingNote(note PostgreSQLNote) {
+	err := getRepository().SetNoteState(note.Id, NOTE_STATE_PENDING, NOTE_STATE_DELETING)
+	if errors.Is(err, ErrNoteMetadataNotFound) {
+		// Committed or already given up on
+		return
+	} else if err != nil {
+		fmt.Println("yana.abandonPendingNote() -> Couldn't mark note as deleting, it will be removed after the timeout:", err)
+		return
+	}
+	err = finishNoteDeletion(note)
+	if err != nil {
+		fmt.Println("yana.abandonPendingNote() -> Couldn't remove note, it will be tried again later:", err)
+	}
+}
+
+// Finishes every note that is deleting and rolls back every pending one that is older than NOTE_WRITE_TIMEOUT
+func FinishUnfinishedNotes() error {
+	notes, err := getRepository().GetUnfinishedNotes(time.Now().UTC().Add(-NOTE_WRITE_TIMEOUT))
+	if err != nil {
+		return fmt.Errorf("yana.FinishUnfinishedNotes() -> Couldn't get unfinished notes: %w", err)
+	}
+	var lastErr error
+	for _, note := range notes {
+		if note.State == NOTE_STATE_PENDING {
+			// From here on the request that inserted it can't commit it anymore
+			err = getRepository().SetNoteState(note.Id, NOTE_STATE_PENDING, NOTE_STATE_DELETING)
+			if errors.Is(err, ErrNoteMetadataNotFound) {
+				continue
+			} else if err != nil {
+				lastErr = fmt.Errorf("yana.FinishUnfinishedNotes() -> Couldn't mark note %s as deleting: %w", note.Id, err)
+				fmt.Println(lastErr)
+				continue
+			}
+		}
+		err = finishNoteDeletion(note)
+		if err != nil {
+			lastErr = fmt.Errorf("yana.FinishUnfinishedNotes() -> Couldn't finish note %s: %w", note.Id, err)
+			fmt.Println(lastErr)
+		}
+	}
+	return lastErr
+}
+
+// Runs FinishUnfinishedNotes() now and then every UnfinishedNoteRetryMinutes until the server stops
+func StartUnfinishedNoteFinisher() {
+	interval := time.Duration(GetServerConfig().UnfinishedNoteRetryMinutes) * time.Minute
+	go func() {
+		for {
+			FinishUnfinishedNotes()
+			if interval <= 0 {
+				return
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
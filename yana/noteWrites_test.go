@@ -0,0 +1,267 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errTestFailure = errors.New("failing on purpose")
+
+// A ContentStore whose writes can be made to fail
+type failingContentStore struct {
+	ContentStore
+	isPutFailing    bool
+	isDeleteFailing bool
+}
+
+func (store *failingContentStore) Put(namespace, key string, content []byte) error {
+	if store.isPutFailing {
+		return errTestFailure
+	}
+	return store.ContentStore.Put(namespace, key, content)
+}
+
+func (store *failingContentStore) Delete(namespace, key string) error {
+	if store.isDeleteFailing {
+		return errTestFailure
+	}
+	return store.ContentStore.Delete(namespace, key)
+}
+
+// A MetadataRepository that can fail to commit notes and to swap their names
+type failingRepository struct {
+	MetadataRepository
+	isCommitFailing bool
+	isSwapFailing   bool
+}
+
+func (repository *failingRepository) SetNoteState(noteId string, fromState string, toState string) error {
+	if repository.isCommitFailing && fromState == NOTE_STATE_PENDING && toState == NOTE_STATE_COMMITTED {
+		return errTestFailure
+	}
+	return repository.MetadataRepository.SetNoteState(noteId, fromState, toState)
+}
+
+func (repository *failingRepository) SwapNoteName(noteId string, pendingNoteId string, leftoverNoteId string) (PostgreSQLNote, error) {
+	if repository.isSwapFailing {
+		return PostgreSQLNote{}, errTestFailure
+	}
+	return repository.MetadataRepository.SwapNoteName(noteId, pendingNoteId, leftoverNoteId)
+}
+
+type noteWriteTest struct {
+	db         *sql.DB
+	store      *failingContentStore
+	repository *failingRepository
+	bucketName string
+	noteId     string // Of the note "Note" with the content "Old"
+}
+
+// Returns every note the user sees, name -> content
+func getVisibleTestNotes(t *testing.T, bucketName string) map[string]string {
+	t.Helper()
+	notes, err := GetAllNotesOfUser(bucketName)
+	if err != nil {
+		t.Fatalf("GetAllNotesOfUser() = %v", err)
+	}
+	visibleNotes := map[string]string{}
+	for _, note := range notes {
+		visibleNotes[note.Name] = note.Content
+	}
+	return visibleNotes
+}
+
+func isSameTestNotes(got, want map[string]string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for name, content := range want {
+		if gotContent, isThere := got[name]; !isThere || gotContent != content {
+			return false
+		}
+	}
+	return true
+}
+
+// Whatever fails while a note is written, the user either sees the old or the new note, never none.
+// Once FinishUnfinishedNotes() has run, no object and no row is left that no note needs
+func TestNoteWritesNeverLoseNotes(t *testing.T) {
+	tests := []struct {
+		name      string
+		fail      func(test *noteWriteTest)
+		write     func(test *noteWriteTest) error
+		wantErr   bool
+		wantNotes map[string]string
+	}{
+		{
+			name:      "new note whose object can't be put",
+			fail:      func(test *noteWriteTest) { test.store.isPutFailing = true },
+			write:     func(test *noteWriteTest) error { return NewNote(test.bucketName, "New", "New") },
+			wantErr:   true,
+			wantNotes: map[string]string{"Note": "Old"},
+		},
+		{
+			name:      "new note that can't be committed",
+			fail:      func(test *noteWriteTest) { test.repository.isCommitFailing = true },
+			write:     func(test *noteWriteTest) error { return NewNote(test.bucketName, "New", "New") },
+			wantErr:   true,
+			wantNotes: map[string]string{"Note": "Old"},
+		},
+		{
+			name: "new content that can't be put",
+			fail: func(test *noteWriteTest) { test.store.isPutFailing = true },
+			write: func(test *noteWriteTest) error {
+				_, err := UpdateNote(test.bucketName, test.noteId, "Note", "New")
+				return err
+			},
+			wantErr:   true,
+			wantNotes: map[string]string{"Note": "Old"},
+		},
+		{
+			name: "rename whose new object can't be put",
+			fail: func(test *noteWriteTest) { test.store.isPutFailing = true },
+			write: func(test *noteWriteTest) error {
+				_, err := UpdateNote(test.bucketName, test.noteId, "Renamed", "New")
+				return err
+			},
+			wantErr:   true,
+			wantNotes: map[string]string{"Note": "Old"},
+		},
+		{
+			name: "rename whose names can't be swapped",
+			fail: func(test *noteWriteTest) { test.repository.isSwapFailing = true },
+			write: func(test *noteWriteTest) error {
+				_, err := UpdateNote(test.bucketName, test.noteId, "Renamed", "New")
+				return err
+			},
+			wantErr:   true,
+			wantNotes: map[string]string{"Note": "Old"},
+		},
+		{
+			name: "rename whose old object can't be removed",
+			fail: func(test *noteWriteTest) { test.store.isDeleteFailing = true },
+			write: func(test *noteWriteTest) error {
+				_, err := UpdateNote(test.bucketName, test.noteId, "Renamed", "New")
+				return err
+			},
+			wantNotes: map[string]string{"Renamed": "New"},
+		},
+		{
+			name:      "deletion whose object can't be removed",
+			fail:      func(test *noteWriteTest) { test.store.isDeleteFailing = true },
+			write:     func(test *noteWriteTest) error { return DeleteNoteFromNoteId(test.bucketName, test.noteId) },
+			wantNotes: map[string]string{},
+		},
+		{
+			name: "crash after the object of a new note was put",
+			write: func(test *noteWriteTest) error {
+				_, err := insertPendingNote(test.bucketName, "New")
+				if err != nil {
+					return err
+				}
+				return test.store.Put(test.bucketName, "New", []byte("New"))
+			},
+			wantNotes: map[string]string{"Note": "Old"},
+		},
+		{
+			name: "crash after a note was marked as deleting",
+			write: func(test *noteWriteTest) error {
+				return getRepository().SetNoteState(test.noteId, NOTE_STATE_COMMITTED, NOTE_STATE_DELETING)
+			},
+			wantNotes: map[string]string{},
+		},
+	}
+	for _, noteTest := range tests {
+		t.Run(noteTest.name, func(t *testing.T) {
+			db := newTestSQLite(t)
+			test := &noteWriteTest{
+				db:         db,
+				store:      &failingContentStore{ContentStore: getContentStoreForTest(t)},
+				repository: &failingRepository{MetadataRepository: getRepository()},
+				bucketName: newTestBucket(t),
+			}
+			SetContentStore(test.store)
+			SetRepository(test.repository)
+			err := NewNote(test.bucketName, "Note", "Old")
+			if err != nil {
+				t.Fatalf("NewNote() = %v", err)
+			}
+			note, err := getRepository().GetNoteMetadataByName(test.bucketName, "Note")
+			if err != nil {
+				t.Fatalf("GetNoteMetadataByName() = %v", err)
+			}
+			test.noteId = note.Id
+
+			if noteTest.fail != nil {
+				noteTest.fail(test)
+			}
+			err = noteTest.write(test)
+			if (err != nil) != noteTest.wantErr {
+				t.Errorf("Writing the note = %v, want an error: %v", err, noteTest.wantErr)
+			}
+			got := getVisibleTestNotes(t, test.bucketName)
+			if !isSameTestNotes(got, noteTest.wantNotes) {
+				t.Errorf("Notes after writing = %v, want %v", got, noteTest.wantNotes)
+			}
+
+			// The store works again and every request that could still commit a pending note has timed out
+			test.store.isPutFailing, test.store.isDeleteFailing = false, false
+			test.repository.isCommitFailing, test.repository.isSwapFailing = false, false
+			_, err = db.Exec(`UPDATE note SET unfinished_since_utc = $1 WHERE state <> $2`,
+				time.Now().UTC().Add(-2*NOTE_WRITE_TIMEOUT), NOTE_STATE_COMMITTED)
+			if err != nil {
+				t.Fatalf("Update query = %v", err)
+			}
+			err = FinishUnfinishedNotes()
+			if err != nil {
+				t.Fatalf("FinishUnfinishedNotes() = %v", err)
+			}
+
+			got = getVisibleTestNotes(t, test.bucketName)
+			if !isSameTestNotes(got, noteTest.wantNotes) {
+				t.Errorf("Notes after FinishUnfinishedNotes() = %v, want %v", got, noteTest.wantNotes)
+			}
+			var wantKeys []string
+			for name := range noteTest.wantNotes {
+				wantKeys = append(wantKeys, name)
+			}
+			sort.Strings(wantKeys)
+			keys := listTestKeys(t, test.store, test.bucketName)
+			if strings.Join(keys, ",") != strings.Join(wantKeys, ",") {
+				t.Errorf("Objects after FinishUnfinishedNotes() = %q, want %q", keys, wantKeys)
+			}
+			unfinishedNotes, err := getRepository().GetUnfinishedNotes(time.Now().UTC().Add(time.Hour))
+			if err != nil || len(unfinishedNotes) != 0 {
+				t.Errorf("GetUnfinishedNotes() after FinishUnfinishedNotes() = %+v, %v, want nothing", unfinishedNotes, err)
+			}
+		})
+	}
+}
+
+// A pending note is only rolled back once its request can't commit it anymore
+func TestFinishUnfinishedNotesWaitsForPendingNotes(t *testing.T) {
+	newTestSQLite(t)
+	bucketName := newTestBucket(t)
+	note, err := insertPendingNote(bucketName, "New")
+	if err != nil {
+		t.Fatalf("insertPendingNote() = %v", err)
+	}
+	putTestContent(t, getContentStoreForTest(t), bucketName, "New", "New")
+
+	err = FinishUnfinishedNotes()
+	if err != nil {
+		t.Fatalf("FinishUnfinishedNotes() = %v", err)
+	}
+	err = commitNote(note.Id)
+	if err != nil {
+		t.Fatalf("commitNote() after FinishUnfinishedNotes() = %v", err)
+	}
+	got := getVisibleTestNotes(t, bucketName)
+	if !isSameTestNotes(got, map[string]string{"New": "New"}) {
+		t.Errorf("Notes after commitNote() = %v, want the new note", got)
+	}
+}
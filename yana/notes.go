@@ -0,0 +1,325 @@
+package yana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	FILENAME_MAX_LEN             = 1024 // In bytes. See https://min.io/docs/minio/windows/operations/concepts/thresholds.html#:~:text=Maximum%20length%20for%20object%20names
+	BUCKETNAME_MAX_LEN           = 63
+	DEFAULT_BUCKET_SERVER_REGION = "us-east-1" // See https://min.io/docs/minio/linux/developers/go/API.html#MakeBucket:~:text=(defaults%20to%20us%2Deast%2D1).
+)
+
+type Note struct {
+	PostgreSQLId     string // TODO: Maybe make this a UUID instead of a string in the future?
+	Name             string
+	BucketName       string // TODO: Maybe make this a UUID instead of a string in the future?
+	Content          string
+	CreatedAtUTC     string
+	ContentShortened string
+}
+
+type UpdatedNoteState struct {
+	State int
+}
+
+const (
+	NewNoteState = iota
+	NothingHappenedState
+)
+
+var yanaContext context.Context = context.Background()
+
+// Just for myself/the developer to have an easy to time to print the error
+func (updatedNoteState UpdatedNoteState) ToString() string {
+	switch updatedNoteState.State {
+	case NewNoteState:
+		return "NewNoteState: Succesfully updated note"
+	case NothingHappenedState:
+		return "NothingHappenedState: Note couldn't be updated and is (still) in it's original state"
+	}
+	return ""
+}
+
+// Turns out that unix-like systems support a plethora of characters.
+// note.html already makes the input <= 1024 bytes and filters out NUL and /, but
+// checking here too because you can't trust the user.
+// Only new names are checked, notes that already exist can always be read. A content store can have a lower limit, see escapeFilesystemName()
+func isFilenameOk(filename string) bool {
+	containsNULCharacter := strings.ContainsRune(filename, '\x00')
+	containsSlash := strings.ContainsRune(filename, '/')    // Can't escape / in a file.
+	isDotOrDotDot := filename == "." || filename == ".."    // I don't know a better name for this variable.
+	isLongerThanAllowed := len(filename) > FILENAME_MAX_LEN // Only limited by the S3 Api.
+	return !containsNULCharacter && !containsSlash && !isLongerThanAllowed && !isDotOrDotDot
+}
+
+func doesNoteWithSameNameExist(bucketName, noteName string) (bool, YanaError) {
+	store, err := getContentStore()
+	if err != nil {
+		return false, YanaError{Code: BadClient, Err: fmt.Errorf("Error in yana.doesNoteWithSameNameExist(): Error getting content store: %w ", err)}
+	}
+	_, err = store.Stat(bucketName, noteName)
+	if err != nil {
+		return false, YanaError{Code: NoError, Err: nil}
+	}
+	return true, YanaError{Code: NoError, Err: nil}
+}
+
+func GetAllNotesOfUser(bucketName string) ([]Note, error) {
+	store, err := getContentStore()
+	if err != nil {
+		return []Note{}, fmt.Errorf("yana.GetAllNotesOfUser() -> Couldn't get content store: %w", err)
+	}
+	contentInfos, err := store.List(bucketName)
+	if err != nil {
+		return []Note{}, fmt.Errorf("yana.GetAllNotesOfUser() -> Couldn't list notes: %w", err)
+	}
+	var notes []Note
+	for _, contentInfo := range contentInfos {
+		actualNote, err := GetNoteFromBucketAndNotename(bucketName, contentInfo.Key)
+		if err != nil {
+			// e.g. a note that is being written or deleted right now. The others are still shown
+			fmt.Printf("yana.GetAllNotesOfUser() -> Couldn't get note %q: %v\n", contentInfo.Key, err)
+			continue
+		}
+		notes = append(notes, actualNote)
+	}
+	return notes, nil
+}
+
+type StorageUsage struct {
+	NoteCount int
+	Bytes     int64
+}
+
+// e.g. "1.5 KB"
+func (usage StorageUsage) HumanReadable() string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size := float64(usage.Bytes)
+	unit := 0
+	for size >= 1000 && unit < len(units)-1 {
+		size /= 1000
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", usage.Bytes)
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
+// Adds up the sizes of every object in the user's bucket
+func GetStorageUsage(bucketName string) (StorageUsage, error) {
+	store, err := getContentStore()
+	if err != nil {
+		return StorageUsage{}, fmt.Errorf("yana.GetStorageUsage() -> Couldn't get content store: %w", err)
+	}
+	contentInfos, err := store.List(bucketName)
+	if err != nil {
+		return StorageUsage{}, fmt.Errorf("yana.GetStorageUsage() -> Couldn't list objects: %w", err)
+	}
+	var usage StorageUsage
+	for _, contentInfo := range contentInfos {
+		usage.NoteCount++
+		usage.Bytes += contentInfo.Size
+	}
+	return usage, nil
+}
+
+func shortenNoteContent(content string) string {
+	if len(content) >= 25 {
+		return content[:21] + "..."
+	}
+	return content
+}
+
+func GetNoteFromBucketAndNotename(bucketName, noteName string) (Note, error) {
+	store, err := getContentStore()
+	if err != nil {
+		return Note{}, fmt.Errorf("yana.GetNoteFromBucketAndNotename() -> Couldn't get content store because: '%w'\n", err)
+	}
+	content, err := store.Get(bucketName, noteName)
+	if err != nil {
+		return Note{}, fmt.Errorf("Couldn't get note content in yana.GetNoteFromBucketAndNotename(): %w", err)
+	}
+	postgresqlNoteInfo, err := getPostgreSQLNoteFromBucketAndNotename(bucketName, noteName)
+	if err != nil {
+		return Note{}, fmt.Errorf("Couldn't get note metadata (from postgresql) in yana.GetNoteFromBucketAndNotename(): %w", err)
+	}
+	return Note{
+		PostgreSQLId:     postgresqlNoteInfo.Id,
+		Name:             noteName,
+		BucketName:       bucketName,
+		Content:          string(content),
+		CreatedAtUTC:     postgresqlNoteInfo.CreatedAtUTC,
+		ContentShortened: shortenNoteContent(string(content))}, nil
+}
+
+// userid is the user that wants to see the note.
+// If the note doesn't belong to them, a YanaError with NoteNotFound or NoteForbidden is returned.
+func GetNoteFromNoteId(userid, postgresqlNoteId string) (Note, error) {
+	store, err := getContentStore()
+	if err != nil {
+		return Note{}, fmt.Errorf("yana.GetNoteFromNoteId() -> (Fail getting content store) Couldn't get content store because: '%w'\n", err)
+	}
+	postgresqlNoteInfo, err := getOwnedPostgreSQLNote(userid, postgresqlNoteId)
+	if err != nil {
+		return Note{}, fmt.Errorf("yana.GetNoteFromNoteId() -> (Fail getting postgresqlNoteInfo) Couldn't get postgreSQLNoteInfo: '%w'\n", err)
+	}
+
+	// I am not using GetNoteFromBucketAndNotename() and do a normal Get call here because otherwise I would have a second unnecessary call to postgresql
+	raw_content, err := store.Get(postgresqlNoteInfo.Bucketname, postgresqlNoteInfo.Filename)
+	if err != nil {
+		return Note{}, fmt.Errorf("Couldn't get note content in yana.GetNoteFromNoteId(): %w", err)
+	}
+	content := string(raw_content)
+	return Note{
+		PostgreSQLId:     postgresqlNoteInfo.Id,
+		Name:             postgresqlNoteInfo.Filename,
+		BucketName:       postgresqlNoteInfo.Bucketname,
+		Content:          content,
+		CreatedAtUTC:     postgresqlNoteInfo.CreatedAtUTC,
+		ContentShortened: shortenNoteContent(content)}, nil
+}
+
+func NewBucket(bucketName string) error {
+	store, err := getContentStore()
+	if err != nil {
+		return fmt.Errorf("yana.NewBucket() -> (Fail getting content store) Couldn't create bucket because: '%w'\n", err)
+	}
+	err = store.CreateNamespace(bucketName)
+	if err != nil {
+		return fmt.Errorf("yana.NewBucket() -> Couldn't create bucket because: '%w'\n", err)
+	}
+	return nil
+}
+
+func NewNote(bucketName, noteName, content string) error {
+	if content == "error" {
+		return fmt.Errorf("content is not allowed to just be \"error\"")
+	}
+	if !isFilenameOk(noteName) {
+		return fmt.Errorf("Error in yana.NewNote(): Filename is not ok")
+	}
+
+	store, err := getContentStore()
+	if err != nil {
+		return fmt.Errorf("yana.NewNote() -> (Fail getting content store) Couldn't get content store because: '%w'\n", err)
+	}
+
+	fmt.Println("noteName=", noteName)
+	isExisting, yanaErr := doesNoteWithSameNameExist(bucketName, noteName)
+	if isExisting {
+		return fmt.Errorf("yana.NewNote() -> (Note already exists) A note with the same name already exists")
+	}
+	if yanaErr.Err != nil {
+		return fmt.Errorf("yana.NewNote() -> Couldn't check if note with same name exists: '%w'", yanaErr.Err)
+	}
+
+	// The row is inserted as pending before the object is put and only committed afterwards, see noteWrites.go
+	note, err := insertNewNoteInPostgreSQL(bucketName, noteName)
+	if err != nil {
+		return fmt.Errorf("yana.NewNote() -> (Fail inserting info to postgres) Couldn't add info to postgresql because: %w", err)
+	}
+	err = store.Put(bucketName, noteName, []byte(content))
+	if err != nil {
+		abandonPendingNote(note)
+		return fmt.Errorf("yana.NewNote() -> (Fail uploading Object) Couldn't create note because: '%w'\n", err)
+	}
+	err = commitNote(note.Id)
+	if err != nil {
+		abandonPendingNote(note)
+		return fmt.Errorf("yana.NewNote() -> Couldn't create note because: %w", err)
+	}
+	return nil
+}
+
+func UpdateNote(bucketName, noteId, newNoteName, newContent string) (UpdatedNoteState, error) {
+	// The bucket is the user's id, so this also makes sure that the note is theirs
+	oldNote, err := GetNoteFromNoteId(bucketName, noteId)
+	if err != nil {
+		return UpdatedNoteState{NothingHappenedState}, fmt.Errorf("Error in yana.UpdateNote() -> Couldn't fetch note because: '%w'\n", err)
+	}
+	// A note that keeps its name can always be saved, see isFilenameOk()
+	if newNoteName != oldNote.Name && !isFilenameOk(newNoteName) {
+		return UpdatedNoteState{NothingHappenedState}, fmt.Errorf("Error in yana.UpdateNote(): Filename is not ok")
+	}
+
+	noteWithSameNameExist, err := doesOtherNoteWithSameNameExist(noteId, bucketName, newNoteName)
+	if err != nil {
+		return UpdatedNoteState{NothingHappenedState}, fmt.Errorf("Error in yana.UpdateNote(): Couldn't check if a note with the same name exists because '%w'", err)
+	}
+	if noteWithSameNameExist {
+		return UpdatedNoteState{NothingHappenedState}, fmt.Errorf("Error in yana.UpdateNote(): A different note with the same name already exists")
+	}
+
+	oldNoteName := oldNote.Name
+	oldContent := oldNote.Content
+
+	isNameChanged := oldNoteName != newNoteName
+	isContentChanged := oldContent != newContent
+	if !isNameChanged && !isContentChanged {
+		// Not an error because the user hasn't changed anything then
+		return UpdatedNoteState{NothingHappenedState}, nil
+	}
+
+	store, err := getContentStore()
+	if err != nil {
+		return UpdatedNoteState{NothingHappenedState}, fmt.Errorf("Error in yana.UpdateNote() -> Couldn't get content store because: '%w'\n", err)
+	}
+	if !isNameChanged {
+		// Put() replaces the object at once, so the note has either the old or the new content
+		err = store.Put(bucketName, oldNoteName, []byte(newContent))
+		if err != nil {
+			return UpdatedNoteState{NothingHappenedState}, fmt.Errorf("Error in yana.UpdateNote() -> Couldn't replace content because: '%w'\n", err)
+		}
+		return UpdatedNoteState{NewNoteState}, nil
+	}
+
+	// A new name needs a new object. The old object is kept until the note has the new name, see noteWrites.go
+	pendingNote, err := insertPendingNote(bucketName, newNoteName)
+	if err != nil {
+		return UpdatedNoteState{NothingHappenedState}, fmt.Errorf("Error in yana.UpdateNote() -> Couldn't reserve the new name because: '%w'\n", err)
+	}
+	err = store.Put(bucketName, newNoteName, []byte(newContent))
+	if err != nil {
+		abandonPendingNote(pendingNote)
+		return UpdatedNoteState{NothingHappenedState}, fmt.Errorf("Error in yana.UpdateNote() -> Couldn't create note with the new information because: '%w'\n", err)
+	}
+	leftoverNote, err := getRepository().SwapNoteName(noteId, pendingNote.Id, generateUserID())
+	if err != nil {
+		abandonPendingNote(pendingNote)
+		return UpdatedNoteState{NothingHappenedState}, fmt.Errorf("Error in yana.UpdateNote() -> Couldn't rename note because: '%w'\n", err)
+	}
+	err = finishNoteDeletion(leftoverNote)
+	if err != nil {
+		fmt.Println("Error in yana.UpdateNote() -> Couldn't remove the old object, it will be tried again later:", err)
+	}
+	return UpdatedNoteState{NewNoteState}, nil
+}
+
+// userid is the user that wants to delete the note, see GetNoteFromNoteId()
+func DeleteNoteFromNoteId(userid, noteId string) error {
+	// 1. Mark the note as deleting, from here on it's gone for the user
+	// 2. Remove the object and then the row
+	// 	  2.1 If 2. wasn't succesful, FinishUnfinishedNotes() tries again later
+	postgresqlNote, err := getOwnedPostgreSQLNote(userid, noteId)
+	if err != nil {
+		return fmt.Errorf("yana.DeleteNoteFromNoteId() -> Couldn't get Info from Postgres: '%w'\n", err)
+	}
+
+	err = getRepository().SetNoteState(noteId, NOTE_STATE_COMMITTED, NOTE_STATE_DELETING)
+	if errors.Is(err, ErrNoteMetadataNotFound) {
+		return YanaError{Code: NoteNotFound, Err: fmt.Errorf("yana.DeleteNoteFromNoteId() -> Note %q has been removed in the meantime", noteId)}
+	} else if err != nil {
+		return fmt.Errorf("yana.DeleteNoteFromNoteId() -> Couldn't delete note in Postgres: '%w'\n", err)
+	}
+
+	err = finishNoteDeletion(postgresqlNote)
+	if err != nil {
+		fmt.Println("yana.DeleteNoteFromNoteId() -> Couldn't remove note in MinIO, it will be tried again later:", err)
+	}
+	return nil
+}
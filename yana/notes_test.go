@@ -0,0 +1,70 @@
+package yana
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestBucket(t *testing.T) string {
+	t.Helper()
+	bucketName := generateUserID()
+	err := NewBucket(bucketName)
+	if err != nil {
+		t.Fatalf("NewBucket() = %v", err)
+	}
+	return bucketName
+}
+
+// Only new names are limited by FILENAME_MAX_LEN. Notes whose names are longer
+// (e.g. from when the limit was different) must still be listed and opened
+func TestNoteNameLength(t *testing.T) {
+	newTestSQLite(t)
+	bucketName := newTestBucket(t)
+	longName := strings.Repeat("ü", FILESYSTEM_KEY_MAX_LEN)
+	err := NewNote(bucketName, longName, "Long")
+	if err != nil {
+		t.Fatalf("NewNote() with a %d byte name = %v", len(longName), err)
+	}
+	err = NewNote(bucketName, strings.Repeat("a", FILENAME_MAX_LEN+1), "Too long")
+	if err == nil {
+		t.Errorf("NewNote() with a name longer than FILENAME_MAX_LEN = nil, want an error")
+	}
+
+	// Saved directly, so nothing checks the name
+	tooLongName := strings.Repeat("b", FILENAME_MAX_LEN+1)
+	note, err := insertNewNoteInPostgreSQL(bucketName, tooLongName)
+	if err != nil {
+		t.Fatalf("insertNewNoteInPostgreSQL() = %v", err)
+	}
+	putTestContent(t, getContentStoreForTest(t), bucketName, tooLongName, "Old")
+	err = commitNote(note.Id)
+	if err != nil {
+		t.Fatalf("commitNote() = %v", err)
+	}
+
+	notes, err := GetAllNotesOfUser(bucketName)
+	if err != nil || len(notes) != 2 {
+		t.Fatalf("GetAllNotesOfUser() = %d notes, %v, want 2", len(notes), err)
+	}
+	got, err := GetNoteFromBucketAndNotename(bucketName, tooLongName)
+	if err != nil || got.Content != "Old" {
+		t.Errorf("GetNoteFromBucketAndNotename() of a name longer than FILENAME_MAX_LEN = %q, %v, want \"Old\"", got.Content, err)
+	}
+	state, err := UpdateNote(bucketName, note.Id, tooLongName, "New")
+	if err != nil || state.State != NewNoteState {
+		t.Errorf("UpdateNote() keeping a name longer than FILENAME_MAX_LEN = %s, %v, want NewNoteState", state.ToString(), err)
+	}
+	_, err = UpdateNote(bucketName, note.Id, tooLongName+"b", "New")
+	if err == nil {
+		t.Errorf("UpdateNote() renaming to a name longer than FILENAME_MAX_LEN = nil, want an error")
+	}
+}
+
+func getContentStoreForTest(t *testing.T) ContentStore {
+	t.Helper()
+	store, err := getContentStore()
+	if err != nil {
+		t.Fatalf("getContentStore() = %v", err)
+	}
+	return store
+}
@@ -0,0 +1,281 @@
+package yana
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * Single sign-on with an OpenID Connect identity provider.
+ * The login uses the authorization code flow with PKCE: BeginLogin() returns the URL of the provider
+ * and saves state, nonce and PKCE verifier in the oidc_login table. After the user comes back,
+ * FinishLogin() exchanges the code and checks the ID token.
+ * Identities are remembered in oidc_identity by issuer and subject, so a changed email at the provider
+ * still ends up in the same account.
+ */
+
+const OIDC_CONFIG_PATH = "config/oidc.yml"
+
+const OIDC_LOGIN_LIFETIME = 10 * time.Minute
+
+var ErrOIDCLoginNotFound = errors.New("single sign-on login not found or expired")
+var ErrOIDCNonceMismatch = errors.New("the nonce of the ID token doesn't match")
+var ErrOIDCEmailNotVerified = errors.New("the identity provider didn't confirm that the email is verified")
+var ErrOIDCSignupDisabled = errors.New("there is no account for this identity and allowsignup is false")
+var ErrOIDCDisabled = errors.New("single sign-on isn't enabled in config/oidc.yml")
+
+type OIDCConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Name         string   `yaml:"name"` // Shown on the login button
+	Issuer       string   `yaml:"issuer"`
+	ClientId     string   `yaml:"clientid"`
+	ClientSecret string   `yaml:"clientsecret"`
+	Scopes       []string `yaml:"scopes"` // "openid" is always added
+	AllowSignup  bool     `yaml:"allowsignup"`
+}
+
+// What FinishLogin() found out about the user from the ID token
+type OIDCIdentity struct {
+	Issuer          string
+	Subject         string
+	Email           string
+	IsEmailVerified bool
+	Name            string
+}
+
+// One identity provider with its discovery document already loaded
+type OIDCProvider struct {
+	Config       OIDCConfig
+	provider     *oidc.Provider
+	oauth2Config oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+}
+
+var oidcProvider *OIDCProvider
+var oidcProviderMutex sync.Mutex
+
+func readOIDCConfig(path string) (OIDCConfig, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return OIDCConfig{}, err
+	}
+
+	config := OIDCConfig{}
+	err = yaml.Unmarshal(file, &config)
+	if err != nil {
+		return OIDCConfig{}, fmt.Errorf("in file %q: %w", path, err)
+	}
+	return config, nil
+}
+
+var oidcConfig OIDCConfig
+var oidcConfigOnce sync.Once
+
+// config/oidc.yml is only read once, like config/server.yml.
+// If it's missing or broken, single sign-on is off.
+func getOIDCConfig() OIDCConfig {
+	oidcConfigOnce.Do(func() {
+		var err error
+		oidcConfig, err = readOIDCConfig(OIDC_CONFIG_PATH)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println("yana.getOIDCConfig() -> Couldn't read oidc config, single sign-on is off:", err)
+		}
+	})
+	return oidcConfig
+}
+
+// Fetches the discovery document from config.Issuer.
+// ctx can carry its own *http.Client through oidc.ClientContext(), e.g. for a mock provider in tests.
+func NewOIDCProvider(ctx context.Context, config OIDCConfig, redirectURL string) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("yana.NewOIDCProvider() -> Discovery wasn't succesful: %w", err)
+	}
+	scopes := append([]string{oidc.ScopeOpenID}, config.Scopes...)
+	return &OIDCProvider{
+		Config:   config,
+		provider: provider,
+		oauth2Config: oauth2.Config{
+			ClientID:     config.ClientId,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientId}),
+	}, nil
+}
+
+// Returns the provider from config/oidc.yml or ErrOIDCDisabled.
+// If the discovery fails (e.g. because the provider is down), it's tried again on the next call.
+func GetOIDCProvider() (*OIDCProvider, error) {
+	oidcProviderMutex.Lock()
+	defer oidcProviderMutex.Unlock()
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+	config := getOIDCConfig()
+	if !config.Enabled {
+		return nil, ErrOIDCDisabled
+	}
+	provider, err := NewOIDCProvider(context.Background(), config, buildLink("/oidc-callback"))
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = provider
+	return oidcProvider, nil
+}
+
+// Returns the name for the login button or "" if single sign-on is disabled.
+// Doesn't need the provider to be reachable.
+func GetOIDCLoginName() string {
+	config := getOIDCConfig()
+	if !config.Enabled {
+		return ""
+	}
+	if config.Name == "" {
+		return "Single sign-on"
+	}
+	return config.Name
+}
+
+// Returns the URL the user has to be sent to and the state, which should be stored
+// in a cookie so that the callback can only be finished by the same browser.
+func (provider *OIDCProvider) BeginLogin() (string, string, error) {
+	state, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", "", fmt.Errorf("yana.OIDCProvider.BeginLogin() -> Couldn't generate state: %w", err)
+	}
+	nonce, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", "", fmt.Errorf("yana.OIDCProvider.BeginLogin() -> Couldn't generate nonce: %w", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	db, err := getDatabase()
+	if err != nil {
+		return "", "", fmt.Errorf("yana.OIDCProvider.BeginLogin() -> Couldn't get database: %w", err)
+	}
+	now := time.Now().UTC()
+	_, err = db.Exec(`DELETE FROM oidc_login WHERE expires_at_utc < $1`, now)
+	if err != nil {
+		fmt.Println("yana.OIDCProvider.BeginLogin() -> Couldn't delete expired logins:", err)
+	}
+	query := `INSERT INTO oidc_login (statehash, nonce, codeverifier, expires_at_utc) VALUES ($1, $2, $3, $4)`
+	_, err = db.Exec(query, hashToken(state), nonce, verifier, now.Add(OIDC_LOGIN_LIFETIME))
+	if err != nil {
+		return "", "", fmt.Errorf("yana.OIDCProvider.BeginLogin() -> Insert query wasn't succesful: %w", err)
+	}
+	authURL := provider.oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return authURL, state, nil
+}
+
+// Exchanges the code from the callback and validates the ID token (signature, issuer, audience, expiry and nonce).
+// Every state can only be used once.
+func (provider *OIDCProvider) FinishLogin(ctx context.Context, state string, code string) (OIDCIdentity, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("yana.OIDCProvider.FinishLogin() -> Couldn't get database: %w", err)
+	}
+	var nonce string
+	var verifier string
+	query := `DELETE FROM oidc_login WHERE statehash = $1 AND expires_at_utc > $2 RETURNING nonce, codeverifier`
+	err = db.QueryRow(query, hashToken(state), time.Now().UTC()).Scan(&nonce, &verifier)
+	if err == sql.ErrNoRows {
+		return OIDCIdentity{}, ErrOIDCLoginNotFound
+	} else if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("yana.OIDCProvider.FinishLogin() -> Delete query wasn't succesful: %w", err)
+	}
+
+	token, err := provider.oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("yana.OIDCProvider.FinishLogin() -> Couldn't exchange code: %w", err)
+	}
+	rawIDToken, isOk := token.Extra("id_token").(string)
+	if !isOk {
+		return OIDCIdentity{}, fmt.Errorf("yana.OIDCProvider.FinishLogin() -> The token response has no id_token")
+	}
+	idToken, err := provider.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("yana.OIDCProvider.FinishLogin() -> Invalid ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return OIDCIdentity{}, ErrOIDCNonceMismatch
+	}
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("yana.OIDCProvider.FinishLogin() -> Couldn't read claims: %w", err)
+	}
+	return OIDCIdentity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Email:   claims.Email,
+		// Some providers send "true" as a string
+		IsEmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+		Name:            claims.Name,
+	}, nil
+}
+
+// Returns the userid of the account that belongs to identity. If there is none yet,
+// the identity is linked to the account with the same (verified) email,
+// or a new account is created if allowSignup is true.
+func LinkOIDCIdentity(identity OIDCIdentity, allowSignup bool) (string, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.LinkOIDCIdentity() -> Couldn't get database: %w", err)
+	}
+	var userid string
+	query := `SELECT user_id FROM oidc_identity WHERE issuer = $1 AND subject = $2`
+	err = db.QueryRow(query, identity.Issuer, identity.Subject).Scan(&userid)
+	if err == nil {
+		return userid, nil
+	} else if err != sql.ErrNoRows {
+		return "", fmt.Errorf("yana.LinkOIDCIdentity() -> Select query wasn't succesful: %w", err)
+	}
+
+	// Otherwise anyone who can create an account with someone else's email at the provider could take over their account
+	if identity.Email == "" || !identity.IsEmailVerified {
+		return "", ErrOIDCEmailNotVerified
+	}
+	err = db.QueryRow(`SELECT id FROM user_ WHERE email = $1`, identity.Email).Scan(&userid)
+	if err == sql.ErrNoRows {
+		if !allowSignup {
+			return "", ErrOIDCSignupDisabled
+		}
+		userid, err = ProvisionExternalUser(identity.Email, identity.Name)
+		if err == ErrExternalSignupClosed {
+			return "", err
+		} else if err != nil {
+			return "", fmt.Errorf("yana.LinkOIDCIdentity() -> Couldn't create user: %w", err)
+		}
+	} else if err != nil {
+		return "", fmt.Errorf("yana.LinkOIDCIdentity() -> Select query wasn't succesful: %w", err)
+	} else {
+		// The provider has confirmed the email, so there's no need for the verification link anymore
+		_, err = db.Exec(`UPDATE user_ SET email_verified = TRUE WHERE id = $1`, userid)
+		if err != nil {
+			fmt.Println("yana.LinkOIDCIdentity() -> Couldn't mark email as verified:", err)
+		}
+	}
+
+	query = `INSERT INTO oidc_identity (issuer, subject, user_id, created_at_utc) VALUES ($1, $2, $3, $4)`
+	_, err = db.Exec(query, identity.Issuer, identity.Subject, userid, time.Now().UTC())
+	if err != nil {
+		return "", fmt.Errorf("yana.LinkOIDCIdentity() -> Insert query wasn't succesful: %w", err)
+	}
+	return userid, nil
+}
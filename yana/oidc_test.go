@@ -0,0 +1,361 @@
+package yana
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_OIDC_CLIENT_ID     = "yana-test"
+	TEST_OIDC_CLIENT_SECRET = "secret"
+	TEST_OIDC_KEY_ID        = "test-key"
+)
+
+// What the mock provider knows about a code it handed out
+type mockOIDCCode struct {
+	codeChallenge string
+	claims        map[string]any
+}
+
+// An OpenID Connect provider with discovery, JWKS and a token endpoint.
+// There is no login page: the test takes the state, nonce and PKCE challenge from the URL
+// of BeginLogin() and gets a code from issueCode() instead
+type mockOIDCProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	mutex  sync.Mutex
+	codes  map[string]mockOIDCCode
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+	mock := &mockOIDCProvider{t: t, key: key, codes: map[string]mockOIDCCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", mock.serveDiscovery)
+	mux.HandleFunc("/jwks", mock.serveJWKS)
+	mux.HandleFunc("/token", mock.serveToken)
+	mock.server = httptest.NewServer(mux)
+	t.Cleanup(mock.server.Close)
+	return mock
+}
+
+func (mock *mockOIDCProvider) issuer() string {
+	return mock.server.URL
+}
+
+func writeTestJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(value)
+}
+
+func (mock *mockOIDCProvider) serveDiscovery(writer http.ResponseWriter, request *http.Request) {
+	writeTestJSON(writer, http.StatusOK, map[string]any{
+		"issuer":                                mock.issuer(),
+		"authorization_endpoint":                mock.issuer() + "/authorize",
+		"token_endpoint":                        mock.issuer() + "/token",
+		"jwks_uri":                              mock.issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (mock *mockOIDCProvider) serveJWKS(writer http.ResponseWriter, request *http.Request) {
+	writeTestJSON(writer, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": TEST_OIDC_KEY_ID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(mock.key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(mock.key.PublicKey.E)).Bytes()),
+		}},
+	})
+}
+
+// Like a real provider, a code only works once, for the right client and with the PKCE verifier of its challenge
+func (mock *mockOIDCProvider) serveToken(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		writeTestJSON(writer, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientId, clientSecret, isOk := request.BasicAuth()
+	if !isOk {
+		clientId, clientSecret = request.PostForm.Get("client_id"), request.PostForm.Get("client_secret")
+	}
+	if clientId != TEST_OIDC_CLIENT_ID || clientSecret != TEST_OIDC_CLIENT_SECRET {
+		writeTestJSON(writer, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	mock.mutex.Lock()
+	code, isFound := mock.codes[request.PostForm.Get("code")]
+	delete(mock.codes, request.PostForm.Get("code"))
+	mock.mutex.Unlock()
+	verifierHash := sha256.Sum256([]byte(request.PostForm.Get("code_verifier")))
+	if !isFound || request.PostForm.Get("grant_type") != "authorization_code" ||
+		base64.RawURLEncoding.EncodeToString(verifierHash[:]) != code.codeChallenge {
+		writeTestJSON(writer, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	writeTestJSON(writer, http.StatusOK, map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     mock.signIDToken(code.claims),
+	})
+}
+
+// Returns claims as an RS256 JWT signed with the key from the JWKS
+func (mock *mockOIDCProvider) signIDToken(claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": TEST_OIDC_KEY_ID})
+	if err != nil {
+		mock.t.Errorf("json.Marshal() = %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		mock.t.Errorf("json.Marshal() = %v", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, mock.key, crypto.SHA256, hash[:])
+	if err != nil {
+		mock.t.Errorf("rsa.SignPKCS1v15() = %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// The claims a correct ID token for authURL would have
+func (mock *mockOIDCProvider) claimsFor(authURL *url.URL, subject, email string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":            mock.issuer(),
+		"sub":            subject,
+		"aud":            TEST_OIDC_CLIENT_ID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          authURL.Query().Get("nonce"),
+		"email":          email,
+		"email_verified": true,
+		"name":           "OIDC User",
+	}
+}
+
+// What the login page of the provider would do: remember the challenge and send the user back with a code
+func (mock *mockOIDCProvider) issueCode(codeChallenge string, claims map[string]any) string {
+	code, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		mock.t.Fatalf("generateToken() = %v", err)
+	}
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	mock.codes[code] = mockOIDCCode{codeChallenge: codeChallenge, claims: claims}
+	return code
+}
+
+func newTestOIDCProvider(t *testing.T) (*mockOIDCProvider, *OIDCProvider) {
+	t.Helper()
+	newTestSQLite(t)
+	mock := newMockOIDCProvider(t)
+	config := OIDCConfig{
+		Enabled:      true,
+		Issuer:       mock.issuer(),
+		ClientId:     TEST_OIDC_CLIENT_ID,
+		ClientSecret: TEST_OIDC_CLIENT_SECRET,
+		Scopes:       []string{"email", "profile"},
+		AllowSignup:  true,
+	}
+	provider, err := NewOIDCProvider(context.Background(), config, "https://notes.example.com/oidc-callback")
+	if err != nil {
+		t.Fatalf("NewOIDCProvider() = %v", err)
+	}
+	return mock, provider
+}
+
+func beginTestOIDCLogin(t *testing.T, provider *OIDCProvider) (*url.URL, string) {
+	t.Helper()
+	rawAuthURL, state, err := provider.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin() = %v", err)
+	}
+	authURL, err := url.Parse(rawAuthURL)
+	if err != nil {
+		t.Fatalf("url.Parse() = %v", err)
+	}
+	return authURL, state
+}
+
+func TestOIDCLogin(t *testing.T) {
+	mock, provider := newTestOIDCProvider(t)
+	authURL, state := beginTestOIDCLogin(t, provider)
+
+	query := authURL.Query()
+	if query.Get("state") != state || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" || query.Get("nonce") == "" {
+		t.Fatalf("BeginLogin() URL = %s, want state, nonce and an S256 code challenge", authURL)
+	}
+	code := mock.issueCode(query.Get("code_challenge"), mock.claimsFor(authURL, "subject-1", "oidc@example.com"))
+
+	identity, err := provider.FinishLogin(context.Background(), state, code)
+	if err != nil {
+		t.Fatalf("FinishLogin() = %v", err)
+	}
+	want := OIDCIdentity{Issuer: mock.issuer(), Subject: "subject-1", Email: "oidc@example.com", IsEmailVerified: true, Name: "OIDC User"}
+	if identity != want {
+		t.Errorf("FinishLogin() = %+v, want %+v", identity, want)
+	}
+
+	// Every state only works once
+	_, err = provider.FinishLogin(context.Background(), state, code)
+	if err != ErrOIDCLoginNotFound {
+		t.Errorf("FinishLogin() with used state = %v, want ErrOIDCLoginNotFound", err)
+	}
+}
+
+// A code that was requested with another PKCE challenge (e.g. one an attacker got for their own login)
+// can't be exchanged, because only the verifier of this login is sent
+func TestOIDCLoginWithOtherCodeChallenge(t *testing.T) {
+	mock, provider := newTestOIDCProvider(t)
+	authURL, state := beginTestOIDCLogin(t, provider)
+	otherAuthURL, otherState := beginTestOIDCLogin(t, provider)
+
+	code := mock.issueCode(otherAuthURL.Query().Get("code_challenge"), mock.claimsFor(authURL, "subject-1", "oidc@example.com"))
+	_, err := provider.FinishLogin(context.Background(), state, code)
+	if err == nil {
+		t.Errorf("FinishLogin() with the code of another challenge = nil, want an error")
+	}
+	_, err = provider.FinishLogin(context.Background(), otherState, "unknown-code")
+	if err == nil {
+		t.Errorf("FinishLogin() with an unknown code = nil, want an error")
+	}
+}
+
+func TestOIDCLoginWithInvalidIDToken(t *testing.T) {
+	for _, testCase := range []struct {
+		name   string
+		change func(claims map[string]any)
+		want   error // nil means any error
+	}{
+		{"OtherNonce", func(claims map[string]any) { claims["nonce"] = "other" }, ErrOIDCNonceMismatch},
+		{"MissingNonce", func(claims map[string]any) { delete(claims, "nonce") }, ErrOIDCNonceMismatch},
+		{"OtherIssuer", func(claims map[string]any) { claims["iss"] = "https://evil.example.com" }, nil},
+		{"OtherAudience", func(claims map[string]any) { claims["aud"] = "other-client" }, nil},
+		{"Expired", func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }, nil},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			mock, provider := newTestOIDCProvider(t)
+			authURL, state := beginTestOIDCLogin(t, provider)
+			claims := mock.claimsFor(authURL, "subject-1", "oidc@example.com")
+			testCase.change(claims)
+			code := mock.issueCode(authURL.Query().Get("code_challenge"), claims)
+
+			identity, err := provider.FinishLogin(context.Background(), state, code)
+			if err == nil || (testCase.want != nil && err != testCase.want) {
+				t.Errorf("FinishLogin() = %+v, %v, want %v", identity, err, testCase.want)
+			}
+		})
+	}
+}
+
+func insertOIDCTestUser(t *testing.T, email string, isEmailVerified bool) string {
+	t.Helper()
+	user := User{
+		UserId:          generateUserID(),
+		Email:           email,
+		IsEmailVerified: isEmailVerified,
+		CreatedAtUTC:    sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	}
+	err := getRepository().InsertUser(user, "hash")
+	if err != nil {
+		t.Fatalf("InsertUser() = %v", err)
+	}
+	return user.UserId
+}
+
+func TestLinkOIDCIdentityToExistingUser(t *testing.T) {
+	newTestSQLite(t)
+	userid := insertOIDCTestUser(t, "existing@example.com", false)
+	identity := OIDCIdentity{Issuer: "https://idp.example.com", Subject: "subject-1", Email: "Existing@example.com", IsEmailVerified: true}
+
+	linkedUserid, err := LinkOIDCIdentity(identity, false)
+	if err != nil || linkedUserid != userid {
+		t.Fatalf("LinkOIDCIdentity() = %q, %v, want the existing user %q", linkedUserid, err, userid)
+	}
+	user, err := GetUserFromUserID(userid)
+	if err != nil || !user.IsEmailVerified {
+		t.Errorf("GetUserFromUserID() after linking = %+v, %v, want a verified email", user, err)
+	}
+
+	// Once linked, the identity is found by issuer and subject even if the email changes at the provider
+	identity.Email = "changed@example.com"
+	identity.IsEmailVerified = false
+	linkedUserid, err = LinkOIDCIdentity(identity, false)
+	if err != nil || linkedUserid != userid {
+		t.Errorf("LinkOIDCIdentity() with changed email = %q, %v, want %q", linkedUserid, err, userid)
+	}
+}
+
+// Otherwise anyone could take over an account by using its email at the provider
+func TestLinkOIDCIdentityWithUnverifiedEmail(t *testing.T) {
+	newTestSQLite(t)
+	insertOIDCTestUser(t, "existing@example.com", true)
+	identity := OIDCIdentity{Issuer: "https://idp.example.com", Subject: "attacker", Email: "existing@example.com", IsEmailVerified: false}
+
+	_, err := LinkOIDCIdentity(identity, true)
+	if err != ErrOIDCEmailNotVerified {
+		t.Errorf("LinkOIDCIdentity() with unverified email = %v, want ErrOIDCEmailNotVerified", err)
+	}
+}
+
+func TestLinkOIDCIdentityProvisionsNewUser(t *testing.T) {
+	newTestSQLite(t)
+	identity := OIDCIdentity{Issuer: "https://idp.example.com", Subject: "subject-1", Email: "new@example.com", IsEmailVerified: true, Name: "New User"}
+
+	_, err := LinkOIDCIdentity(identity, false)
+	if err != ErrOIDCSignupDisabled {
+		t.Errorf("LinkOIDCIdentity() without allowSignup = %v, want ErrOIDCSignupDisabled", err)
+	}
+	serverConfig.Registration = REGISTRATION_CLOSED
+	_, err = LinkOIDCIdentity(identity, true)
+	if err != ErrExternalSignupClosed {
+		t.Errorf("LinkOIDCIdentity() with closed registration = %v, want ErrExternalSignupClosed", err)
+	}
+	serverConfig.Registration = REGISTRATION_OPEN
+
+	userid, err := LinkOIDCIdentity(identity, true)
+	if err != nil {
+		t.Fatalf("LinkOIDCIdentity() = %v", err)
+	}
+	user, err := GetUserFromUserID(userid)
+	if err != nil || user.Email != "new@example.com" || user.FullName != "New User" || !user.IsEmailVerified || user.HasPassword {
+		t.Errorf("GetUserFromUserID() of provisioned user = %+v, %v, want a verified account without password", user, err)
+	}
+	store, _ := getContentStore()
+	namespaces, err := store.ListNamespaces()
+	if err != nil || len(namespaces) != 1 || namespaces[0] != userid {
+		t.Errorf("ListNamespaces() = %q, %v, want the bucket of the new user", namespaces, err)
+	}
+	linkedUserid, err := LinkOIDCIdentity(identity, false)
+	if err != nil || linkedUserid != userid {
+		t.Errorf("LinkOIDCIdentity() again without allowSignup = %q, %v, want %q", linkedUserid, err, userid)
+	}
+}
@@ -0,0 +1,324 @@
+package yana
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+/*
+ * Passkeys and security keys (WebAuthn).
+ * Every ceremony has two steps: Begin...() returns the options for navigator.credentials in the browser,
+ * Finish...() checks what the browser sent back. Between the two steps the challenge is kept
+ * in the webauthn_ceremony table, found through a token in a cookie.
+ */
+
+const WEBAUTHN_CEREMONY_LIFETIME = 5 * time.Minute
+
+var ErrCeremonyNotFound = errors.New("passkey ceremony not found or expired")
+var ErrPasskeyCloned = errors.New("the sign counter of the passkey went backwards, it might have been cloned")
+
+type Passkey struct {
+	Id            string // base64url encoded credential id
+	Name          string
+	CreatedAtUTC  time.Time
+	LastUsedAtUTC sql.NullTime
+}
+
+// Implements webauthn.User
+type passkeyUser struct {
+	user        User
+	credentials []webauthn.Credential
+}
+
+// The user id is used as the user handle so that a discoverable login can find the user again
+func (passkeyUser passkeyUser) WebAuthnID() []byte {
+	return []byte(passkeyUser.user.UserId)
+}
+
+func (passkeyUser passkeyUser) WebAuthnName() string {
+	return passkeyUser.user.Email
+}
+
+func (passkeyUser passkeyUser) WebAuthnDisplayName() string {
+	if passkeyUser.user.FullName == "" {
+		return passkeyUser.user.Email
+	}
+	return passkeyUser.user.FullName
+}
+
+func (passkeyUser passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return passkeyUser.credentials
+}
+
+// The relying party is derived from baseurl in config/server.yml,
+// e.g. https://notes.example.com is the RP ID notes.example.com
+func newWebAuthn() (*webauthn.WebAuthn, error) {
+	baseURL, err := url.Parse(GetServerConfig().BaseURL)
+	if err != nil || baseURL.Hostname() == "" {
+		return nil, fmt.Errorf("yana.newWebAuthn() -> baseurl in config/server.yml isn't a valid URL")
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          baseURL.Hostname(),
+		RPDisplayName: TOTP_ISSUER,
+		RPOrigins:     []string{baseURL.Scheme + "://" + baseURL.Host},
+	})
+}
+
+func encodeCredentialId(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func getWebAuthnCredentials(db *sql.DB, userid string) ([]webauthn.Credential, error) {
+	rows, err := db.Query(`SELECT credential FROM webauthn_credential WHERE user_id = $1`, userid)
+	if err != nil {
+		return nil, fmt.Errorf("Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	var credentials []webauthn.Credential
+	for rows.Next() {
+		var credentialJSON string
+		err = rows.Scan(&credentialJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't scan row: %w", err)
+		}
+		var credential webauthn.Credential
+		err = json.Unmarshal([]byte(credentialJSON), &credential)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't decode credential: %w", err)
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+func getPasskeyUser(db *sql.DB, userid string) (passkeyUser, error) {
+	var user User
+	query := `SELECT id, email, fullname FROM user_ WHERE id = $1`
+	err := db.QueryRow(query, userid).Scan(&user.UserId, &user.Email, &user.FullName)
+	if err != nil {
+		return passkeyUser{}, fmt.Errorf("Couldn't find user: %w", err)
+	}
+	credentials, err := getWebAuthnCredentials(db, userid)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	return passkeyUser{user: user, credentials: credentials}, nil
+}
+
+// Returns the token for the ceremony cookie
+func saveCeremony(db *sql.DB, userid string, sessionData *webauthn.SessionData) (string, error) {
+	token, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", err
+	}
+	sessionDataJSON, err := json.Marshal(sessionData)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't encode session data: %w", err)
+	}
+	now := time.Now().UTC()
+	_, err = db.Exec(`DELETE FROM webauthn_ceremony WHERE expires_at_utc < $1`, now)
+	if err != nil {
+		fmt.Println("yana.saveCeremony() -> Couldn't delete expired ceremonies:", err)
+	}
+	query := `INSERT INTO webauthn_ceremony (tokenhash, user_id, sessiondata, expires_at_utc) VALUES ($1, $2, $3, $4)`
+	_, err = db.Exec(query, hashToken(token), sql.NullString{String: userid, Valid: userid != ""}, string(sessionDataJSON), now.Add(WEBAUTHN_CEREMONY_LIFETIME))
+	if err != nil {
+		return "", fmt.Errorf("Insert query wasn't succesful: %w", err)
+	}
+	return token, nil
+}
+
+// Every ceremony can only be finished once, so it's deleted while it's loaded
+func takeCeremony(db *sql.DB, token string) (string, webauthn.SessionData, error) {
+	var userid sql.NullString
+	var sessionDataJSON string
+	query := `DELETE FROM webauthn_ceremony WHERE tokenhash = $1 AND expires_at_utc > $2 RETURNING user_id, sessiondata`
+	err := db.QueryRow(query, hashToken(token), time.Now().UTC()).Scan(&userid, &sessionDataJSON)
+	if err == sql.ErrNoRows {
+		return "", webauthn.SessionData{}, ErrCeremonyNotFound
+	} else if err != nil {
+		return "", webauthn.SessionData{}, fmt.Errorf("Delete query wasn't succesful: %w", err)
+	}
+	var sessionData webauthn.SessionData
+	err = json.Unmarshal([]byte(sessionDataJSON), &sessionData)
+	if err != nil {
+		return "", webauthn.SessionData{}, fmt.Errorf("Couldn't decode session data: %w", err)
+	}
+	return userid.String, sessionData, nil
+}
+
+// Returns the options for navigator.credentials.create() and the token for the ceremony cookie
+func BeginPasskeyRegistration(userid string) (*protocol.CredentialCreation, string, error) {
+	webAuthn, err := newWebAuthn()
+	if err != nil {
+		return nil, "", err
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return nil, "", fmt.Errorf("yana.BeginPasskeyRegistration() -> Couldn't get database: %w", err)
+	}
+	user, err := getPasskeyUser(db, userid)
+	if err != nil {
+		return nil, "", fmt.Errorf("yana.BeginPasskeyRegistration() -> %w", err)
+	}
+	creation, sessionData, err := webAuthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()))
+	if err != nil {
+		return nil, "", fmt.Errorf("yana.BeginPasskeyRegistration() -> %w", err)
+	}
+	token, err := saveCeremony(db, userid, sessionData)
+	if err != nil {
+		return nil, "", fmt.Errorf("yana.BeginPasskeyRegistration() -> %w", err)
+	}
+	return creation, token, nil
+}
+
+// request is the request with the response of navigator.credentials.create() as its body
+func FinishPasskeyRegistration(userid, ceremonyToken, name string, request *http.Request) error {
+	webAuthn, err := newWebAuthn()
+	if err != nil {
+		return err
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.FinishPasskeyRegistration() -> Couldn't get database: %w", err)
+	}
+	ceremonyUserid, sessionData, err := takeCeremony(db, ceremonyToken)
+	if err != nil {
+		return fmt.Errorf("yana.FinishPasskeyRegistration() -> %w", err)
+	}
+	if ceremonyUserid != userid {
+		return ErrCeremonyNotFound
+	}
+	user, err := getPasskeyUser(db, userid)
+	if err != nil {
+		return fmt.Errorf("yana.FinishPasskeyRegistration() -> %w", err)
+	}
+	credential, err := webAuthn.FinishRegistration(user, sessionData, request)
+	if err != nil {
+		return fmt.Errorf("yana.FinishPasskeyRegistration() -> Couldn't verify passkey: %w", err)
+	}
+	credentialJSON, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("yana.FinishPasskeyRegistration() -> Couldn't encode credential: %w", err)
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+	query := `INSERT INTO webauthn_credential (id, user_id, name, credential, signcount, created_at_utc) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = db.Exec(query, encodeCredentialId(credential.ID), userid, name, string(credentialJSON),
+		int64(credential.Authenticator.SignCount), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("yana.FinishPasskeyRegistration() -> Insert query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+// Starts a login without knowing the user yet: the browser offers every passkey it has for this site.
+// Returns the options for navigator.credentials.get() and the token for the ceremony cookie
+func BeginPasskeyLogin() (*protocol.CredentialAssertion, string, error) {
+	webAuthn, err := newWebAuthn()
+	if err != nil {
+		return nil, "", err
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return nil, "", fmt.Errorf("yana.BeginPasskeyLogin() -> Couldn't get database: %w", err)
+	}
+	assertion, sessionData, err := webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", fmt.Errorf("yana.BeginPasskeyLogin() -> %w", err)
+	}
+	token, err := saveCeremony(db, "", sessionData)
+	if err != nil {
+		return nil, "", fmt.Errorf("yana.BeginPasskeyLogin() -> %w", err)
+	}
+	return assertion, token, nil
+}
+
+// request is the request with the response of navigator.credentials.get() as its body.
+// Returns the id of the user the passkey belongs to.
+func FinishPasskeyLogin(ceremonyToken string, request *http.Request) (string, error) {
+	webAuthn, err := newWebAuthn()
+	if err != nil {
+		return "", err
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.FinishPasskeyLogin() -> Couldn't get database: %w", err)
+	}
+	_, sessionData, err := takeCeremony(db, ceremonyToken)
+	if err != nil {
+		return "", fmt.Errorf("yana.FinishPasskeyLogin() -> %w", err)
+	}
+	findUser := func(rawId, userHandle []byte) (webauthn.User, error) {
+		return getPasskeyUser(db, string(userHandle))
+	}
+	webAuthnUser, credential, err := webAuthn.FinishPasskeyLogin(findUser, sessionData, request)
+	if err != nil {
+		return "", fmt.Errorf("yana.FinishPasskeyLogin() -> Couldn't verify passkey: %w", err)
+	}
+	if credential.Authenticator.CloneWarning {
+		return "", ErrPasskeyCloned
+	}
+	userid := string(webAuthnUser.WebAuthnID())
+
+	// The sign counter has to be saved, otherwise a cloned authenticator can't be detected next time
+	credentialJSON, err := json.Marshal(credential)
+	if err != nil {
+		return "", fmt.Errorf("yana.FinishPasskeyLogin() -> Couldn't encode credential: %w", err)
+	}
+	query := `UPDATE webauthn_credential SET credential = $1, signcount = $2, last_used_at_utc = $3 WHERE id = $4 AND user_id = $5`
+	_, err = db.Exec(query, string(credentialJSON), int64(credential.Authenticator.SignCount), time.Now().UTC(),
+		encodeCredentialId(credential.ID), userid)
+	if err != nil {
+		return "", fmt.Errorf("yana.FinishPasskeyLogin() -> Update query wasn't succesful: %w", err)
+	}
+	return userid, nil
+}
+
+func GetPasskeys(userid string) ([]Passkey, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetPasskeys() -> Couldn't get database: %w", err)
+	}
+	query := `SELECT id, name, created_at_utc, last_used_at_utc FROM webauthn_credential WHERE user_id = $1 ORDER BY created_at_utc`
+	rows, err := db.Query(query, userid)
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetPasskeys() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	var passkeys []Passkey
+	for rows.Next() {
+		var passkey Passkey
+		err = rows.Scan(&passkey.Id, &passkey.Name, &passkey.CreatedAtUTC, &passkey.LastUsedAtUTC)
+		if err != nil {
+			return nil, fmt.Errorf("yana.GetPasskeys() -> Couldn't scan row: %w", err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	return passkeys, rows.Err()
+}
+
+// The userid is checked so that users can only delete their own passkeys
+func DeletePasskey(userid, passkeyId string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.DeletePasskey() -> Couldn't get database: %w", err)
+	}
+	_, err = db.Exec(`DELETE FROM webauthn_credential WHERE id = $1 AND user_id = $2`, passkeyId, userid)
+	if err != nil {
+		return fmt.Errorf("yana.DeletePasskey() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
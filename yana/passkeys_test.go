@@ -0,0 +1,293 @@
+package yana
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	AUTHENTICATOR_FLAG_USER_PRESENT  = 0x01
+	AUTHENTICATOR_FLAG_USER_VERIFIED = 0x04
+	AUTHENTICATOR_FLAG_ATTESTED      = 0x40
+)
+
+// A passkey in software: an ECDSA P-256 key with "none" attestation,
+// which answers the options from Begin...() like navigator.credentials in a browser would
+type softwareAuthenticator struct {
+	t            *testing.T
+	origin       string
+	rpId         string
+	credentialId []byte
+	privateKey   *ecdsa.PrivateKey
+	signCount    uint32
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	t.Helper()
+	baseURL, err := url.Parse(GetServerConfig().BaseURL)
+	if err != nil {
+		t.Fatalf("url.Parse() = %v", err)
+	}
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	credentialId := make([]byte, 16)
+	rand.Read(credentialId)
+	return &softwareAuthenticator{
+		t:            t,
+		origin:       baseURL.Scheme + "://" + baseURL.Host,
+		rpId:         baseURL.Hostname(),
+		credentialId: credentialId,
+		privateKey:   privateKey,
+	}
+}
+
+func (authenticator *softwareAuthenticator) clientDataJSON(ceremonyType protocol.CeremonyType, challenge protocol.URLEncodedBase64) []byte {
+	clientData, err := json.Marshal(protocol.CollectedClientData{
+		Type:      ceremonyType,
+		Challenge: challenge.String(),
+		Origin:    authenticator.origin,
+	})
+	if err != nil {
+		authenticator.t.Fatalf("json.Marshal() = %v", err)
+	}
+	return clientData
+}
+
+// RP ID hash, flags and sign counter, see https://www.w3.org/TR/webauthn-2/#sctn-authenticator-data
+func (authenticator *softwareAuthenticator) authenticatorData(flags byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(authenticator.rpId))
+	data := append(rpIdHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, authenticator.signCount)
+}
+
+// Returns the request with the response of navigator.credentials.create()
+func (authenticator *softwareAuthenticator) create(creation *protocol.CredentialCreation) *http.Request {
+	t := authenticator.t
+	t.Helper()
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: authenticator.privateKey.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: authenticator.privateKey.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("webauthncbor.Marshal() = %v", err)
+	}
+	authData := authenticator.authenticatorData(AUTHENTICATOR_FLAG_USER_PRESENT | AUTHENTICATOR_FLAG_USER_VERIFIED | AUTHENTICATOR_FLAG_ATTESTED)
+	authData = append(authData, make([]byte, 16)...) // AAGUID, all zero without attestation
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(authenticator.credentialId)))
+	authData = append(authData, authenticator.credentialId...)
+	authData = append(authData, publicKey...)
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatalf("webauthncbor.Marshal() = %v", err)
+	}
+	return authenticator.newRequest(map[string]any{
+		"clientDataJSON":    authenticator.clientDataJSON(protocol.CreateCeremony, creation.Response.Challenge),
+		"attestationObject": attestationObject,
+	})
+}
+
+// Returns the request with the response of navigator.credentials.get() and counts the use like a real passkey
+func (authenticator *softwareAuthenticator) get(assertion *protocol.CredentialAssertion, userid string) *http.Request {
+	t := authenticator.t
+	t.Helper()
+	authenticator.signCount++
+	clientData := authenticator.clientDataJSON(protocol.AssertCeremony, assertion.Response.Challenge)
+	authData := authenticator.authenticatorData(AUTHENTICATOR_FLAG_USER_PRESENT | AUTHENTICATOR_FLAG_USER_VERIFIED)
+	clientDataHash := sha256.Sum256(clientData)
+	signedHash := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, authenticator.privateKey, signedHash[:])
+	if err != nil {
+		t.Fatalf("ecdsa.SignASN1() = %v", err)
+	}
+	return authenticator.newRequest(map[string]any{
+		"clientDataJSON":    clientData,
+		"authenticatorData": authData,
+		"signature":         signature,
+		"userHandle":        []byte(userid),
+	})
+}
+
+// Every []byte in response is base64url encoded like in the JSON the browser sends
+func (authenticator *softwareAuthenticator) newRequest(response map[string]any) *http.Request {
+	t := authenticator.t
+	t.Helper()
+	encodedResponse := map[string]string{}
+	for key, value := range response {
+		encodedResponse[key] = base64.RawURLEncoding.EncodeToString(value.([]byte))
+	}
+	id := base64.RawURLEncoding.EncodeToString(authenticator.credentialId)
+	body, err := json.Marshal(map[string]any{"id": id, "rawId": id, "type": "public-key", "response": encodedResponse})
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	return newPasskeyRequest(body)
+}
+
+func newPasskeyRequest(body []byte) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	return request
+}
+
+func newPasskeyTestUser(t *testing.T) string {
+	t.Helper()
+	newTestSQLite(t)
+	serverConfig.BaseURL = "https://notes.example.com"
+	user := User{
+		UserId:       generateUserID(),
+		Email:        "passkey@example.com",
+		FullName:     "Passkey User",
+		CreatedAtUTC: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	}
+	err := getRepository().InsertUser(user, "")
+	if err != nil {
+		t.Fatalf("InsertUser() = %v", err)
+	}
+	return user.UserId
+}
+
+func registerSoftwarePasskey(t *testing.T, userid string) *softwareAuthenticator {
+	t.Helper()
+	authenticator := newSoftwareAuthenticator(t)
+	creation, ceremonyToken, err := BeginPasskeyRegistration(userid)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration() = %v", err)
+	}
+	err = FinishPasskeyRegistration(userid, ceremonyToken, "Test key", authenticator.create(creation))
+	if err != nil {
+		t.Fatalf("FinishPasskeyRegistration() = %v", err)
+	}
+	return authenticator
+}
+
+func loginWithSoftwarePasskey(t *testing.T, authenticator *softwareAuthenticator, userid string) (string, error) {
+	t.Helper()
+	assertion, ceremonyToken, err := BeginPasskeyLogin()
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin() = %v", err)
+	}
+	return FinishPasskeyLogin(ceremonyToken, authenticator.get(assertion, userid))
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	userid := newPasskeyTestUser(t)
+	authenticator := registerSoftwarePasskey(t, userid)
+
+	passkeys, err := GetPasskeys(userid)
+	if err != nil || len(passkeys) != 1 || passkeys[0].Name != "Test key" || passkeys[0].LastUsedAtUTC.Valid {
+		t.Fatalf("GetPasskeys() = %+v, %v, want one unused passkey named Test key", passkeys, err)
+	}
+	if passkeys[0].Id != encodeCredentialId(authenticator.credentialId) {
+		t.Errorf("Passkey.Id = %q, want %q", passkeys[0].Id, encodeCredentialId(authenticator.credentialId))
+	}
+
+	for i := 0; i < 2; i++ {
+		loggedInUserid, err := loginWithSoftwarePasskey(t, authenticator, userid)
+		if err != nil || loggedInUserid != userid {
+			t.Fatalf("FinishPasskeyLogin() = %q, %v, want %q", loggedInUserid, err, userid)
+		}
+	}
+	passkeys, err = GetPasskeys(userid)
+	if err != nil || len(passkeys) != 1 || !passkeys[0].LastUsedAtUTC.Valid {
+		t.Errorf("GetPasskeys() after login = %+v, %v, want LastUsedAtUTC to be set", passkeys, err)
+	}
+}
+
+func TestPasskeyRegistrationOfOtherUser(t *testing.T) {
+	userid := newPasskeyTestUser(t)
+	authenticator := newSoftwareAuthenticator(t)
+	creation, ceremonyToken, err := BeginPasskeyRegistration(userid)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration() = %v", err)
+	}
+	err = FinishPasskeyRegistration(generateUserID(), ceremonyToken, "Test key", authenticator.create(creation))
+	if !errors.Is(err, ErrCeremonyNotFound) {
+		t.Errorf("FinishPasskeyRegistration() by another user = %v, want ErrCeremonyNotFound", err)
+	}
+}
+
+// The ceremony is used up by the first login, so the same response can't log in again
+func TestPasskeyReplayedLogin(t *testing.T) {
+	userid := newPasskeyTestUser(t)
+	authenticator := registerSoftwarePasskey(t, userid)
+	assertion, ceremonyToken, err := BeginPasskeyLogin()
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin() = %v", err)
+	}
+	body, err := io.ReadAll(authenticator.get(assertion, userid).Body)
+	if err != nil {
+		t.Fatalf("io.ReadAll() = %v", err)
+	}
+
+	_, err = FinishPasskeyLogin(ceremonyToken, newPasskeyRequest(body))
+	if err != nil {
+		t.Fatalf("FinishPasskeyLogin() = %v", err)
+	}
+	_, err = FinishPasskeyLogin(ceremonyToken, newPasskeyRequest(body))
+	if !errors.Is(err, ErrCeremonyNotFound) {
+		t.Errorf("FinishPasskeyLogin() with replayed response = %v, want ErrCeremonyNotFound", err)
+	}
+}
+
+// A copy of the passkey doesn't know the counter of the original, so its counter is the same or lower
+func TestPasskeySignCountGoingBackwards(t *testing.T) {
+	for _, testCase := range []struct {
+		name            string
+		signCountChange int
+	}{
+		{"Same", -1}, // get() counts up by one before signing, so this signs with the last count again
+		{"Lower", -2},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			userid := newPasskeyTestUser(t)
+			authenticator := registerSoftwarePasskey(t, userid)
+			for i := 0; i < 3; i++ {
+				_, err := loginWithSoftwarePasskey(t, authenticator, userid)
+				if err != nil {
+					t.Fatalf("FinishPasskeyLogin() = %v", err)
+				}
+			}
+
+			authenticator.signCount = uint32(int(authenticator.signCount) + testCase.signCountChange)
+			_, err := loginWithSoftwarePasskey(t, authenticator, userid)
+			if err != ErrPasskeyCloned {
+				t.Errorf("FinishPasskeyLogin() with sign count %d = %v, want ErrPasskeyCloned", authenticator.signCount, err)
+			}
+			// The original still works afterwards
+			authenticator.signCount = 10
+			_, err = loginWithSoftwarePasskey(t, authenticator, userid)
+			if err != nil {
+				t.Errorf("FinishPasskeyLogin() with higher sign count = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const PASSWORD_RESET_LIFETIME = 30 * time.Minute
+
+// Returned if the token of a reset link is unknown, expired or was used already
+var ErrPasswordResetNotFound = errors.New("password reset link not found, expired or already used")
+
+const PASSWORD_RESET_MAIL = `Hello %s,
+
+someone (hopefully you) asked to reset the password of your YANAgo account.
+Open this link to choose a new password:
+
+%s
+
+The link works once and expires in %d minutes.
+If you didn't ask for this, you can ignore this mail and your password stays the same.
+`
+
+// Mails a reset link to email if there is an account with it.
+// Unknown addresses return nil too, so the response doesn't reveal who has an account.
+// Every call counts towards the limit of ipAddress, see throttle.go
+func RequestPasswordReset(email string, ipAddress string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return YanaError{Code: ConnectionFailed, Err: fmt.Errorf("yana.RequestPasswordReset() -> Couldn't get database: %w", err)}
+	}
+	throttleKey := passwordResetThrottleKey(ipAddress)
+	err = checkThrottle(db, throttleKey)
+	if _, isLocked := err.(LockedError); isLocked {
+		return YanaError{Code: TooManyAttempts, Err: err}
+	} else if err != nil {
+		return err
+	}
+	err = recordThrottleFailure(db, throttleKey, GetServerConfig().PasswordResetMaxMailsPerIP)
+	if err != nil {
+		fmt.Println("yana.RequestPasswordReset() -> Couldn't record attempt:", err)
+	}
+
+	var userid string
+	var fullname string
+	err = db.QueryRow(`SELECT id, fullname FROM user_ WHERE email = $1`, email).Scan(&userid, &fullname)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return YanaError{Code: QueryFailed, Err: fmt.Errorf("yana.RequestPasswordReset() -> Select query wasn't succesful: %w", err)}
+	}
+
+	link, err := createPasswordResetLink(db, userid)
+	if err != nil {
+		return fmt.Errorf("yana.RequestPasswordReset() -> Couldn't create link: %w", err)
+	}
+	err = GetMailer().Send(Mail{
+		To:      email,
+		Subject: "Reset your YANAgo password",
+		Body:    fmt.Sprintf(PASSWORD_RESET_MAIL, fullname, link, int(PASSWORD_RESET_LIFETIME.Minutes())),
+	})
+	if err != nil {
+		return fmt.Errorf("yana.RequestPasswordReset() -> Couldn't send mail: %w", err)
+	}
+	return nil
+}
+
+// Returns the link to /reset-password with a new token for userid.
+// Only the newest link of a user works.
+func createPasswordResetLink(db *sql.DB, userid string) (string, error) {
+	token, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", fmt.Errorf("yana.createPasswordResetLink() -> Couldn't generate token: %w", err)
+	}
+	now := time.Now().UTC()
+	_, err = db.Exec(`DELETE FROM password_reset WHERE user_id = $1 OR expires_at_utc < $2`, userid, now)
+	if err != nil {
+		return "", fmt.Errorf("yana.createPasswordResetLink() -> Delete query wasn't succesful: %w", err)
+	}
+	query := `INSERT INTO password_reset (tokenhash, user_id, expires_at_utc) VALUES ($1, $2, $3)`
+	_, err = db.Exec(query, hashToken(token), userid, now.Add(PASSWORD_RESET_LIFETIME))
+	if err != nil {
+		return "", fmt.Errorf("yana.createPasswordResetLink() -> Insert query wasn't succesful: %w", err)
+	}
+	return buildLink("/reset-password?token=" + url.QueryEscape(token)), nil
+}
+
+// Lets /reset-password tell the user that their link is broken before they type a new password
+func IsPasswordResetTokenValid(token string) (bool, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return false, fmt.Errorf("yana.IsPasswordResetTokenValid() -> Couldn't get database: %w", err)
+	}
+	var userid string
+	query := `SELECT user_id FROM password_reset WHERE tokenhash = $1 AND expires_at_utc > $2`
+	err = db.QueryRow(query, hashToken(token), time.Now().UTC()).Scan(&userid)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("yana.IsPasswordResetTokenValid() -> Select query wasn't succesful: %w", err)
+	}
+	return true, nil
+}
+
+// Uses up the token, sets the new password and logs the user out everywhere,
+// since whoever knew the old password shouldn't stay logged in.
+// Returns ErrPasswordResetNotFound if the token can't be used.
+// Returns string: the userid whose password was changed
+func ResetPassword(token string, newPassword string) (string, error) {
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return "", fmt.Errorf("yana.ResetPassword() -> Couldn't hash password: %w", err)
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.ResetPassword() -> Couldn't get database: %w", err)
+	}
+	transaction, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("yana.ResetPassword() -> Couldn't begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	var userid string
+	query := `DELETE FROM password_reset WHERE tokenhash = $1 AND expires_at_utc > $2 RETURNING user_id`
+	err = transaction.QueryRow(query, hashToken(token), time.Now().UTC()).Scan(&userid)
+	if err == sql.ErrNoRows {
+		return "", ErrPasswordResetNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("yana.ResetPassword() -> Delete query wasn't succesful: %w", err)
+	}
+	_, err = transaction.Exec(`UPDATE user_ SET encryptedpassword = $1, password_reset_required = FALSE WHERE id = $2`, hash, userid)
+	if err != nil {
+		return "", fmt.Errorf("yana.ResetPassword() -> Update query wasn't succesful: %w", err)
+	}
+	for _, table := range []string{"password_reset", "session", "remember_token", "pending_login"} {
+		_, err = transaction.Exec(`DELETE FROM `+table+` WHERE user_id = $1`, userid)
+		if err != nil {
+			return "", fmt.Errorf("yana.ResetPassword() -> Couldn't delete from %s: %w", table, err)
+		}
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return "", fmt.Errorf("yana.ResetPassword() -> Couldn't commit transaction: %w", err)
+	}
+	return userid, nil
+}
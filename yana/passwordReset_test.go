@@ -0,0 +1,133 @@
+package yana
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+)
+
+func newPasswordResetTestUser(t *testing.T, email string) string {
+	t.Helper()
+	hash, err := hashPassword("old password")
+	if err != nil {
+		t.Fatalf("hashPassword() = %v", err)
+	}
+	user := User{UserId: generateUserID(), Email: email, FullName: "Reset User", CreatedAtUTC: sql.NullTime{Time: time.Now().UTC(), Valid: true}}
+	err = getRepository().InsertUser(user, hash)
+	if err != nil {
+		t.Fatalf("InsertUser() = %v", err)
+	}
+	return user.UserId
+}
+
+func TestPasswordResetThroughMail(t *testing.T) {
+	newTestSQLite(t)
+	mailPath := useTestFileMailer(t)
+	userid := newPasswordResetTestUser(t, "reset@example.com")
+	sessionToken, err := CreateSession(userid)
+	if err != nil {
+		t.Fatalf("CreateSession() = %v", err)
+	}
+
+	err = RequestPasswordReset("reset@example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("RequestPasswordReset() = %v", err)
+	}
+	token := readTokenFromMails(t, mailPath, "reset@example.com", "/reset-password")
+	isValid, err := IsPasswordResetTokenValid(token)
+	if err != nil || !isValid {
+		t.Fatalf("IsPasswordResetTokenValid() = %v, %v, want true", isValid, err)
+	}
+
+	resetUserid, err := ResetPassword(token, "new password")
+	if err != nil || resetUserid != userid {
+		t.Fatalf("ResetPassword() = %q, %v, want %q", resetUserid, err, userid)
+	}
+	loggedInUserid, err := PostgreSQLAuthenticator{}.Authenticate("reset@example.com", "new password")
+	if err != nil || loggedInUserid != userid {
+		t.Errorf("Authenticate() with new password = %q, %v, want %q", loggedInUserid, err, userid)
+	}
+	_, err = PostgreSQLAuthenticator{}.Authenticate("reset@example.com", "old password")
+	if GetYanaErrorCode(err) != PasswordsNotEqual {
+		t.Errorf("Authenticate() with old password = %v, want PasswordsNotEqual", err)
+	}
+	_, _, err = GetUserFromSession(sessionToken)
+	if err != ErrSessionNotFound {
+		t.Errorf("GetUserFromSession() after reset = %v, want ErrSessionNotFound", err)
+	}
+
+	// The link only works once
+	_, err = ResetPassword(token, "another password")
+	if err != ErrPasswordResetNotFound {
+		t.Errorf("ResetPassword() with used link = %v, want ErrPasswordResetNotFound", err)
+	}
+	isValid, err = IsPasswordResetTokenValid(token)
+	if err != nil || isValid {
+		t.Errorf("IsPasswordResetTokenValid() of used link = %v, %v, want false", isValid, err)
+	}
+}
+
+func TestPasswordResetLinkExpires(t *testing.T) {
+	db := newTestSQLite(t)
+	mailPath := useTestFileMailer(t)
+	newPasswordResetTestUser(t, "reset@example.com")
+	err := RequestPasswordReset("reset@example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("RequestPasswordReset() = %v", err)
+	}
+	token := readTokenFromMails(t, mailPath, "reset@example.com", "/reset-password")
+
+	_, err = db.Exec(`UPDATE password_reset SET expires_at_utc = $1`, time.Now().UTC().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Update query = %v", err)
+	}
+	isValid, err := IsPasswordResetTokenValid(token)
+	if err != nil || isValid {
+		t.Errorf("IsPasswordResetTokenValid() of expired link = %v, %v, want false", isValid, err)
+	}
+	_, err = ResetPassword(token, "new password")
+	if err != ErrPasswordResetNotFound {
+		t.Errorf("ResetPassword() with expired link = %v, want ErrPasswordResetNotFound", err)
+	}
+}
+
+// Only the newest link of a user works
+func TestPasswordResetLinkIsReplaced(t *testing.T) {
+	newTestSQLite(t)
+	mailPath := useTestFileMailer(t)
+	newPasswordResetTestUser(t, "reset@example.com")
+	err := RequestPasswordReset("reset@example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("RequestPasswordReset() = %v", err)
+	}
+	firstToken := readTokenFromMails(t, mailPath, "reset@example.com", "/reset-password")
+	err = RequestPasswordReset("reset@example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("RequestPasswordReset() = %v", err)
+	}
+	secondToken := readTokenFromMails(t, mailPath, "reset@example.com", "/reset-password")
+
+	_, err = ResetPassword(firstToken, "new password")
+	if err != ErrPasswordResetNotFound {
+		t.Errorf("ResetPassword() with replaced link = %v, want ErrPasswordResetNotFound", err)
+	}
+	_, err = ResetPassword(secondToken, "new password")
+	if err != nil {
+		t.Errorf("ResetPassword() with newest link = %v", err)
+	}
+}
+
+// Unknown addresses look the same as known ones, but nothing is mailed
+func TestPasswordResetOfUnknownEmail(t *testing.T) {
+	newTestSQLite(t)
+	mailPath := useTestFileMailer(t)
+	err := RequestPasswordReset("nobody@example.com", "192.0.2.1")
+	if err != nil {
+		t.Errorf("RequestPasswordReset() of unknown email = %v, want nil", err)
+	}
+	_, err = os.Stat(mailPath)
+	if !os.IsNotExist(err) {
+		t.Errorf("Stat() of mail file = %v, want no mail", err)
+	}
+}
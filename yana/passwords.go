@@ -0,0 +1,127 @@
+package yana
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Every hash is stored in the PHC string format so that the algorithm and its
+// parameters are saved next to the hash itself, e.g.:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+// That way the parameters can be raised later without breaking old hashes.
+const (
+	ARGON2ID_PREFIX  = "$argon2id$"
+	ARGON2_SALT_LEN  = 16
+	ARGON2_KEY_LEN   = 32
+	BCRYPT_PREFIX_2A = "$2a$"
+	BCRYPT_PREFIX_2B = "$2b$"
+	BCRYPT_PREFIX_2Y = "$2y$"
+)
+
+type Argon2Params struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// These are the parameters new hashes are created with.
+// Raising them makes every older hash get rehashed on the next successful login.
+// See https://datatracker.ietf.org/doc/html/rfc9106#section-4 for recommendations
+var PasswordHashParams = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+}
+
+func hashPassword(password string) (string, error) {
+	return hashPasswordWithParams(password, PasswordHashParams)
+}
+
+func hashPasswordWithParams(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, ARGON2_SALT_LEN)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", fmt.Errorf("yana.hashPassword() -> Couldn't generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, ARGON2_KEY_LEN)
+	encoding := base64.RawStdEncoding
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", ARGON2ID_PREFIX, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+}
+
+func parseArgon2idHash(hash string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("yana.parseArgon2idHash() -> Hash has %d parts instead of 6", len(parts))
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("yana.parseArgon2idHash() -> Couldn't read version: %w", err)
+	}
+	if version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("yana.parseArgon2idHash() -> Unsupported argon2 version %d", version)
+	}
+	params := Argon2Params{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("yana.parseArgon2idHash() -> Couldn't read parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("yana.parseArgon2idHash() -> Couldn't decode salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("yana.parseArgon2idHash() -> Couldn't decode key: %w", err)
+	}
+	return params, salt, key, nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, BCRYPT_PREFIX_2A) ||
+		strings.HasPrefix(hash, BCRYPT_PREFIX_2B) ||
+		strings.HasPrefix(hash, BCRYPT_PREFIX_2Y)
+}
+
+// Checks password against storedHash, which can be an argon2id hash, a bcrypt hash
+// or (for accounts created before passwords were hashed) the raw password.
+// needsRehash is true if the password matched but storedHash isn't an argon2id hash
+// with the current PasswordHashParams.
+func verifyPassword(password string, storedHash string) (isMatching bool, needsRehash bool, err error) {
+	switch {
+	case storedHash == "":
+		// Accounts from an external identity provider (see provisioning.go) have no password
+		return false, false, nil
+	case strings.HasPrefix(storedHash, ARGON2ID_PREFIX):
+		params, salt, key, err := parseArgon2idHash(storedHash)
+		if err != nil {
+			return false, false, err
+		}
+		otherKey := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+			return false, false, nil
+		}
+		return true, params != PasswordHashParams, nil
+	case isBcryptHash(storedHash):
+		err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		} else if err != nil {
+			return false, false, fmt.Errorf("yana.verifyPassword() -> Couldn't compare bcrypt hash: %w", err)
+		}
+		return true, true, nil
+	default:
+		// Legacy row: the password was stored without any hashing
+		isMatching := subtle.ConstantTimeCompare([]byte(password), []byte(storedHash)) == 1
+		return isMatching, isMatching, nil
+	}
+}
@@ -0,0 +1,76 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+ * Accounts of users that log in through an external identity provider (OIDC, ...)
+ * are created the first time they log in. They have no password in user_
+ * and their email counts as verified because the provider has checked it already.
+ * Like /register, this only happens while registration is open (see GetRegistrationMode()).
+ * Otherwise only users who already have an account with the same email can log in.
+ */
+
+var ErrExternalUserExists = errors.New("there already is an account with this email")
+var ErrExternalSignupClosed = errors.New("there is no account with this email and registration isn't open")
+
+// Creates the user_ row and the bucket for a user of an external identity provider.
+// If the bucket can't be created, the row is removed again so the next login can try again.
+// Returns ErrExternalSignupClosed unless registration is open.
+// Returns string: uuid of the new user
+func ProvisionExternalUser(email string, fullname string) (string, error) {
+	if GetRegistrationMode() != REGISTRATION_OPEN {
+		return "", ErrExternalSignupClosed
+	}
+	// The provider can send anything, so it's checked like the form of /register
+	fullname, err := validateNewUser(email, fullname)
+	if err != nil {
+		return "", fmt.Errorf("yana.ProvisionExternalUser() -> %w", err)
+	}
+
+	// The email counts as verified because the provider has checked it already, the empty hash means no password
+	user := User{
+		UserId:          generateUserID(),
+		Email:           email,
+		FullName:        fullname,
+		IsEmailVerified: true,
+		CreatedAtUTC:    sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	}
+	err = getRepository().InsertUser(user, "")
+	if err == ErrEmailTaken {
+		return "", ErrExternalUserExists
+	} else if err != nil {
+		return "", fmt.Errorf("yana.ProvisionExternalUser() -> Couldn't insert user: %w", err)
+	}
+
+	err = NewBucket(user.UserId)
+	if err != nil {
+		deleteErr := getRepository().DeleteUser(user.UserId)
+		if deleteErr != nil {
+			fmt.Println("yana.ProvisionExternalUser() -> Couldn't remove user without bucket:", deleteErr)
+		}
+		return "", fmt.Errorf("yana.ProvisionExternalUser() -> Couldn't create bucket: %w", err)
+	}
+	return user.UserId, nil
+}
+
+// Returns the userid of the account with email and creates it first if there is none
+func FindOrProvisionExternalUser(email string, fullname string) (string, error) {
+	userid, err := GetUserIDFromEmail(email)
+	if err != nil {
+		return "", err
+	}
+	if userid != "" {
+		return userid, nil
+	}
+	userid, err = ProvisionExternalUser(email, fullname)
+	if err == ErrExternalUserExists {
+		// Someone else was faster, e.g. the same user logging in twice at once
+		return GetUserIDFromEmail(email)
+	}
+	return userid, err
+}
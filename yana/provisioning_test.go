@@ -0,0 +1,41 @@
+package yana
+
+import (
+	"strings"
+	"testing"
+)
+
+// Whatever the provider sends is checked like the form of /register
+func TestProvisionExternalUserValidates(t *testing.T) {
+	newTestSQLite(t)
+	tests := []struct {
+		name     string
+		email    string
+		fullname string
+	}{
+		{"email with a name", "Someone <someone@example.com>", "Someone"},
+		{"no email", "", "Someone"},
+		{"too long local part", strings.Repeat("a", EMAIL_LOCAL_MAX_LEN+1) + "@example.com", "Someone"},
+		{"too long email", "someone@" + strings.Repeat("a.", EMAIL_MAX_LEN/2) + "com", "Someone"},
+		{"too long name", "someone@example.com", strings.Repeat("a", FULLNAME_MAX_LEN+1)},
+	}
+	for _, test := range tests {
+		userid, err := ProvisionExternalUser(test.email, test.fullname)
+		if err == nil {
+			t.Errorf("ProvisionExternalUser() with %s = %q, want an error", test.name, userid)
+		}
+	}
+	userid, err := GetUserIDFromEmail("someone@example.com")
+	if err != nil || userid != "" {
+		t.Errorf("GetUserIDFromEmail() after refused provisioning = %q, %v, want no account", userid, err)
+	}
+
+	userid, err = ProvisionExternalUser("someone@example.com", "  Someone  ")
+	if err != nil {
+		t.Fatalf("ProvisionExternalUser() = %v", err)
+	}
+	user, err := GetUserFromUserID(userid)
+	if err != nil || user.FullName != "Someone" || user.HasPassword || !user.IsEmailVerified {
+		t.Errorf("GetUserFromUserID() = %+v, %v, want a verified account named Someone without password", user, err)
+	}
+}
@@ -0,0 +1,83 @@
+package yana
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * In proxy auth mode YANAgo doesn't log anyone in itself. An auth proxy in front of it
+ * (oauth2-proxy, Authelia, ...) does that and tells YANAgo who the user is with headers.
+ * Those headers are only believed if the request comes from one of the trusted proxies,
+ * otherwise anyone could just send them.
+ */
+
+const PROXY_AUTH_CONFIG_PATH = "config/proxyauth.yml"
+
+type ProxyAuthConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	TrustedProxies []string `yaml:"trustedproxies"` // CIDRs, e.g. "10.0.0.0/8"
+	EmailHeader    string   `yaml:"emailheader"`
+	NameHeader     string   `yaml:"nameheader"`
+	LogoutURL      string   `yaml:"logouturl"` // Where /logout sends the user, e.g. the sign out page of the proxy
+	trustedNets    []*net.IPNet
+}
+
+var proxyAuthConfig ProxyAuthConfig
+var proxyAuthConfigOnce sync.Once
+
+func readProxyAuthConfig(path string) (ProxyAuthConfig, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return ProxyAuthConfig{}, err
+	}
+
+	config := ProxyAuthConfig{}
+	err = yaml.Unmarshal(file, &config)
+	if err != nil {
+		return ProxyAuthConfig{}, fmt.Errorf("in file %q: %w", path, err)
+	}
+	for _, cidr := range config.TrustedProxies {
+		_, trustedNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return ProxyAuthConfig{}, fmt.Errorf("in file %q: %w", path, err)
+		}
+		config.trustedNets = append(config.trustedNets, trustedNet)
+	}
+	return config, nil
+}
+
+// config/proxyauth.yml is only read once, like config/server.yml.
+// If it's missing or broken, proxy auth mode is off and the normal login is used.
+func GetProxyAuthConfig() ProxyAuthConfig {
+	proxyAuthConfigOnce.Do(func() {
+		var err error
+		proxyAuthConfig, err = readProxyAuthConfig(PROXY_AUTH_CONFIG_PATH)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println("yana.GetProxyAuthConfig() -> Couldn't read proxy auth config, proxy auth is off:", err)
+		}
+	})
+	return proxyAuthConfig
+}
+
+// Also used without proxy auth mode: X-Forwarded-For is only believed from these networks
+func (config ProxyAuthConfig) GetTrustedNets() []*net.IPNet {
+	return config.trustedNets
+}
+
+func (config ProxyAuthConfig) IsTrustedProxy(ipAddress string) bool {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return false
+	}
+	for _, trustedNet := range config.trustedNets {
+		if trustedNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package yana
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * "Remember me" tokens are selector/validator pairs sent as "selector:validator".
+ * The selector is used to look the token up, only the hash of the validator is saved.
+ * Every token belongs to a family (one family per device) and is replaced
+ * with a new token of the same family every time it is used.
+ * A replaced token is kept (as used) until it expires so that using it again can be detected,
+ * which can only happen if the cookie was stolen. In that case the whole family is revoked.
+ * The exception is REMEMBER_GRACE_PERIOD right after the replacement: e.g. two tabs that load at once
+ * both send the old cookie, so then the old token still resolves to its successor.
+ */
+
+const (
+	REMEMBER_SELECTOR_LEN  = 12
+	REMEMBER_VALIDATOR_LEN = 32
+)
+
+// Long enough for requests that were sent at the same time, short enough that a stolen cookie is useless
+const REMEMBER_GRACE_PERIOD = 5 * time.Second
+
+var ErrRememberTokenNotFound = errors.New("remember token not found or expired")
+var ErrRememberTokenReused = errors.New("remember token was already used, all tokens of this device have been revoked")
+
+type RememberedDevice struct {
+	FamilyId      string
+	DeviceName    string
+	CreatedAtUTC  time.Time
+	LastUsedAtUTC time.Time
+	ExpiresAtUTC  time.Time
+}
+
+func splitRememberToken(token string) (string, string, bool) {
+	selector, validator, isFound := strings.Cut(token, ":")
+	return selector, validator, isFound && selector != "" && validator != ""
+}
+
+func insertRememberToken(db *sql.DB, userid, familyId, deviceName string, createdAt time.Time) (string, error) {
+	selector, err := generateToken(REMEMBER_SELECTOR_LEN)
+	if err != nil {
+		return "", err
+	}
+	validator, err := generateToken(REMEMBER_VALIDATOR_LEN)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(time.Duration(GetServerConfig().RememberMeLifetimeDays) * 24 * time.Hour)
+	query := `INSERT INTO remember_token (selector, validatorhash, user_id, family_id, devicename, created_at_utc, last_used_at_utc, expires_at_utc, is_used)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, FALSE)`
+	_, err = db.Exec(query, selector, hashToken(validator), userid, familyId, deviceName, createdAt, now, expiresAt)
+	if err != nil {
+		return "", fmt.Errorf("Insert query wasn't succesful: %w", err)
+	}
+	return selector + ":" + validator, nil
+}
+
+// Starts a new token family for a device.
+// Returns string: the token that should be stored in the user's remember cookie
+func IssueRememberToken(userid, deviceName string) (string, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.IssueRememberToken() -> Couldn't get database: %w", err)
+	}
+
+	// Nobody needs expired tokens anymore, not even for detecting reuse
+	_, err = db.Exec(`DELETE FROM remember_token WHERE expires_at_utc < $1`, time.Now().UTC())
+	if err != nil {
+		fmt.Println("yana.IssueRememberToken() -> Couldn't delete expired tokens:", err)
+	}
+
+	token, err := insertRememberToken(db, userid, uuid.New().String(), deviceName, time.Now().UTC())
+	if err != nil {
+		return "", fmt.Errorf("yana.IssueRememberToken() -> Couldn't insert token: %w", err)
+	}
+	return token, nil
+}
+
+// Checks a token from a remember cookie and replaces it with a new one.
+// Returns the userid and the new token. The new token is "" if the token was replaced
+// within REMEMBER_GRACE_PERIOD already, the browser gets its successor from that request.
+func ConsumeRememberToken(token string) (string, string, error) {
+	selector, validator, isOk := splitRememberToken(token)
+	if !isOk {
+		return "", "", ErrRememberTokenNotFound
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return "", "", fmt.Errorf("yana.ConsumeRememberToken() -> Couldn't get database: %w", err)
+	}
+
+	var validatorHash, userid, familyId, deviceName string
+	var createdAt, expiresAt time.Time
+	var isUsed bool
+	query := `SELECT validatorhash, user_id, family_id, devicename, created_at_utc, expires_at_utc, is_used FROM remember_token WHERE selector = $1`
+	err = db.QueryRow(query, selector).Scan(&validatorHash, &userid, &familyId, &deviceName, &createdAt, &expiresAt, &isUsed)
+	if err == sql.ErrNoRows {
+		return "", "", ErrRememberTokenNotFound
+	} else if err != nil {
+		return "", "", fmt.Errorf("yana.ConsumeRememberToken() -> Select query wasn't succesful: %w", err)
+	}
+
+	isValidatorOk := subtle.ConstantTimeCompare([]byte(hashToken(validator)), []byte(validatorHash)) == 1
+	if !isValidatorOk {
+		// Someone is guessing validators for a known selector
+		err = revokeRememberFamily(db, familyId)
+		if err != nil {
+			return "", "", fmt.Errorf("yana.ConsumeRememberToken() -> Wrong validator but family couldn't be revoked: %w", err)
+		}
+		return "", "", ErrRememberTokenReused
+	}
+	if time.Now().UTC().After(expiresAt) {
+		return "", "", ErrRememberTokenNotFound
+	}
+	if isUsed {
+		return resolveReplacedRememberToken(db, selector, userid, familyId)
+	}
+
+	newToken, err := insertRememberToken(db, userid, familyId, deviceName, createdAt)
+	if err != nil {
+		return "", "", fmt.Errorf("yana.ConsumeRememberToken() -> Couldn't insert rotated token: %w", err)
+	}
+	newSelector, _, _ := splitRememberToken(newToken)
+
+	// Only one request can replace the token, a concurrent second one resolves to the successor of the first
+	query = `UPDATE remember_token SET is_used = TRUE, used_at_utc = $1, replaced_by = $2 WHERE selector = $3 AND is_used = FALSE`
+	result, err := db.Exec(query, time.Now().UTC(), newSelector, selector)
+	if err != nil {
+		return "", "", fmt.Errorf("yana.ConsumeRememberToken() -> Update query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", "", fmt.Errorf("yana.ConsumeRememberToken() -> Couldn't get affected rows: %w", err)
+	}
+	if rowsAffected != 1 {
+		_, err = db.Exec(`DELETE FROM remember_token WHERE selector = $1`, newSelector)
+		if err != nil {
+			fmt.Println("yana.ConsumeRememberToken() -> Couldn't delete unneeded token:", err)
+		}
+		return resolveReplacedRememberToken(db, selector, userid, familyId)
+	}
+	return userid, newToken, nil
+}
+
+// Called when a token that was already replaced is used again.
+// Within REMEMBER_GRACE_PERIOD it still counts if its successor exists, otherwise the cookie was stolen
+// and the whole family is revoked
+func resolveReplacedRememberToken(db *sql.DB, selector, userid, familyId string) (string, string, error) {
+	var usedAt sql.NullTime
+	var successorCount int
+	query := `SELECT used_at_utc, (SELECT COUNT(*) FROM remember_token AS successor
+		WHERE successor.selector = remember_token.replaced_by AND successor.expires_at_utc > $2)
+		FROM remember_token WHERE selector = $1`
+	err := db.QueryRow(query, selector, time.Now().UTC()).Scan(&usedAt, &successorCount)
+	if err != nil && err != sql.ErrNoRows {
+		return "", "", fmt.Errorf("yana.resolveReplacedRememberToken() -> Select query wasn't succesful: %w", err)
+	}
+	isInGracePeriod := usedAt.Valid && time.Since(usedAt.Time) < REMEMBER_GRACE_PERIOD
+	if err == nil && isInGracePeriod && successorCount == 1 {
+		return userid, "", nil
+	}
+	err = revokeRememberFamily(db, familyId)
+	if err != nil {
+		return "", "", fmt.Errorf("yana.resolveReplacedRememberToken() -> Token was reused but family couldn't be revoked: %w", err)
+	}
+	return "", "", ErrRememberTokenReused
+}
+
+func revokeRememberFamily(db *sql.DB, familyId string) error {
+	_, err := db.Exec(`DELETE FROM remember_token WHERE family_id = $1`, familyId)
+	return err
+}
+
+// Revokes the device the token belongs to, used when logging out
+func RevokeRememberToken(token string) error {
+	selector, _, isOk := splitRememberToken(token)
+	if !isOk {
+		return nil
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.RevokeRememberToken() -> Couldn't get database: %w", err)
+	}
+	query := `DELETE FROM remember_token WHERE family_id = (SELECT family_id FROM remember_token WHERE selector = $1)`
+	_, err = db.Exec(query, selector)
+	if err != nil {
+		return fmt.Errorf("yana.RevokeRememberToken() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+// Returns every device that can currently log in with a remember token
+func GetRememberedDevices(userid string) ([]RememberedDevice, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetRememberedDevices() -> Couldn't get database: %w", err)
+	}
+	query := `SELECT family_id, devicename, created_at_utc, last_used_at_utc, expires_at_utc FROM remember_token
+		WHERE user_id = $1 AND is_used = FALSE AND expires_at_utc > $2 ORDER BY last_used_at_utc DESC`
+	rows, err := db.Query(query, userid, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("yana.GetRememberedDevices() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	var devices []RememberedDevice
+	for rows.Next() {
+		var device RememberedDevice
+		err = rows.Scan(&device.FamilyId, &device.DeviceName, &device.CreatedAtUTC, &device.LastUsedAtUTC, &device.ExpiresAtUTC)
+		if err != nil {
+			return nil, fmt.Errorf("yana.GetRememberedDevices() -> Couldn't scan row: %w", err)
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// The userid is checked so that users can only revoke their own devices
+func RevokeRememberedDevice(userid, familyId string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.RevokeRememberedDevice() -> Couldn't get database: %w", err)
+	}
+	_, err = db.Exec(`DELETE FROM remember_token WHERE user_id = $1 AND family_id = $2`, userid, familyId)
+	if err != nil {
+		return fmt.Errorf("yana.RevokeRememberedDevice() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+// Used when the password changes
+func RevokeAllRememberTokensOfUser(userid string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.RevokeAllRememberTokensOfUser() -> Couldn't get database: %w", err)
+	}
+	_, err = db.Exec(`DELETE FROM remember_token WHERE user_id = $1`, userid)
+	if err != nil {
+		return fmt.Errorf("yana.RevokeAllRememberTokensOfUser() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package yana
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+ * Users, notes and sessions are read and written through a MetadataRepository.
+ * Which database is used is set with database in config/server.yml:
+ *
+ * - "postgresql" (default): see config/postgresql.yml
+ * - "sqlite": one file at sqlitepath.
+ *   Meant for single-user or development installs that don't want to run a database server.
+ *
+ * The tables of both are created by the migrations in migrations/ (see migrations.go).
+ *
+ * The other features (throttling, tokens, passkeys, the audit log, ...) use getDatabase() directly.
+ * Their SQL is written so that it works on both databases, e.g. times and ids are always created in Go.
+ */
+
+const (
+	DATABASE_POSTGRESQL = "postgresql"
+	DATABASE_SQLITE     = "sqlite"
+)
+
+// Returned by GetNoteMetadata() and GetNoteMetadataByName() if there is no such note
+var ErrNoteMetadataNotFound = fmt.Errorf("note metadata not found: %w", sql.ErrNoRows)
+
+type MetadataRepository interface {
+	// e.g. "PostgreSQL 16.2 ..."
+	Version(ctx context.Context) (string, error)
+
+	// Returns ErrEmailTaken if another account already uses user.Email
+	InsertUser(user User, passwordHash string) error
+	// Only for accounts that were just created and have nothing else yet, see deleteAccount() for everything else
+	DeleteUser(userid string) error
+	// Returns a YanaError with the code UserNotFound if there is no such user
+	GetUser(userid string) (User, error)
+	// Returns an empty string if there is no such user
+	GetUserIDByEmail(email string) (string, error)
+	// Returns a YanaError with the code UserNotFound if there is no such user
+	GetPasswordHash(userid string) (string, error)
+	// Returns a YanaError with the code UserNotFound if there is no such user
+	GetPasswordHashByEmail(email string) (userid string, passwordHash string, err error)
+	SetPasswordHash(userid string, passwordHash string) error
+	SetPasswordResetRequired(userid string, isRequired bool) error
+	SetFullName(userid string, fullname string) error
+	// Also marks the email as unverified. Returns ErrEmailTaken if another account already uses email
+	SetEmail(userid string, email string) error
+
+	InsertNoteMetadata(note PostgreSQLNote) error
+	// Both only find committed notes and return ErrNoteMetadataNotFound if there is no such note
+	GetNoteMetadata(noteId string) (PostgreSQLNote, error)
+	GetNoteMetadataByName(bucketName string, filename string) (PostgreSQLNote, error)
+	// Returns ErrNoteMetadataNotFound if the note isn't in fromState (anymore)
+	SetNoteState(noteId string, fromState string, toState string) error
+	// Gives noteId the name of the pending note pendingNoteId, see noteWrites.go.
+	// Returns the deleting note with the id leftoverNoteId that holds the old name
+	SwapNoteName(noteId string, pendingNoteId string, leftoverNoteId string) (PostgreSQLNote, error)
+	// Every deleting note and every pending one that became pending before pendingBeforeUTC
+	GetUnfinishedNotes(pendingBeforeUTC time.Time) ([]PostgreSQLNote, error)
+	DeleteNoteMetadata(noteId string) error
+	// Whether a note other than exceptNoteId already has this name, unfinished ones included. exceptNoteId can be empty
+	IsNoteNameTaken(bucketName string, filename string, exceptNoteId string) (bool, error)
+
+	// Returns a YanaError with the code AccountDisabled if the user is disabled or doesn't exist
+	InsertSession(tokenHash string, session Session) error
+	// Returns ErrSessionNotFound if there is no such session or the user is disabled
+	GetSession(tokenHash string) (User, Session, error)
+	TouchSession(tokenHash string, lastSeenAtUTC time.Time) error
+	DeleteSession(tokenHash string) error
+	DeleteSessionsOfUser(userid string) error
+}
+
+// Creates the repository for the database that is set in config/server.yml. db comes from OpenDatabase()
+func NewRepository(db *sql.DB) MetadataRepository {
+	if GetServerConfig().Database == DATABASE_SQLITE {
+		return NewSQLiteRepository(db)
+	}
+	return NewPostgreSQLRepository(db)
+}
+
+var repository MetadataRepository
+var repositoryMutex sync.RWMutex
+
+// Set by Init()
+func getRepository() MetadataRepository {
+	repositoryMutex.RLock()
+	defer repositoryMutex.RUnlock()
+	if repository == nil {
+		panic("yana.getRepository() -> yana.Init() has to be called first")
+	}
+	return repository
+}
+
+// Replaces the repository from Init(), e.g. in tests
+func SetRepository(newRepository MetadataRepository) {
+	repositoryMutex.Lock()
+	defer repositoryMutex.Unlock()
+	repository = newRepository
+}
+
+// Opens the pool for the database that is set in config/server.yml, with the pool sizes from there.
+// It's meant to be opened once at startup and handed to Init(), which shares it with every request.
+// A database that can't be reached yet is only logged: the pool connects again on the next query.
+func OpenDatabase() (*sql.DB, error) {
+	config := GetServerConfig()
+	var db *sql.DB
+	var err error
+	switch config.Database {
+	case DATABASE_POSTGRESQL:
+		db, err = openPostgreSQL(POSTGRESQL_CONFIG_PATH)
+	case DATABASE_SQLITE:
+		db, err = openSQLite(config.SQLitePath)
+	default:
+		err = fmt.Errorf("unknown database %q", config.Database)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("yana.OpenDatabase() -> Couldn't open %s: %w", config.Database, err)
+	}
+	db.SetMaxOpenConns(config.DatabaseMaxOpenConnections)
+	db.SetMaxIdleConns(config.DatabaseMaxIdleConnections)
+	db.SetConnMaxLifetime(time.Duration(config.DatabaseConnLifetimeMinutes) * time.Minute)
+	err = db.Ping()
+	if err != nil {
+		fmt.Println("yana.OpenDatabase() -> Couldn't reach the database yet:", err)
+	}
+	return db, nil
+}
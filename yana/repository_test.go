@@ -0,0 +1,371 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Every test runs against every MetadataRepository, see repositoryBackends
+var repositoryTests = []struct {
+	name string
+	test func(t *testing.T, repository MetadataRepository)
+}{
+	{"InsertAndGetUser", testInsertAndGetUser},
+	{"UniqueEmail", testUniqueEmail},
+	{"DeleteUser", testDeleteUser},
+	{"InsertAndListNotes", testInsertAndListNotes},
+	{"SwapNoteName", testSwapNoteName},
+	{"SwapNoteNameWithoutPendingNote", testSwapNoteNameWithoutPendingNote},
+	{"CreateAndRevokeSession", testCreateAndRevokeSession},
+	{"ExpiredSession", testExpiredSession},
+	{"SessionOfDisabledUser", testSessionOfDisabledUser},
+}
+
+var repositoryBackends = []struct {
+	name string
+	open func(t *testing.T) MetadataRepository
+}{
+	{"SQLite", func(t *testing.T) MetadataRepository { return NewSQLiteRepository(newTestSQLite(t)) }},
+	{"PostgreSQL", func(t *testing.T) MetadataRepository { return NewPostgreSQLRepository(newTestPostgreSQL(t)) }},
+}
+
+func TestMetadataRepository(t *testing.T) {
+	for _, backend := range repositoryBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, repositoryTest := range repositoryTests {
+				t.Run(repositoryTest.name, func(t *testing.T) {
+					repository := backend.open(t)
+					SetRepository(repository)
+					repositoryTest.test(t, repository)
+				})
+			}
+		})
+	}
+}
+
+// The PostgreSQL database is kept between runs, so every user gets a new email
+func newTestUser(t *testing.T, repository MetadataRepository) User {
+	t.Helper()
+	user := User{
+		UserId:          generateUserID(),
+		Email:           uuid.New().String() + "@example.com",
+		FullName:        "Test User",
+		IsEmailVerified: true,
+		CreatedAtUTC:    sql.NullTime{Time: time.Now().UTC().Truncate(time.Second), Valid: true},
+	}
+	err := repository.InsertUser(user, "hash")
+	if err != nil {
+		t.Fatalf("InsertUser() = %v", err)
+	}
+	return user
+}
+
+func newTestNote(t *testing.T, repository MetadataRepository, bucketName, filename, state string) PostgreSQLNote {
+	t.Helper()
+	note := PostgreSQLNote{
+		Id:           uuid.New().String(),
+		Bucketname:   bucketName,
+		Filename:     filename,
+		CreatedAtUTC: time.Now().UTC().Format(time.RFC3339Nano),
+		State:        state,
+	}
+	err := repository.InsertNoteMetadata(note)
+	if err != nil {
+		t.Fatalf("InsertNoteMetadata() = %v", err)
+	}
+	return note
+}
+
+func testInsertAndGetUser(t *testing.T, repository MetadataRepository) {
+	user := newTestUser(t, repository)
+
+	got, err := repository.GetUser(user.UserId)
+	if err != nil {
+		t.Fatalf("GetUser() = %v", err)
+	}
+	if got.Email != user.Email || got.FullName != user.FullName || !got.IsEmailVerified || !got.HasPassword {
+		t.Errorf("GetUser() = %+v, want the inserted user %+v", got, user)
+	}
+	if got.Role != ROLE_USER || got.IsDisabled {
+		t.Errorf("GetUser() = %+v, want an enabled user with role %q", got, ROLE_USER)
+	}
+
+	userid, err := repository.GetUserIDByEmail(strings.ToUpper(user.Email))
+	if err != nil || userid != user.UserId {
+		t.Errorf("GetUserIDByEmail() with other case = %q, %v, want %q", userid, err, user.UserId)
+	}
+	userid, hash, err := repository.GetPasswordHashByEmail(user.Email)
+	if err != nil || userid != user.UserId || hash != "hash" {
+		t.Errorf("GetPasswordHashByEmail() = %q, %q, %v", userid, hash, err)
+	}
+
+	userid, err = repository.GetUserIDByEmail("nobody-" + user.Email)
+	if err != nil || userid != "" {
+		t.Errorf("GetUserIDByEmail() of unknown email = %q, %v, want \"\"", userid, err)
+	}
+	_, err = repository.GetUser(generateUserID())
+	if GetYanaErrorCode(err) != UserNotFound {
+		t.Errorf("GetUser() of unknown user = %v, want UserNotFound", err)
+	}
+}
+
+func testUniqueEmail(t *testing.T, repository MetadataRepository) {
+	user := newTestUser(t, repository)
+
+	other := User{UserId: generateUserID(), Email: strings.ToUpper(user.Email)}
+	err := repository.InsertUser(other, "")
+	if err != ErrEmailTaken {
+		t.Errorf("InsertUser() with a taken email = %v, want ErrEmailTaken", err)
+	}
+	_, err = repository.GetUser(other.UserId)
+	if GetYanaErrorCode(err) != UserNotFound {
+		t.Errorf("GetUser() of rejected user = %v, want UserNotFound", err)
+	}
+
+	second := newTestUser(t, repository)
+	err = repository.SetEmail(second.UserId, user.Email)
+	if err != ErrEmailTaken {
+		t.Errorf("SetEmail() to a taken email = %v, want ErrEmailTaken", err)
+	}
+}
+
+func testDeleteUser(t *testing.T, repository MetadataRepository) {
+	user := newTestUser(t, repository)
+	err := repository.DeleteUser(user.UserId)
+	if err != nil {
+		t.Fatalf("DeleteUser() = %v", err)
+	}
+	userid, err := repository.GetUserIDByEmail(user.Email)
+	if err != nil || userid != "" {
+		t.Errorf("GetUserIDByEmail() after DeleteUser() = %q, %v, want \"\"", userid, err)
+	}
+	// The email can be used again
+	err = repository.InsertUser(User{UserId: generateUserID(), Email: user.Email}, "")
+	if err != nil {
+		t.Errorf("InsertUser() with the email of a deleted user = %v", err)
+	}
+}
+
+func testInsertAndListNotes(t *testing.T, repository MetadataRepository) {
+	bucketName := uuid.New().String()
+	committed := newTestNote(t, repository, bucketName, "Committed", NOTE_STATE_COMMITTED)
+	pending := newTestNote(t, repository, bucketName, "Pending", NOTE_STATE_PENDING)
+	deleting := newTestNote(t, repository, bucketName, "Deleting", NOTE_STATE_DELETING)
+
+	got, err := repository.GetNoteMetadataByName(bucketName, "Committed")
+	if err != nil || got.Id != committed.Id || got.State != NOTE_STATE_COMMITTED {
+		t.Errorf("GetNoteMetadataByName() = %+v, %v, want %+v", got, err, committed)
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, got.CreatedAtUTC)
+	if err != nil || time.Since(createdAt) > time.Minute {
+		t.Errorf("CreatedAtUTC = %q, %v, want about now", got.CreatedAtUTC, err)
+	}
+	_, err = repository.GetNoteMetadata(pending.Id)
+	if err != ErrNoteMetadataNotFound {
+		t.Errorf("GetNoteMetadata() of pending note = %v, want ErrNoteMetadataNotFound", err)
+	}
+
+	// Unfinished notes still reserve their name
+	for _, filename := range []string{"Committed", "Pending", "Deleting"} {
+		isTaken, err := repository.IsNoteNameTaken(bucketName, filename, "")
+		if err != nil || !isTaken {
+			t.Errorf("IsNoteNameTaken(%q) = %v, %v, want true", filename, isTaken, err)
+		}
+	}
+	isTaken, err := repository.IsNoteNameTaken(bucketName, "Committed", committed.Id)
+	if err != nil || isTaken {
+		t.Errorf("IsNoteNameTaken() except the note itself = %v, %v, want false", isTaken, err)
+	}
+
+	// Only pending notes older than the given time are listed
+	notes, err := repository.GetUnfinishedNotes(time.Now().UTC().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetUnfinishedNotes() = %v", err)
+	}
+	ids := map[string]bool{}
+	for _, note := range notes {
+		ids[note.Id] = true
+	}
+	if !ids[deleting.Id] || ids[pending.Id] || ids[committed.Id] {
+		t.Errorf("GetUnfinishedNotes() of an hour ago = %+v, want only the deleting note", notes)
+	}
+	notes, err = repository.GetUnfinishedNotes(time.Now().UTC().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetUnfinishedNotes() = %v", err)
+	}
+	ids = map[string]bool{}
+	for _, note := range notes {
+		ids[note.Id] = true
+	}
+	if !ids[deleting.Id] || !ids[pending.Id] || ids[committed.Id] {
+		t.Errorf("GetUnfinishedNotes() of in an hour = %+v, want the deleting and the pending note", notes)
+	}
+
+	err = repository.SetNoteState(pending.Id, NOTE_STATE_PENDING, NOTE_STATE_COMMITTED)
+	if err != nil {
+		t.Fatalf("SetNoteState() = %v", err)
+	}
+	err = repository.SetNoteState(pending.Id, NOTE_STATE_PENDING, NOTE_STATE_DELETING)
+	if err != ErrNoteMetadataNotFound {
+		t.Errorf("SetNoteState() from a state the note isn't in = %v, want ErrNoteMetadataNotFound", err)
+	}
+	err = repository.DeleteNoteMetadata(committed.Id)
+	if err != nil {
+		t.Fatalf("DeleteNoteMetadata() = %v", err)
+	}
+	_, err = repository.GetNoteMetadata(committed.Id)
+	if err != ErrNoteMetadataNotFound {
+		t.Errorf("GetNoteMetadata() after DeleteNoteMetadata() = %v, want ErrNoteMetadataNotFound", err)
+	}
+}
+
+func testSwapNoteName(t *testing.T, repository MetadataRepository) {
+	bucketName := uuid.New().String()
+	note := newTestNote(t, repository, bucketName, "Old", NOTE_STATE_COMMITTED)
+	pending := newTestNote(t, repository, bucketName, "New", NOTE_STATE_PENDING)
+	leftoverNoteId := uuid.New().String()
+
+	leftover, err := repository.SwapNoteName(note.Id, pending.Id, leftoverNoteId)
+	if err != nil {
+		t.Fatalf("SwapNoteName() = %v", err)
+	}
+	if leftover.Id != leftoverNoteId || leftover.Filename != "Old" || leftover.State != NOTE_STATE_DELETING {
+		t.Errorf("SwapNoteName() = %+v, want the deleting note %s named Old", leftover, leftoverNoteId)
+	}
+	got, err := repository.GetNoteMetadata(note.Id)
+	if err != nil || got.Filename != "New" {
+		t.Errorf("GetNoteMetadata() after SwapNoteName() = %+v, %v, want the name New", got, err)
+	}
+	_, err = repository.GetNoteMetadataByName(bucketName, "Old")
+	if err != ErrNoteMetadataNotFound {
+		t.Errorf("GetNoteMetadataByName() of the old name = %v, want ErrNoteMetadataNotFound", err)
+	}
+	notes, err := repository.GetUnfinishedNotes(time.Now().UTC())
+	if err != nil {
+		t.Fatalf("GetUnfinishedNotes() = %v", err)
+	}
+	isLeftoverListed := false
+	for _, unfinished := range notes {
+		if unfinished.Id == pending.Id {
+			t.Errorf("GetUnfinishedNotes() still lists the pending note %s", pending.Id)
+		}
+		isLeftoverListed = isLeftoverListed || unfinished.Id == leftoverNoteId
+	}
+	if !isLeftoverListed {
+		t.Errorf("GetUnfinishedNotes() = %+v, want the leftover note %s", notes, leftoverNoteId)
+	}
+}
+
+func testSwapNoteNameWithoutPendingNote(t *testing.T, repository MetadataRepository) {
+	bucketName := uuid.New().String()
+	note := newTestNote(t, repository, bucketName, "Old", NOTE_STATE_COMMITTED)
+	// e.g. FinishUnfinishedNotes() has given up on it already
+	givenUpNote := newTestNote(t, repository, bucketName, "New", NOTE_STATE_DELETING)
+
+	_, err := repository.SwapNoteName(note.Id, givenUpNote.Id, uuid.New().String())
+	if err != ErrNoteMetadataNotFound {
+		t.Errorf("SwapNoteName() with a deleting note = %v, want ErrNoteMetadataNotFound", err)
+	}
+	got, err := repository.GetNoteMetadata(note.Id)
+	if err != nil || got.Filename != "Old" {
+		t.Errorf("GetNoteMetadata() after failed SwapNoteName() = %+v, %v, want the name Old", got, err)
+	}
+}
+
+func newTestSession(userid string, expiresAtUTC time.Time) Session {
+	now := time.Now().UTC().Truncate(time.Second)
+	return Session{UserId: userid, CSRFToken: "csrf", CreatedAtUTC: now, LastSeenAtUTC: now, ExpiresAtUTC: expiresAtUTC.Truncate(time.Second)}
+}
+
+func testCreateAndRevokeSession(t *testing.T, repository MetadataRepository) {
+	user := newTestUser(t, repository)
+	tokenHash := hashToken(uuid.New().String())
+	session := newTestSession(user.UserId, time.Now().UTC().Add(time.Hour))
+	err := repository.InsertSession(tokenHash, session)
+	if err != nil {
+		t.Fatalf("InsertSession() = %v", err)
+	}
+
+	gotUser, gotSession, err := repository.GetSession(tokenHash)
+	if err != nil {
+		t.Fatalf("GetSession() = %v", err)
+	}
+	if gotUser.UserId != user.UserId || gotSession.CSRFToken != "csrf" || !gotSession.ExpiresAtUTC.Equal(session.ExpiresAtUTC) {
+		t.Errorf("GetSession() = %+v, %+v, want %+v", gotUser, gotSession, session)
+	}
+
+	lastSeen := session.LastSeenAtUTC.Add(time.Minute)
+	err = repository.TouchSession(tokenHash, lastSeen)
+	if err != nil {
+		t.Fatalf("TouchSession() = %v", err)
+	}
+	_, gotSession, err = repository.GetSession(tokenHash)
+	if err != nil || !gotSession.LastSeenAtUTC.Equal(lastSeen) {
+		t.Errorf("LastSeenAtUTC after TouchSession() = %v, %v, want %v", gotSession.LastSeenAtUTC, err, lastSeen)
+	}
+
+	err = repository.DeleteSession(tokenHash)
+	if err != nil {
+		t.Fatalf("DeleteSession() = %v", err)
+	}
+	_, _, err = repository.GetSession(tokenHash)
+	if err != ErrSessionNotFound {
+		t.Errorf("GetSession() after DeleteSession() = %v, want ErrSessionNotFound", err)
+	}
+
+	// Logging out everywhere
+	otherTokenHashes := []string{hashToken(uuid.New().String()), hashToken(uuid.New().String())}
+	for _, otherTokenHash := range otherTokenHashes {
+		err = repository.InsertSession(otherTokenHash, session)
+		if err != nil {
+			t.Fatalf("InsertSession() = %v", err)
+		}
+	}
+	err = repository.DeleteSessionsOfUser(user.UserId)
+	if err != nil {
+		t.Fatalf("DeleteSessionsOfUser() = %v", err)
+	}
+	for _, otherTokenHash := range otherTokenHashes {
+		_, _, err = repository.GetSession(otherTokenHash)
+		if err != ErrSessionNotFound {
+			t.Errorf("GetSession() after DeleteSessionsOfUser() = %v, want ErrSessionNotFound", err)
+		}
+	}
+}
+
+// The repository returns expired sessions, GetUserFromSession() removes them
+func testExpiredSession(t *testing.T, repository MetadataRepository) {
+	user := newTestUser(t, repository)
+	token := uuid.New().String()
+	session := newTestSession(user.UserId, time.Now().UTC().Add(-time.Minute))
+	err := repository.InsertSession(hashToken(token), session)
+	if err != nil {
+		t.Fatalf("InsertSession() = %v", err)
+	}
+	_, gotSession, err := repository.GetSession(hashToken(token))
+	if err != nil || !gotSession.ExpiresAtUTC.Equal(session.ExpiresAtUTC) {
+		t.Fatalf("GetSession() of expired session = %+v, %v", gotSession, err)
+	}
+
+	_, _, err = GetUserFromSession(token)
+	if err != ErrSessionNotFound {
+		t.Errorf("GetUserFromSession() of expired session = %v, want ErrSessionNotFound", err)
+	}
+	_, _, err = repository.GetSession(hashToken(token))
+	if err != ErrSessionNotFound {
+		t.Errorf("GetSession() after GetUserFromSession() = %v, want the expired session to be deleted", err)
+	}
+}
+
+func testSessionOfDisabledUser(t *testing.T, repository MetadataRepository) {
+	err := repository.InsertSession(hashToken(uuid.New().String()), newTestSession(generateUserID(), time.Now().UTC().Add(time.Hour)))
+	var yanaErr YanaError
+	if !errors.As(err, &yanaErr) || yanaErr.Code != AccountDisabled {
+		t.Errorf("InsertSession() for unknown user = %v, want AccountDisabled", err)
+	}
+}
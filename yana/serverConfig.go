@@ -0,0 +1,107 @@
+package yana
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const SERVER_CONFIG_PATH = "config/server.yml"
+
+type ServerConfig struct {
+	SecureCookies                  bool   `yaml:"securecookies"`
+	SessionLifetimeMinutes         int    `yaml:"sessionlifetimeminutes"`
+	SessionIdleTimeoutMinutes      int    `yaml:"sessionidletimeoutminutes"`
+	RememberMeLifetimeDays         int    `yaml:"remembermelifetimedays"`
+	LoginMaxAttempts               int    `yaml:"loginmaxattempts"`
+	LoginMaxAttemptsPerIP          int    `yaml:"loginmaxattemptsperip"`
+	LoginLockoutMinutes            int    `yaml:"loginlockoutminutes"`
+	LoginMaxLockoutMinutes         int    `yaml:"loginmaxlockoutminutes"`
+	RegistrationMaxAttemptsPerIP   int    `yaml:"registrationmaxattemptsperip"`
+	PasswordResetMaxMailsPerIP     int    `yaml:"passwordresetmaxmailsperip"`
+	MagicLinks                     bool   `yaml:"magiclinks"`
+	MagicLinkMaxMailsPerIP         int    `yaml:"magiclinkmaxmailsperip"`
+	EmailVerificationLifetimeHours int    `yaml:"emailverificationlifetimehours"`
+	EmailVerificationResendMinutes int    `yaml:"emailverificationresendminutes"`
+	UnverifiedCanCreateNotes       bool   `yaml:"unverifiedcancreatenotes"`
+	UnverifiedCanEditNotes         bool   `yaml:"unverifiedcaneditnotes"`
+	AccountDeletionRetryMinutes    int    `yaml:"accountdeletionretryminutes"`
+	UnfinishedNoteRetryMinutes     int    `yaml:"unfinishednoteretryminutes"`
+	Database                       string `yaml:"database"`
+	SQLitePath                     string `yaml:"sqlitepath"`
+	DatabaseMaxOpenConnections     int    `yaml:"databasemaxopenconnections"`
+	DatabaseMaxIdleConnections     int    `yaml:"databasemaxidleconnections"`
+	DatabaseConnLifetimeMinutes    int    `yaml:"databaseconnlifetimeminutes"`
+	AutoMigrate                    bool   `yaml:"automigrate"`
+	Storage                        string `yaml:"storage"`
+	StoragePath                    string `yaml:"storagepath"`
+	Registration                   string `yaml:"registration"`
+	UsersCanInvite                 bool   `yaml:"userscaninvite"`
+	SecretKey                      string `yaml:"secretkey"`
+	BaseURL                        string `yaml:"baseurl"`
+}
+
+// Used for every value that is missing in config/server.yml
+var DEFAULT_SERVER_CONFIG = ServerConfig{
+	SecureCookies:                  true,
+	SessionLifetimeMinutes:         24 * 60,
+	SessionIdleTimeoutMinutes:      60,
+	RememberMeLifetimeDays:         30,
+	LoginMaxAttempts:               5,
+	LoginMaxAttemptsPerIP:          20,
+	LoginLockoutMinutes:            1,
+	LoginMaxLockoutMinutes:         60,
+	RegistrationMaxAttemptsPerIP:   5,
+	PasswordResetMaxMailsPerIP:     5,
+	MagicLinks:                     true,
+	MagicLinkMaxMailsPerIP:         5,
+	EmailVerificationLifetimeHours: 48,
+	EmailVerificationResendMinutes: 5,
+	UnverifiedCanCreateNotes:       false,
+	UnverifiedCanEditNotes:         true,
+	AccountDeletionRetryMinutes:    10,
+	UnfinishedNoteRetryMinutes:     10,
+	Database:                       DATABASE_POSTGRESQL,
+	SQLitePath:                     "data/yana.db",
+	DatabaseMaxOpenConnections:     25,
+	DatabaseMaxIdleConnections:     5,
+	DatabaseConnLifetimeMinutes:    30,
+	AutoMigrate:                    true,
+	Storage:                        STORAGE_MINIO,
+	StoragePath:                    "data/notes",
+	Registration:                   REGISTRATION_OPEN,
+	UsersCanInvite:                 true,
+	BaseURL:                        "http://localhost:1323",
+}
+
+var serverConfig ServerConfig
+var serverConfigOnce sync.Once
+
+func readServerConfig(path string) (ServerConfig, error) {
+	config := DEFAULT_SERVER_CONFIG
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = yaml.Unmarshal(file, &config)
+	if err != nil {
+		return DEFAULT_SERVER_CONFIG, fmt.Errorf("in file %q: %w", path, err)
+	}
+	return config, nil
+}
+
+// Unlike the PostgreSQL and MinIO configs, config/server.yml is only read once
+// because it is needed on every request.
+// If it can't be read, the defaults are used.
+func GetServerConfig() ServerConfig {
+	serverConfigOnce.Do(func() {
+		var err error
+		serverConfig, err = readServerConfig(SERVER_CONFIG_PATH)
+		if err != nil {
+			fmt.Println("yana.GetServerConfig() -> Couldn't read server config, using defaults:", err)
+		}
+	})
+	return serverConfig
+}
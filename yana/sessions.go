@@ -0,0 +1,124 @@
+package yana
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SESSION_TOKEN_LEN = 32 // in bytes, before encoding
+	CSRF_TOKEN_LEN    = 32
+)
+
+// Returned by GetUserFromSession() if the token is unknown, expired or idle for too long
+var ErrSessionNotFound = errors.New("session not found or expired")
+
+type Session struct {
+	UserId        string
+	CSRFToken     string // Has to be sent with every form or fetch call that changes something
+	CreatedAtUTC  time.Time
+	LastSeenAtUTC time.Time
+	ExpiresAtUTC  time.Time
+}
+
+// Returns a random, URL-safe token
+func generateToken(length int) (string, error) {
+	raw := make([]byte, length)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", fmt.Errorf("yana.generateToken() -> Couldn't read random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Only the hash of a token is saved in the database so a leaked table can't be used to log in.
+// The tokens have enough entropy that a plain SHA-256 is enough here (unlike for passwords).
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// Returns a new CSRF token for visitors that aren't logged in.
+// Logged in users use the CSRFToken of their Session instead.
+func GenerateCSRFToken() (string, error) {
+	return generateToken(CSRF_TOKEN_LEN)
+}
+
+// Returns string: the token that should be stored in the user's session cookie
+func CreateSession(userid string) (string, error) {
+	token, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", fmt.Errorf("yana.CreateSession() -> Couldn't generate token: %w", err)
+	}
+	csrfToken, err := GenerateCSRFToken()
+	if err != nil {
+		return "", fmt.Errorf("yana.CreateSession() -> Couldn't generate CSRF token: %w", err)
+	}
+	config := GetServerConfig()
+	now := time.Now().UTC()
+	session := Session{
+		UserId:        userid,
+		CSRFToken:     csrfToken,
+		CreatedAtUTC:  now,
+		LastSeenAtUTC: now,
+		ExpiresAtUTC:  now.Add(time.Duration(config.SessionLifetimeMinutes) * time.Minute),
+	}
+	err = getRepository().InsertSession(hashToken(token), session)
+	if err != nil {
+		// Wrapped so that GetYanaErrorCode() still finds AccountDisabled
+		return "", fmt.Errorf("yana.CreateSession() -> Couldn't insert session: %w", err)
+	}
+	return token, nil
+}
+
+// Looks up the user of a session and marks the session as used.
+// Expired or idle sessions are deleted and ErrSessionNotFound is returned.
+func GetUserFromSession(token string) (User, Session, error) {
+	tokenHash := hashToken(token)
+	user, session, err := getRepository().GetSession(tokenHash)
+	if err == ErrSessionNotFound {
+		return User{}, Session{}, ErrSessionNotFound
+	} else if err != nil {
+		return User{}, Session{}, fmt.Errorf("yana.GetUserFromSession() -> Couldn't get session: %w", err)
+	}
+
+	now := time.Now().UTC()
+	idleTimeout := time.Duration(GetServerConfig().SessionIdleTimeoutMinutes) * time.Minute
+	if now.After(session.ExpiresAtUTC) || now.After(session.LastSeenAtUTC.Add(idleTimeout)) {
+		err = getRepository().DeleteSession(tokenHash)
+		if err != nil {
+			fmt.Println("yana.GetUserFromSession() -> Couldn't delete expired session:", err)
+		}
+		return User{}, Session{}, ErrSessionNotFound
+	}
+
+	err = getRepository().TouchSession(tokenHash, now)
+	if err != nil {
+		return User{}, Session{}, fmt.Errorf("yana.GetUserFromSession() -> Update query wasn't succesful: %w", err)
+	}
+	session.LastSeenAtUTC = now
+	return user, session, nil
+}
+
+// err == nil means the session doesn't exist anymore, even if it never existed
+func RevokeSession(token string) error {
+	err := getRepository().DeleteSession(hashToken(token))
+	if err != nil {
+		return fmt.Errorf("yana.RevokeSession() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+// Logs the user out everywhere
+func RevokeAllSessionsOfUser(userid string) error {
+	err := getRepository().DeleteSessionsOfUser(userid)
+	if err != nil {
+		return fmt.Errorf("yana.RevokeAllSessionsOfUser() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
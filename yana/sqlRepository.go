@@ -0,0 +1,388 @@
+package yana
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The queries of PostgreSQLRepository and SQLiteRepository. Both databases understand
+// $1 placeholders, TRUE/FALSE and INSERT ... SELECT, so only opening the database and Version() differ.
+type sqlRepository struct {
+	db *sql.DB
+	// The queries that run on nearly every request, see prepared()
+	statements     map[string]*sql.Stmt
+	statementMutex sync.Mutex
+}
+
+func newSQLRepository(db *sql.DB) *sqlRepository {
+	return &sqlRepository{db: db, statements: map[string]*sql.Stmt{}}
+}
+
+// Prepares query the first time it's used and reuses the statement afterwards.
+// If the database is down, nothing is kept and the next call tries again.
+func (repository *sqlRepository) prepared(query string) (*sql.Stmt, error) {
+	repository.statementMutex.Lock()
+	defer repository.statementMutex.Unlock()
+	statement, isExisting := repository.statements[query]
+	if isExisting {
+		return statement, nil
+	}
+	statement, err := repository.db.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("yana.sqlRepository.prepared() -> Couldn't prepare query: %w", err)
+	}
+	repository.statements[query] = statement
+	return statement, nil
+}
+
+func (repository *sqlRepository) InsertUser(user User, passwordHash string) error {
+	query := `INSERT INTO user_ (id, fullname, encryptedpassword, email, email_verified, created_at_utc)
+		SELECT $1, $2, $3, $4, $5, $6 WHERE NOT EXISTS (SELECT 1 FROM user_ WHERE email = $7)`
+	result, err := repository.db.Exec(query, user.UserId, user.FullName, passwordHash, user.Email, user.IsEmailVerified, user.CreatedAtUTC, user.Email)
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.InsertUser() -> Insert query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.InsertUser() -> Couldn't get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+func (repository *sqlRepository) DeleteUser(userid string) error {
+	return repository.exec("DeleteUser", `DELETE FROM user_ WHERE id = $1`, userid)
+}
+
+func (repository *sqlRepository) GetUser(userid string) (User, error) {
+	var user User
+	query := `SELECT id, email, fullname, email_verified, encryptedpassword != '', created_at_utc, role, is_disabled, password_reset_required
+		FROM user_ WHERE id = $1`
+	statement, err := repository.prepared(query)
+	if err != nil {
+		return User{}, fmt.Errorf("yana.sqlRepository.GetUser() -> %w", err)
+	}
+	err = statement.QueryRow(userid).Scan(&user.UserId, &user.Email, &user.FullName, &user.IsEmailVerified, &user.HasPassword, &user.CreatedAtUTC,
+		&user.Role, &user.IsDisabled, &user.IsPasswordResetRequired)
+	if err == sql.ErrNoRows {
+		return User{}, YanaError{Code: UserNotFound, Err: fmt.Errorf("yana.sqlRepository.GetUser() -> Couldn't find user")}
+	} else if err != nil {
+		return User{}, fmt.Errorf("yana.sqlRepository.GetUser() -> Select query wasn't succesful: %w", err)
+	}
+	return user, nil
+}
+
+func (repository *sqlRepository) GetUserIDByEmail(email string) (string, error) {
+	var userid string
+	err := repository.db.QueryRow(`SELECT id FROM user_ WHERE email = $1`, email).Scan(&userid)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("yana.sqlRepository.GetUserIDByEmail() -> Select query wasn't succesful: %w", err)
+	}
+	return userid, nil
+}
+
+func (repository *sqlRepository) GetPasswordHash(userid string) (string, error) {
+	var passwordHash string
+	err := repository.db.QueryRow(`SELECT encryptedpassword FROM user_ WHERE id = $1`, userid).Scan(&passwordHash)
+	if err == sql.ErrNoRows {
+		return "", YanaError{Code: UserNotFound, Err: fmt.Errorf("yana.sqlRepository.GetPasswordHash() -> Couldn't find user")}
+	} else if err != nil {
+		return "", fmt.Errorf("yana.sqlRepository.GetPasswordHash() -> Select query wasn't succesful: %w", err)
+	}
+	return passwordHash, nil
+}
+
+func (repository *sqlRepository) GetPasswordHashByEmail(email string) (string, string, error) {
+	var userid, passwordHash string
+	err := repository.db.QueryRow(`SELECT id, encryptedpassword FROM user_ WHERE email = $1`, email).Scan(&userid, &passwordHash)
+	if err == sql.ErrNoRows {
+		return "", "", YanaError{Code: UserNotFound, Err: fmt.Errorf("yana.sqlRepository.GetPasswordHashByEmail() -> Couldn't find user")}
+	} else if err != nil {
+		return "", "", fmt.Errorf("yana.sqlRepository.GetPasswordHashByEmail() -> Select query wasn't succesful: %w", err)
+	}
+	return userid, passwordHash, nil
+}
+
+// Runs a query that doesn't need anything back
+func (repository *sqlRepository) exec(functionName string, query string, args ...any) error {
+	_, err := repository.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.%s() -> Query wasn't succesful: %w", functionName, err)
+	}
+	return nil
+}
+
+func (repository *sqlRepository) SetPasswordHash(userid string, passwordHash string) error {
+	return repository.exec("SetPasswordHash", `UPDATE user_ SET encryptedpassword = $1 WHERE id = $2`, passwordHash, userid)
+}
+
+func (repository *sqlRepository) SetPasswordResetRequired(userid string, isRequired bool) error {
+	return repository.exec("SetPasswordResetRequired", `UPDATE user_ SET password_reset_required = $1 WHERE id = $2`, isRequired, userid)
+}
+
+func (repository *sqlRepository) SetFullName(userid string, fullname string) error {
+	return repository.exec("SetFullName", `UPDATE user_ SET fullname = $1 WHERE id = $2`, fullname, userid)
+}
+
+func (repository *sqlRepository) SetEmail(userid string, email string) error {
+	query := `UPDATE user_ SET email = $1, email_verified = FALSE, email_verification_tokenhash = NULL,
+		email_verification_expires_at_utc = NULL, email_verification_sent_at_utc = NULL
+		WHERE id = $2 AND NOT EXISTS (SELECT 1 FROM user_ WHERE email = $3 AND id != $4)`
+	result, err := repository.db.Exec(query, email, userid, email, userid)
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.SetEmail() -> Update query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.SetEmail() -> Couldn't get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+// Unfinished notes remember since when, see FinishUnfinishedNotes()
+func unfinishedSince(state string) sql.NullTime {
+	if state == NOTE_STATE_COMMITTED {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: time.Now().UTC(), Valid: true}
+}
+
+func (repository *sqlRepository) InsertNoteMetadata(note PostgreSQLNote) error {
+	// Parsed so that both databases store it as a time and not as text
+	createdAtUTC, err := time.Parse(time.RFC3339Nano, note.CreatedAtUTC)
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.InsertNoteMetadata() -> Couldn't parse creation time: %w", err)
+	}
+	query := `INSERT INTO note (id, bucketname, filename, created_at_utc, state, unfinished_since_utc) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = repository.db.Exec(query, note.Id, note.Bucketname, note.Filename, createdAtUTC, note.State, unfinishedSince(note.State))
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.InsertNoteMetadata() -> Insert query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+func (repository *sqlRepository) queryNoteMetadata(functionName string, condition string, args ...any) (PostgreSQLNote, error) {
+	var note PostgreSQLNote
+	var createdAtUTC time.Time
+	query := `SELECT id, bucketname, filename, created_at_utc, state FROM note WHERE state = 'committed' AND ` + condition
+	statement, err := repository.prepared(query)
+	if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.sqlRepository.%s() -> %w", functionName, err)
+	}
+	err = statement.QueryRow(args...).Scan(&note.Id, &note.Bucketname, &note.Filename, &createdAtUTC, &note.State)
+	if err == sql.ErrNoRows {
+		return PostgreSQLNote{}, ErrNoteMetadataNotFound
+	} else if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.sqlRepository.%s() -> Select query wasn't succesful: %w", functionName, err)
+	}
+	note.CreatedAtUTC = createdAtUTC.UTC().Format(time.RFC3339Nano)
+	return note, nil
+}
+
+func (repository *sqlRepository) GetNoteMetadata(noteId string) (PostgreSQLNote, error) {
+	return repository.queryNoteMetadata("GetNoteMetadata", `id = $1`, noteId)
+}
+
+func (repository *sqlRepository) GetNoteMetadataByName(bucketName string, filename string) (PostgreSQLNote, error) {
+	return repository.queryNoteMetadata("GetNoteMetadataByName", `bucketname = $1 AND filename = $2`, bucketName, filename)
+}
+
+func (repository *sqlRepository) SetNoteState(noteId string, fromState string, toState string) error {
+	query := `UPDATE note SET state = $1, unfinished_since_utc = $2 WHERE id = $3 AND state = $4`
+	result, err := repository.db.Exec(query, toState, unfinishedSince(toState), noteId, fromState)
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.SetNoteState() -> Update query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.SetNoteState() -> Couldn't get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNoteMetadataNotFound
+	}
+	return nil
+}
+
+func (repository *sqlRepository) SwapNoteName(noteId string, pendingNoteId string, leftoverNoteId string) (PostgreSQLNote, error) {
+	transaction, err := repository.db.Begin()
+	if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.sqlRepository.SwapNoteName() -> Couldn't begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	// The name is freed first, the unique (bucketname, filename) is checked after every statement
+	var bucketName string
+	var newFilename string
+	query := `DELETE FROM note WHERE id = $1 AND state = 'pending' RETURNING bucketname, filename`
+	err = transaction.QueryRow(query, pendingNoteId).Scan(&bucketName, &newFilename)
+	if err == sql.ErrNoRows {
+		return PostgreSQLNote{}, ErrNoteMetadataNotFound
+	} else if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.sqlRepository.SwapNoteName() -> Delete query wasn't succesful: %w", err)
+	}
+	var oldFilename string
+	query = `SELECT filename FROM note WHERE id = $1 AND bucketname = $2 AND state = 'committed'`
+	err = transaction.QueryRow(query, noteId, bucketName).Scan(&oldFilename)
+	if err == sql.ErrNoRows {
+		return PostgreSQLNote{}, ErrNoteMetadataNotFound
+	} else if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.sqlRepository.SwapNoteName() -> Select query wasn't succesful: %w", err)
+	}
+	query = `UPDATE note SET filename = $1 WHERE id = $2 AND filename = $3 AND state = 'committed'`
+	result, err := transaction.Exec(query, newFilename, noteId, oldFilename)
+	if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.sqlRepository.SwapNoteName() -> Update query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.sqlRepository.SwapNoteName() -> Couldn't get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return PostgreSQLNote{}, ErrNoteMetadataNotFound
+	}
+	now := time.Now().UTC()
+	leftoverNote := PostgreSQLNote{
+		Id:           leftoverNoteId,
+		Bucketname:   bucketName,
+		Filename:     oldFilename,
+		CreatedAtUTC: now.Format(time.RFC3339Nano),
+		State:        NOTE_STATE_DELETING,
+	}
+	query = `INSERT INTO note (id, bucketname, filename, created_at_utc, state, unfinished_since_utc) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = transaction.Exec(query, leftoverNote.Id, leftoverNote.Bucketname, leftoverNote.Filename, now, leftoverNote.State, now)
+	if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.sqlRepository.SwapNoteName() -> Insert query wasn't succesful: %w", err)
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return PostgreSQLNote{}, fmt.Errorf("yana.sqlRepository.SwapNoteName() -> Couldn't commit transaction: %w", err)
+	}
+	return leftoverNote, nil
+}
+
+func (repository *sqlRepository) GetUnfinishedNotes(pendingBeforeUTC time.Time) ([]PostgreSQLNote, error) {
+	query := `SELECT id, bucketname, filename, created_at_utc, state FROM note
+		WHERE state = 'deleting' OR (state = 'pending' AND unfinished_since_utc < $1)`
+	rows, err := repository.db.Query(query, pendingBeforeUTC)
+	if err != nil {
+		return nil, fmt.Errorf("yana.sqlRepository.GetUnfinishedNotes() -> Select query wasn't succesful: %w", err)
+	}
+	defer rows.Close()
+	var notes []PostgreSQLNote
+	for rows.Next() {
+		var note PostgreSQLNote
+		var createdAtUTC time.Time
+		err = rows.Scan(&note.Id, &note.Bucketname, &note.Filename, &createdAtUTC, &note.State)
+		if err != nil {
+			return nil, fmt.Errorf("yana.sqlRepository.GetUnfinishedNotes() -> Couldn't scan row: %w", err)
+		}
+		note.CreatedAtUTC = createdAtUTC.UTC().Format(time.RFC3339Nano)
+		notes = append(notes, note)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("yana.sqlRepository.GetUnfinishedNotes() -> Couldn't read rows: %w", err)
+	}
+	return notes, nil
+}
+
+func (repository *sqlRepository) DeleteNoteMetadata(noteId string) error {
+	return repository.exec("DeleteNoteMetadata", `DELETE FROM note WHERE id = $1`, noteId)
+}
+
+func (repository *sqlRepository) IsNoteNameTaken(bucketName string, filename string, exceptNoteId string) (bool, error) {
+	var isTaken bool
+	query := `SELECT EXISTS (SELECT 1 FROM note WHERE bucketname = $1 AND filename = $2)`
+	args := []any{bucketName, filename}
+	// PostgreSQL doesn't accept an empty string as a UUID
+	if exceptNoteId != "" {
+		query = `SELECT EXISTS (SELECT 1 FROM note WHERE bucketname = $1 AND filename = $2 AND id != $3)`
+		args = append(args, exceptNoteId)
+	}
+	statement, err := repository.prepared(query)
+	if err != nil {
+		return false, fmt.Errorf("yana.sqlRepository.IsNoteNameTaken() -> %w", err)
+	}
+	err = statement.QueryRow(args...).Scan(&isTaken)
+	if err != nil {
+		return false, fmt.Errorf("yana.sqlRepository.IsNoteNameTaken() -> Select query wasn't succesful: %w", err)
+	}
+	return isTaken, nil
+}
+
+func (repository *sqlRepository) InsertSession(tokenHash string, session Session) error {
+	// Every way of logging in ends up here, so disabled accounts are stopped here too
+	query := `INSERT INTO session (tokenhash, user_id, csrftoken, created_at_utc, last_seen_at_utc, expires_at_utc)
+		SELECT $1, $2, $3, $4, $5, $6 WHERE EXISTS (SELECT 1 FROM user_ WHERE id = $7 AND NOT is_disabled)`
+	result, err := repository.db.Exec(query, tokenHash, session.UserId, session.CSRFToken, session.CreatedAtUTC, session.LastSeenAtUTC, session.ExpiresAtUTC, session.UserId)
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.InsertSession() -> Insert query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.InsertSession() -> Couldn't get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return YanaError{Code: AccountDisabled, Err: fmt.Errorf("yana.sqlRepository.InsertSession() -> Account is disabled or doesn't exist")}
+	}
+	return nil
+}
+
+func (repository *sqlRepository) GetSession(tokenHash string) (User, Session, error) {
+	var user User
+	var session Session
+	query := `SELECT user_.id, user_.email, user_.fullname, user_.email_verified, user_.role, session.csrftoken, session.created_at_utc, session.last_seen_at_utc, session.expires_at_utc
+		FROM session JOIN user_ ON user_.id = session.user_id WHERE session.tokenhash = $1 AND NOT user_.is_disabled`
+	statement, err := repository.prepared(query)
+	if err != nil {
+		return User{}, Session{}, fmt.Errorf("yana.sqlRepository.GetSession() -> %w", err)
+	}
+	err = statement.QueryRow(tokenHash).Scan(&user.UserId, &user.Email, &user.FullName, &user.IsEmailVerified, &user.Role,
+		&session.CSRFToken, &session.CreatedAtUTC, &session.LastSeenAtUTC, &session.ExpiresAtUTC)
+	if err == sql.ErrNoRows {
+		return User{}, Session{}, ErrSessionNotFound
+	} else if err != nil {
+		return User{}, Session{}, fmt.Errorf("yana.sqlRepository.GetSession() -> Select query wasn't succesful: %w", err)
+	}
+	session.UserId = user.UserId
+	return user, session, nil
+}
+
+func (repository *sqlRepository) TouchSession(tokenHash string, lastSeenAtUTC time.Time) error {
+	statement, err := repository.prepared(`UPDATE session SET last_seen_at_utc = $1 WHERE tokenhash = $2`)
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.TouchSession() -> %w", err)
+	}
+	_, err = statement.Exec(lastSeenAtUTC, tokenHash)
+	if err != nil {
+		return fmt.Errorf("yana.sqlRepository.TouchSession() -> Update query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+func (repository *sqlRepository) DeleteSession(tokenHash string) error {
+	return repository.exec("DeleteSession", `DELETE FROM session WHERE tokenhash = $1`, tokenHash)
+}
+
+func (repository *sqlRepository) DeleteSessionsOfUser(userid string) error {
+	return repository.exec("DeleteSessionsOfUser", `DELETE FROM session WHERE user_id = $1`, userid)
+}
+
+// Used by both Version() implementations
+func (repository *sqlRepository) queryVersion(ctx context.Context, query string) (string, error) {
+	var version string
+	err := repository.db.QueryRowContext(ctx, query).Scan(&version)
+	if err != nil {
+		return "", fmt.Errorf("yana.sqlRepository.Version() -> Select query wasn't succesful: %w", err)
+	}
+	return version, nil
+}
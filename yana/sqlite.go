@@ -0,0 +1,40 @@
+package yana
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// Opens the file at path, the tables are created by the migrations (see migrations.go).
+// busy_timeout makes concurrent writers wait for each other instead of failing right away.
+// _txlock=immediate takes the write lock when a transaction begins instead of at its first write,
+// otherwise two transactions that both read first could end up waiting for each other.
+func openSQLite(path string) (*sql.DB, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("yana.openSQLite() -> Couldn't create directory of %q: %w", path, err)
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("yana.openSQLite() -> Couldn't open %q: %w", path, err)
+	}
+	return db, nil
+}
+
+// Everything is in the one file at sqlitepath, there is no server to set up
+type SQLiteRepository struct {
+	*sqlRepository
+}
+
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{newSQLRepository(db)}
+}
+
+func (repository *SQLiteRepository) Version(ctx context.Context) (string, error) {
+	return repository.queryVersion(ctx, `SELECT 'SQLite ' || sqlite_version()`)
+}
@@ -0,0 +1,37 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+/*
+ * The database pool and the content store are created once by the caller (see server.go)
+ * with OpenDatabase() and NewContentStore() and handed to Init().
+ * Everything in this package shares them instead of opening its own connections.
+ */
+
+var ErrNotInitialized = errors.New("yana.Init() hasn't been called")
+
+var database *sql.DB
+var databaseMutex sync.RWMutex
+
+// Has to be called once before anything else in this package is used
+func Init(db *sql.DB, store ContentStore) {
+	databaseMutex.Lock()
+	database = db
+	databaseMutex.Unlock()
+	SetRepository(NewRepository(db))
+	SetContentStore(store)
+}
+
+// Returns the pool from Init(). It's shared, so it must never be closed
+func getDatabase() (*sql.DB, error) {
+	databaseMutex.RLock()
+	defer databaseMutex.RUnlock()
+	if database == nil {
+		return nil, ErrNotInitialized
+	}
+	return database, nil
+}
@@ -0,0 +1,192 @@
+package yana
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+/*
+ * Failed logins are counted per account and per IP address in the login_throttle table
+ * so that the counters are shared by every instance and survive restarts.
+ * After a number of free failures, every further failure locks the key for
+ * twice as long as the one before, up to a maximum.
+ * Registrations, password reset mails and login link mails are counted the same way per IP address.
+ */
+
+// Failures are forgotten if there was no new one for this long
+const THROTTLE_RESET_AFTER = 24 * time.Hour
+
+const (
+	THROTTLE_EVENT_LOCKOUT = "lockout"
+	THROTTLE_EVENT_UNLOCK  = "unlock"
+)
+
+// Used as the Err of a YanaError with the code TooManyAttempts
+type LockedError struct {
+	Until time.Time
+}
+
+func (lockedErr LockedError) Error() string {
+	return fmt.Sprintf("too many attempts, locked until %s", lockedErr.Until.Format(time.RFC3339))
+}
+
+// Rounded up so that "0 minutes" is never shown
+func (lockedErr LockedError) MinutesLeft() int {
+	return int(math.Ceil(time.Until(lockedErr.Until).Minutes()))
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func ipThrottleKey(ipAddress string) string {
+	return "ip:" + ipAddress
+}
+
+func registrationThrottleKey(ipAddress string) string {
+	return "register-ip:" + ipAddress
+}
+
+func passwordResetThrottleKey(ipAddress string) string {
+	return "reset-ip:" + ipAddress
+}
+
+func magicLinkThrottleKey(ipAddress string) string {
+	return "magic-link-ip:" + ipAddress
+}
+
+func recordThrottleEvent(db *sql.DB, key, event string) {
+	query := `INSERT INTO login_throttle_event (key, event, at_utc) VALUES ($1, $2, $3)`
+	_, err := db.Exec(query, key, event, time.Now().UTC())
+	if err != nil {
+		fmt.Println("yana.recordThrottleEvent() -> Couldn't record event:", err)
+	}
+}
+
+// Returns a LockedError if key is locked right now.
+// A lock that has run out is removed and recorded as an unlock.
+func checkThrottle(db *sql.DB, key string) error {
+	var lockedUntil sql.NullTime
+	err := db.QueryRow(`SELECT locked_until_utc FROM login_throttle WHERE key = $1`, key).Scan(&lockedUntil)
+	if err == sql.ErrNoRows || (err == nil && !lockedUntil.Valid) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("yana.checkThrottle() -> Select query wasn't succesful: %w", err)
+	}
+	if time.Now().UTC().Before(lockedUntil.Time) {
+		return LockedError{Until: lockedUntil.Time}
+	}
+	// Only one instance gets to record the unlock
+	result, err := db.Exec(`UPDATE login_throttle SET locked_until_utc = NULL WHERE key = $1 AND locked_until_utc = $2`, key, lockedUntil.Time)
+	if err != nil {
+		return fmt.Errorf("yana.checkThrottle() -> Update query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err == nil && rowsAffected == 1 {
+		recordThrottleEvent(db, key, THROTTLE_EVENT_UNLOCK)
+	}
+	return nil
+}
+
+// Counts a failure for key and locks it if it had more than freeAttempts failures.
+func recordThrottleFailure(db *sql.DB, key string, freeAttempts int) error {
+	now := time.Now().UTC()
+	var failures int
+	query := `INSERT INTO login_throttle (key, failures, last_failure_at_utc) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttle.last_failure_at_utc < $3 THEN 1 ELSE login_throttle.failures + 1 END,
+			last_failure_at_utc = $2
+		RETURNING failures`
+	err := db.QueryRow(query, key, now, now.Add(-THROTTLE_RESET_AFTER)).Scan(&failures)
+	if err != nil {
+		return fmt.Errorf("yana.recordThrottleFailure() -> Upsert query wasn't succesful: %w", err)
+	}
+	if failures <= freeAttempts {
+		return nil
+	}
+	lockDuration := lockoutDuration(failures - freeAttempts)
+	_, err = db.Exec(`UPDATE login_throttle SET locked_until_utc = $1 WHERE key = $2`, now.Add(lockDuration), key)
+	if err != nil {
+		return fmt.Errorf("yana.recordThrottleFailure() -> Update query wasn't succesful: %w", err)
+	}
+	recordThrottleEvent(db, key, THROTTLE_EVENT_LOCKOUT)
+	return nil
+}
+
+// 1st lockout: LoginLockoutMinutes, 2nd: twice that, ... up to LoginMaxLockoutMinutes
+func lockoutDuration(lockoutNumber int) time.Duration {
+	config := GetServerConfig()
+	maxDuration := time.Duration(config.LoginMaxLockoutMinutes) * time.Minute
+	duration := time.Duration(config.LoginLockoutMinutes) * time.Minute
+	for i := 1; i < lockoutNumber && duration < maxDuration; i++ {
+		duration *= 2
+	}
+	return min(duration, maxDuration)
+}
+
+func resetThrottle(db *sql.DB, key string) error {
+	_, err := db.Exec(`DELETE FROM login_throttle WHERE key = $1`, key)
+	if err != nil {
+		return fmt.Errorf("yana.resetThrottle() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+// Returns a YanaError with the code TooManyAttempts if the account or the IP address are locked
+func checkLoginThrottle(db *sql.DB, email, ipAddress string) error {
+	for _, key := range []string{accountThrottleKey(email), ipThrottleKey(ipAddress)} {
+		err := checkThrottle(db, key)
+		if _, isLocked := err.(LockedError); isLocked {
+			return YanaError{Code: TooManyAttempts, Err: err}
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordLoginFailure(db *sql.DB, email, ipAddress string) {
+	config := GetServerConfig()
+	err := recordThrottleFailure(db, accountThrottleKey(email), config.LoginMaxAttempts)
+	if err != nil {
+		fmt.Println("yana.recordLoginFailure() -> Couldn't record failure for account:", err)
+	}
+	err = recordThrottleFailure(db, ipThrottleKey(ipAddress), config.LoginMaxAttemptsPerIP)
+	if err != nil {
+		fmt.Println("yana.recordLoginFailure() -> Couldn't record failure for IP:", err)
+	}
+}
+
+// The counter of the IP address isn't reset on purpose:
+// Otherwise logging into your own account would allow guessing other passwords forever
+func recordLoginSuccess(db *sql.DB, email string) {
+	err := resetThrottle(db, accountThrottleKey(email))
+	if err != nil {
+		fmt.Println("yana.recordLoginSuccess() -> Couldn't reset failures:", err)
+	}
+}
+
+// Returns a YanaError with the code TooManyAttempts if ipAddress registered too many accounts lately
+func CheckRegistrationThrottle(ipAddress string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return YanaError{Code: ConnectionFailed, Err: fmt.Errorf("yana.CheckRegistrationThrottle() -> Couldn't get database: %w", err)}
+	}
+	err = checkThrottle(db, registrationThrottleKey(ipAddress))
+	if _, isLocked := err.(LockedError); isLocked {
+		return YanaError{Code: TooManyAttempts, Err: err}
+	}
+	return err
+}
+
+// Every registration counts, whether it worked or not
+func RecordRegistrationAttempt(ipAddress string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.RecordRegistrationAttempt() -> Couldn't get database: %w", err)
+	}
+	return recordThrottleFailure(db, registrationThrottleKey(ipAddress), GetServerConfig().RegistrationMaxAttemptsPerIP)
+}
@@ -0,0 +1,392 @@
+package yana
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP as described in RFC 6238 with the defaults every authenticator app supports
+const (
+	TOTP_ISSUER     = "YANAgo"
+	TOTP_PERIOD     = 30 // in seconds
+	TOTP_DIGITS     = 6
+	TOTP_SKEW       = 1  // How many periods before and after now are accepted too
+	TOTP_SECRET_LEN = 20 // in bytes, as recommended by RFC 4226
+
+	RECOVERY_CODE_COUNT = 10
+
+	PENDING_LOGIN_LIFETIME     = 5 * time.Minute
+	PENDING_LOGIN_MAX_ATTEMPTS = 5
+)
+
+var ErrPendingLoginNotFound = errors.New("pending login not found, expired or too many wrong codes")
+var ErrNoTwoFactorEnrollment = errors.New("two-factor authentication hasn't been started")
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorEnrollment struct {
+	Secret string // base32, for typing it into the authenticator app by hand
+	URI    string // otpauth:// URI, usually shown as a QR code
+}
+
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, TOTP_SECRET_LEN)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("yana.generateTOTPSecret() -> Couldn't read random bytes: %w", err)
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func buildTOTPURI(email, secret string) string {
+	parameters := url.Values{}
+	parameters.Set("secret", secret)
+	parameters.Set("issuer", TOTP_ISSUER)
+	parameters.Set("algorithm", "SHA1")
+	parameters.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	parameters.Set("period", fmt.Sprint(TOTP_PERIOD))
+	label := url.PathEscape(TOTP_ISSUER + ":" + email)
+	return "otpauth://totp/" + label + "?" + parameters.Encode()
+}
+
+// HOTP from RFC 4226, section 5.3
+func generateTOTPCode(secret []byte, step int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	binaryCode := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, binaryCode%modulo)
+}
+
+// Returns the time step the code belongs to, or false if it doesn't match any step around now
+func findTOTPStep(secretBase32, code string, now time.Time) (int64, bool) {
+	secret, err := base32NoPadding.DecodeString(strings.ToUpper(secretBase32))
+	if err != nil || len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+	currentStep := now.Unix() / TOTP_PERIOD
+	for step := currentStep - TOTP_SKEW; step <= currentStep+TOTP_SKEW; step++ {
+		if subtle.ConstantTimeCompare([]byte(generateTOTPCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Looks like "a1b2c-d3e4f"
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, 7)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32NoPadding.EncodeToString(raw))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
+
+func IsTwoFactorEnabled(userid string) (bool, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return false, fmt.Errorf("yana.IsTwoFactorEnabled() -> Couldn't get database: %w", err)
+	}
+	var isEnabled bool
+	err = db.QueryRow(`SELECT totp_enabled FROM user_ WHERE id = $1`, userid).Scan(&isEnabled)
+	if err != nil {
+		return false, fmt.Errorf("yana.IsTwoFactorEnabled() -> Select query wasn't succesful: %w", err)
+	}
+	return isEnabled, nil
+}
+
+// Generates a new secret and saves it (encrypted) without enabling 2FA yet.
+// 2FA is only enabled once ConfirmTwoFactorEnrollment() gets a correct code.
+func BeginTwoFactorEnrollment(userid, email string) (TwoFactorEnrollment, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	encryptedSecret, err := encryptSecret(secret)
+	if err != nil {
+		return TwoFactorEnrollment{}, fmt.Errorf("yana.BeginTwoFactorEnrollment() -> Couldn't encrypt secret: %w", err)
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return TwoFactorEnrollment{}, fmt.Errorf("yana.BeginTwoFactorEnrollment() -> Couldn't get database: %w", err)
+	}
+	query := `UPDATE user_ SET totpsecret = $1, totp_last_used_step = 0 WHERE id = $2 AND totp_enabled = FALSE`
+	_, err = db.Exec(query, encryptedSecret, userid)
+	if err != nil {
+		return TwoFactorEnrollment{}, fmt.Errorf("yana.BeginTwoFactorEnrollment() -> Update query wasn't succesful: %w", err)
+	}
+	return TwoFactorEnrollment{Secret: secret, URI: buildTOTPURI(email, secret)}, nil
+}
+
+// Returns the enrollment started by BeginTwoFactorEnrollment(), e.g. to show the QR code again
+func GetTwoFactorEnrollment(userid, email string) (TwoFactorEnrollment, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return TwoFactorEnrollment{}, fmt.Errorf("yana.GetTwoFactorEnrollment() -> Couldn't get database: %w", err)
+	}
+	secret, isEnabled, _, err := getTOTPSecret(db, userid)
+	if err != nil {
+		return TwoFactorEnrollment{}, fmt.Errorf("yana.GetTwoFactorEnrollment() -> %w", err)
+	}
+	if isEnabled || secret == "" {
+		return TwoFactorEnrollment{}, ErrNoTwoFactorEnrollment
+	}
+	return TwoFactorEnrollment{Secret: secret, URI: buildTOTPURI(email, secret)}, nil
+}
+
+// Returns the decrypted secret, whether 2FA is enabled and the last used time step
+func getTOTPSecret(db *sql.DB, userid string) (string, bool, int64, error) {
+	var encryptedSecret sql.NullString
+	var isEnabled bool
+	var lastUsedStep int64
+	query := `SELECT totpsecret, totp_enabled, totp_last_used_step FROM user_ WHERE id = $1`
+	err := db.QueryRow(query, userid).Scan(&encryptedSecret, &isEnabled, &lastUsedStep)
+	if err != nil {
+		return "", false, 0, fmt.Errorf("Select query wasn't succesful: %w", err)
+	}
+	if !encryptedSecret.Valid || encryptedSecret.String == "" {
+		return "", isEnabled, lastUsedStep, nil
+	}
+	secret, err := decryptSecret(encryptedSecret.String)
+	if err != nil {
+		return "", false, 0, err
+	}
+	return secret, isEnabled, lastUsedStep, nil
+}
+
+// Marks step as used so that the same code can't be used twice.
+// Returns false if step (or a later one) was already used.
+func useTOTPStep(db *sql.DB, userid string, step int64) (bool, error) {
+	result, err := db.Exec(`UPDATE user_ SET totp_last_used_step = $1 WHERE id = $2 AND totp_last_used_step < $3`, step, userid, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected == 1, err
+}
+
+// Enables 2FA if code is correct.
+// Returns the recovery codes, which have to be shown to the user because they can't be shown again.
+func ConfirmTwoFactorEnrollment(userid, code string) ([]string, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, fmt.Errorf("yana.ConfirmTwoFactorEnrollment() -> Couldn't get database: %w", err)
+	}
+	secret, isEnabled, _, err := getTOTPSecret(db, userid)
+	if err != nil {
+		return nil, fmt.Errorf("yana.ConfirmTwoFactorEnrollment() -> %w", err)
+	}
+	if isEnabled || secret == "" {
+		return nil, ErrNoTwoFactorEnrollment
+	}
+	step, isOk := findTOTPStep(secret, strings.TrimSpace(code), time.Now())
+	if !isOk {
+		return nil, YanaError{Code: WrongTwoFactorCode, Err: fmt.Errorf("yana.ConfirmTwoFactorEnrollment() -> Code is wrong")}
+	}
+
+	transaction, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("yana.ConfirmTwoFactorEnrollment() -> Couldn't begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	_, err = transaction.Exec(`UPDATE user_ SET totp_enabled = TRUE, totp_last_used_step = $1 WHERE id = $2`, step, userid)
+	if err != nil {
+		return nil, fmt.Errorf("yana.ConfirmTwoFactorEnrollment() -> Update query wasn't succesful: %w", err)
+	}
+	recoveryCodes, err := replaceRecoveryCodes(transaction, userid)
+	if err != nil {
+		return nil, fmt.Errorf("yana.ConfirmTwoFactorEnrollment() -> %w", err)
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("yana.ConfirmTwoFactorEnrollment() -> Couldn't commit transaction: %w", err)
+	}
+	return recoveryCodes, nil
+}
+
+func replaceRecoveryCodes(transaction *sql.Tx, userid string) ([]string, error) {
+	_, err := transaction.Exec(`DELETE FROM recovery_code WHERE user_id = $1`, userid)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't delete old recovery codes: %w", err)
+	}
+	recoveryCodes := make([]string, 0, RECOVERY_CODE_COUNT)
+	for range RECOVERY_CODE_COUNT {
+		recoveryCode, err := generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't generate recovery code: %w", err)
+		}
+		_, err = transaction.Exec(`INSERT INTO recovery_code (user_id, codehash) VALUES ($1, $2)`, userid, hashToken(recoveryCode))
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't insert recovery code: %w", err)
+		}
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+	}
+	return recoveryCodes, nil
+}
+
+// Checks the second step of a login: code can be a TOTP code or one of the recovery codes.
+// Every TOTP code and every recovery code only works once.
+func VerifyTwoFactorCode(userid, code string) (bool, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return false, fmt.Errorf("yana.VerifyTwoFactorCode() -> Couldn't get database: %w", err)
+	}
+	secret, isEnabled, lastUsedStep, err := getTOTPSecret(db, userid)
+	if err != nil {
+		return false, fmt.Errorf("yana.VerifyTwoFactorCode() -> %w", err)
+	}
+	if !isEnabled {
+		return false, ErrNoTwoFactorEnrollment
+	}
+
+	code = strings.TrimSpace(code)
+	step, isOk := findTOTPStep(secret, code, time.Now())
+	if isOk && step > lastUsedStep {
+		isUnused, err := useTOTPStep(db, userid, step)
+		if err != nil {
+			return false, fmt.Errorf("yana.VerifyTwoFactorCode() -> Couldn't mark code as used: %w", err)
+		}
+		return isUnused, nil
+	}
+
+	query := `UPDATE recovery_code SET used_at_utc = $1 WHERE user_id = $2 AND codehash = $3 AND used_at_utc IS NULL`
+	result, err := db.Exec(query, time.Now().UTC(), userid, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, fmt.Errorf("yana.VerifyTwoFactorCode() -> Update query wasn't succesful: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("yana.VerifyTwoFactorCode() -> %w", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// Returns how many recovery codes haven't been used yet
+func CountUnusedRecoveryCodes(userid string) (int, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return 0, fmt.Errorf("yana.CountUnusedRecoveryCodes() -> Couldn't get database: %w", err)
+	}
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM recovery_code WHERE user_id = $1 AND used_at_utc IS NULL`, userid).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("yana.CountUnusedRecoveryCodes() -> Select query wasn't succesful: %w", err)
+	}
+	return count, nil
+}
+
+// The password has to be entered again so that a forgotten open session can't be used to disable 2FA
+func DisableTwoFactor(userid, password string) error {
+	isCorrect, err := IsPasswordCorrect(userid, password)
+	if err != nil {
+		return fmt.Errorf("yana.DisableTwoFactor() -> %w", err)
+	}
+	if !isCorrect {
+		return YanaError{Code: PasswordsNotEqual, Err: fmt.Errorf("yana.DisableTwoFactor() -> Password is wrong")}
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.DisableTwoFactor() -> Couldn't get database: %w", err)
+	}
+	transaction, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("yana.DisableTwoFactor() -> Couldn't begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+	_, err = transaction.Exec(`UPDATE user_ SET totpsecret = NULL, totp_enabled = FALSE, totp_last_used_step = 0 WHERE id = $1`, userid)
+	if err != nil {
+		return fmt.Errorf("yana.DisableTwoFactor() -> Update query wasn't succesful: %w", err)
+	}
+	_, err = transaction.Exec(`DELETE FROM recovery_code WHERE user_id = $1`, userid)
+	if err != nil {
+		return fmt.Errorf("yana.DisableTwoFactor() -> Delete query wasn't succesful: %w", err)
+	}
+	return transaction.Commit()
+}
+
+// ------------ PENDING LOGINS ------------
+// A pending login is a login where the password was correct but the 2FA code is still missing.
+
+// Returns string: the token that should be stored in the user's pending login cookie
+func CreatePendingLogin(userid string, isRemembered bool) (string, error) {
+	token, err := generateToken(SESSION_TOKEN_LEN)
+	if err != nil {
+		return "", err
+	}
+	db, err := getDatabase()
+	if err != nil {
+		return "", fmt.Errorf("yana.CreatePendingLogin() -> Couldn't get database: %w", err)
+	}
+	now := time.Now().UTC()
+	_, err = db.Exec(`DELETE FROM pending_login WHERE expires_at_utc < $1`, now)
+	if err != nil {
+		fmt.Println("yana.CreatePendingLogin() -> Couldn't delete expired pending logins:", err)
+	}
+	query := `INSERT INTO pending_login (tokenhash, user_id, is_remembered, attempts, expires_at_utc) VALUES ($1, $2, $3, 0, $4)`
+	_, err = db.Exec(query, hashToken(token), userid, isRemembered, now.Add(PENDING_LOGIN_LIFETIME))
+	if err != nil {
+		return "", fmt.Errorf("yana.CreatePendingLogin() -> Insert query wasn't succesful: %w", err)
+	}
+	return token, nil
+}
+
+// Counts an attempt and returns the userid and whether "Remember me" was checked.
+// After PENDING_LOGIN_MAX_ATTEMPTS attempts the pending login is gone and the password has to be entered again.
+func UsePendingLogin(token string) (string, bool, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return "", false, fmt.Errorf("yana.UsePendingLogin() -> Couldn't get database: %w", err)
+	}
+	var userid string
+	var isRemembered bool
+	query := `UPDATE pending_login SET attempts = attempts + 1
+		WHERE tokenhash = $1 AND expires_at_utc > $2 AND attempts < $3
+		RETURNING user_id, is_remembered`
+	err = db.QueryRow(query, hashToken(token), time.Now().UTC(), PENDING_LOGIN_MAX_ATTEMPTS).Scan(&userid, &isRemembered)
+	if err == sql.ErrNoRows {
+		return "", false, ErrPendingLoginNotFound
+	} else if err != nil {
+		return "", false, fmt.Errorf("yana.UsePendingLogin() -> Update query wasn't succesful: %w", err)
+	}
+	return userid, isRemembered, nil
+}
+
+func DeletePendingLogin(token string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return fmt.Errorf("yana.DeletePendingLogin() -> Couldn't get database: %w", err)
+	}
+	_, err = db.Exec(`DELETE FROM pending_login WHERE tokenhash = $1`, hashToken(token))
+	if err != nil {
+		return fmt.Errorf("yana.DeletePendingLogin() -> Delete query wasn't succesful: %w", err)
+	}
+	return nil
+}
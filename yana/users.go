@@ -0,0 +1,286 @@
+package yana
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// I am willingly ignoring Golang's styleguide for constants
+// https://google.github.io/styleguide/go/guide#mixedcaps
+const (
+	EMAIL_LOCAL_MAX_LEN  = 64
+	EMAIL_DOMAIN_MAX_LEN = 255
+	EMAIL_MAX_LEN        = 320
+)
+
+type User struct {
+	UserId                  string
+	Email                   string
+	FullName                string
+	IsEmailVerified         bool
+	HasPassword             bool         // Accounts from an external identity provider have none, see provisioning.go
+	CreatedAtUTC            sql.NullTime // Not valid for accounts that are older than the column
+	Role                    string       // ROLE_USER or ROLE_ADMIN, see admin.go
+	IsDisabled              bool         // Disabled accounts can't log in, see SetUserDisabled()
+	IsPasswordResetRequired bool         // Set by an admin, see ForcePasswordReset()
+}
+
+func (user User) IsAdmin() bool {
+	return user.Role == ROLE_ADMIN
+}
+
+// Checks the login with the authenticator from GetAuthenticator() (PostgreSQL by default, see authenticators.go).
+// ipAddress is the address the login came from, see throttle.go
+// Returns string: the userid if the login is ok
+func IsLoginOk(login string, password string, ipAddress string) (string, YanaError) {
+	db, err := getDatabase()
+	if err != nil {
+		return "", YanaError{Code: ConnectionFailed, Err: fmt.Errorf("yana.IsLoginOk() -> Couldn't get database: %w", err)}
+	}
+	err = checkLoginThrottle(db, login, ipAddress)
+	var yanaErr YanaError
+	if errors.As(err, &yanaErr) {
+		return "", yanaErr
+	} else if err != nil {
+		return "", YanaError{Code: QueryFailed, Err: fmt.Errorf("yana.IsLoginOk() -> Couldn't check for too many attempts: %w", err)}
+	}
+	userid, err := GetAuthenticator().Authenticate(login, password)
+	if err != nil {
+		code := GetYanaErrorCode(err)
+		if code == UserNotFound || code == PasswordsNotEqual {
+			recordLoginFailure(db, login, ipAddress)
+		}
+		if code == NoError {
+			code = AuthenticatorFailed
+		}
+		return "", YanaError{Code: code, Err: fmt.Errorf("yana.IsLoginOk() -> Login wasn't succesful: %w", err)}
+	}
+	recordLoginSuccess(db, login)
+
+	// Only checked after the password so that nobody learns about the state of an account without it
+	user, err := getRepository().GetUser(userid)
+	if err != nil {
+		return "", YanaError{Code: QueryFailed, Err: fmt.Errorf("yana.IsLoginOk() -> Couldn't get user: %w", err)}
+	}
+	if user.IsDisabled {
+		return "", YanaError{Code: AccountDisabled, Err: fmt.Errorf("yana.IsLoginOk() -> Account is disabled")}
+	}
+	if user.IsPasswordResetRequired {
+		return "", YanaError{Code: PasswordResetRequired, Err: fmt.Errorf("yana.IsLoginOk() -> Password has to be reset first")}
+	}
+	return userid, YanaError{}
+}
+
+// For actions that need the password again, even though the user is logged in
+func IsPasswordCorrect(userid string, password string) (bool, error) {
+	storedHash, err := getRepository().GetPasswordHash(userid)
+	if err != nil {
+		return false, fmt.Errorf("yana.IsPasswordCorrect() -> Couldn't get password hash: %w", err)
+	}
+	// Accounts from an external identity provider have no password (see provisioning.go), so no password is correct for them.
+	// Otherwise an empty password would confirm e.g. disabling 2FA with nothing but an open session
+	if storedHash == "" {
+		return false, nil
+	}
+	isMatching, _, err := verifyPassword(password, storedHash)
+	if err != nil {
+		return false, fmt.Errorf("yana.IsPasswordCorrect() -> Couldn't verify password: %w", err)
+	}
+	return isMatching, nil
+}
+
+// Replaces the stored hash of the user with a new hash of password
+func updatePasswordHash(userid string, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("yana.updatePasswordHash() -> Couldn't hash password: %w", err)
+	}
+	err = getRepository().SetPasswordHash(userid, hash)
+	if err != nil {
+		return fmt.Errorf("yana.updatePasswordHash() -> Couldn't execute update query: %w", err)
+	}
+	return nil
+}
+
+func GetUserIDFromEmail(email string) (string, error) {
+	userid, err := getRepository().GetUserIDByEmail(email)
+	if err != nil {
+		return "", fmt.Errorf("yana.GetUserIDFromEmail() -> Couldn't get user: %w", err)
+	}
+	return userid, nil
+}
+
+func GetUserFromUserID(userid string) (User, error) {
+	user, err := getRepository().GetUser(userid)
+	if err != nil {
+		// Wrapped so that GetYanaErrorCode() still finds UserNotFound
+		return User{}, fmt.Errorf("yana.GetUserFromUserID() -> Couldn't get user: %w", err)
+	}
+	return user, nil
+}
+
+const FULLNAME_MAX_LEN = 255
+
+func UpdateFullName(userid string, fullname string) error {
+	fullname = strings.TrimSpace(fullname)
+	if len(fullname) > FULLNAME_MAX_LEN {
+		return fmt.Errorf("yana.UpdateFullName() -> The name can't be longer than %d characters", FULLNAME_MAX_LEN)
+	}
+	err := getRepository().SetFullName(userid, fullname)
+	if err != nil {
+		return fmt.Errorf("yana.UpdateFullName() -> Update query wasn't succesful: %w", err)
+	}
+	return nil
+}
+
+// Returned by UpdateEmail() if another account already uses the new email
+var ErrEmailTaken = errors.New("there already is an account with this email")
+
+// Changes the email after checking the password and sends a verification link to the new address.
+// Until it's clicked, the account counts as unverified again.
+func UpdateEmail(userid string, password string, newEmail string) error {
+	address, err := mail.ParseAddress(newEmail)
+	if err != nil || address.Address != newEmail {
+		return fmt.Errorf("yana.UpdateEmail() -> %q is not a valid email address", newEmail)
+	}
+	isCorrect, err := IsPasswordCorrect(userid, password)
+	if err != nil {
+		return fmt.Errorf("yana.UpdateEmail() -> Couldn't check password: %w", err)
+	}
+	if !isCorrect {
+		return YanaError{Code: PasswordsNotEqual, Err: fmt.Errorf("yana.UpdateEmail() -> Passwords are not equal")}
+	}
+	err = getRepository().SetEmail(userid, newEmail)
+	if err == ErrEmailTaken {
+		return ErrEmailTaken
+	} else if err != nil {
+		return fmt.Errorf("yana.UpdateEmail() -> Couldn't update email: %w", err)
+	}
+	err = SendEmailVerification(userid)
+	if err != nil {
+		return fmt.Errorf("yana.UpdateEmail() -> Email was changed but the verification mail couldn't be sent: %w", err)
+	}
+	return nil
+}
+
+// Returns a YanaError with the code PasswordsNotEqual if currentPassword is wrong
+func ChangePassword(userid string, currentPassword string, newPassword string) error {
+	if newPassword == "" {
+		return fmt.Errorf("yana.ChangePassword() -> The new password is empty")
+	}
+	isCorrect, err := IsPasswordCorrect(userid, currentPassword)
+	if err != nil {
+		return fmt.Errorf("yana.ChangePassword() -> Couldn't check password: %w", err)
+	}
+	if !isCorrect {
+		return YanaError{Code: PasswordsNotEqual, Err: fmt.Errorf("yana.ChangePassword() -> Passwords are not equal")}
+	}
+	err = updatePasswordHash(userid, newPassword)
+	if err != nil {
+		return fmt.Errorf("yana.ChangePassword() -> Couldn't update password: %w", err)
+	}
+	// A reset that an admin forced is done with a new password too
+	err = getRepository().SetPasswordResetRequired(userid, false)
+	if err != nil {
+		return fmt.Errorf("yana.ChangePassword() -> Couldn't clear forced password reset: %w", err)
+	}
+	return nil
+}
+
+func generateUserID() string {
+	return uuid.New().String()
+}
+
+func isUserInDatabase(email string) (bool, error) {
+	userid, err := getRepository().GetUserIDByEmail(email)
+	if err != nil {
+		return false, fmt.Errorf("yana.isUserInDatabase() -> Couldn't get user: %w", err)
+	}
+	return userid != "", nil
+}
+
+// This has been here since before I even had a frontend and never needed it
+// because the email field in /register is required
+// func NewUserNoUsername(email string, password string) (string, error) {
+// 	address, errIsEmailValid := mail.ParseAddress(email)
+// 	if errIsEmailValid != nil {
+// 		return "", errIsEmailValid
+// 	}
+// 	return CreateNewUser(email, address.Name, password)
+// }
+
+// Returns string: uuid of newly created user
+// Checks the email and name of a new account, the same way for /register and external identity providers.
+// Returns the name without surrounding spaces
+func validateNewUser(email string, fullname string) (string, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", err
+	}
+	// ParseAddress() also accepts "Name <address>", which would end up in user_.email otherwise
+	if address.Address != email {
+		return "", fmt.Errorf("yana.validateNewUser() -> %q is not just an email address", email)
+	}
+	atIndex := strings.LastIndex(email, "@")
+	if len(email) > EMAIL_MAX_LEN || atIndex > EMAIL_LOCAL_MAX_LEN || len(email)-atIndex-1 > EMAIL_DOMAIN_MAX_LEN {
+		return "", fmt.Errorf("yana.validateNewUser() -> The email address is too long")
+	}
+	fullname = strings.TrimSpace(fullname)
+	if len(fullname) > FULLNAME_MAX_LEN {
+		return "", fmt.Errorf("yana.validateNewUser() -> The name can't be longer than %d characters", FULLNAME_MAX_LEN)
+	}
+	return fullname, nil
+}
+
+func CreateNewUser(email string, fullname string, password string) (string, error) {
+	fullname, err := validateNewUser(email, fullname)
+	if err != nil {
+		return "", err
+	}
+	isUserInDB, err := isUserInDatabase(email)
+	if err != nil {
+		return "", err
+	}
+	if isUserInDB {
+		return "", nil
+	}
+
+	userid := generateUserID()
+
+	encryptedPassword, err := hashPassword(password)
+	if err != nil {
+		return "", fmt.Errorf("yana.CreateNewUser() -> Couldn't hash password: %w", err)
+	}
+
+	// The email has to be verified with the link from SendEmailVerification()
+	user := User{
+		UserId:       userid,
+		Email:        email,
+		FullName:     fullname,
+		CreatedAtUTC: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	}
+	err = getRepository().InsertUser(user, encryptedPassword)
+	if err == ErrEmailTaken {
+		// Registered at the same time with the same email
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("yana.CreateNewUser() -> Insert query wasn't succesful: %w", err)
+	}
+	return userid, nil
+}
+
+// Removes an account that CreateNewUser() has just created, e.g. because its bucket couldn't be created,
+// so that the email can be used again. Accounts that were used already are removed with DeleteAccount()
+func RemoveNewUser(userid string) error {
+	err := getRepository().DeleteUser(userid)
+	if err != nil {
+		return fmt.Errorf("yana.RemoveNewUser() -> Couldn't delete user: %w", err)
+	}
+	return nil
+}
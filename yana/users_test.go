@@ -0,0 +1,93 @@
+package yana
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func insertTestUserWithHash(t *testing.T, email string, passwordHash string) string {
+	t.Helper()
+	user := User{
+		UserId:          generateUserID(),
+		Email:           email,
+		FullName:        "Test User",
+		IsEmailVerified: true,
+		CreatedAtUTC:    sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	}
+	err := getRepository().InsertUser(user, passwordHash)
+	if err != nil {
+		t.Fatalf("InsertUser() = %v", err)
+	}
+	return user.UserId
+}
+
+func TestIsPasswordCorrect(t *testing.T) {
+	newTestSQLite(t)
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatalf("hashPassword() = %v", err)
+	}
+	hashedUserid := insertTestUserWithHash(t, "hashed@example.com", hash)
+	legacyUserid := insertTestUserWithHash(t, "legacy@example.com", "secret")
+	externalUserid := insertTestUserWithHash(t, "external@example.com", "")
+
+	tests := []struct {
+		name     string
+		userid   string
+		password string
+		want     bool
+	}{
+		{"hashed password", hashedUserid, "secret", true},
+		{"wrong password", hashedUserid, "wrong", false},
+		{"empty password", hashedUserid, "", false},
+		{"legacy password", legacyUserid, "secret", true},
+		{"wrong legacy password", legacyUserid, "", false},
+		{"external account with empty password", externalUserid, "", false},
+		{"external account with any password", externalUserid, "secret", false},
+	}
+	for _, test := range tests {
+		isCorrect, err := IsPasswordCorrect(test.userid, test.password)
+		if err != nil || isCorrect != test.want {
+			t.Errorf("IsPasswordCorrect() with %s = %v, %v, want %v", test.name, isCorrect, err, test.want)
+		}
+	}
+}
+
+// An open session of an account without a password must not be enough to change what needs the password
+func TestExternalAccountCantConfirmWithEmptyPassword(t *testing.T) {
+	db := newTestSQLite(t)
+	useTestFileMailer(t)
+	userid := insertTestUserWithHash(t, "external@example.com", "")
+	_, err := db.Exec(`UPDATE user_ SET totpsecret = 'secret', totp_enabled = TRUE WHERE id = $1`, userid)
+	if err != nil {
+		t.Fatalf("Update query = %v", err)
+	}
+
+	isPasswordsNotEqual := func(err error) bool {
+		yanaErr, isYanaErr := err.(YanaError)
+		return isYanaErr && yanaErr.Code == PasswordsNotEqual
+	}
+	err = ChangePassword(userid, "", "new password")
+	if !isPasswordsNotEqual(err) {
+		t.Errorf("ChangePassword() with empty password = %v, want PasswordsNotEqual", err)
+	}
+	err = UpdateEmail(userid, "", "attacker@example.com")
+	if !isPasswordsNotEqual(err) {
+		t.Errorf("UpdateEmail() with empty password = %v, want PasswordsNotEqual", err)
+	}
+	err = DisableTwoFactor(userid, "")
+	if !isPasswordsNotEqual(err) {
+		t.Errorf("DisableTwoFactor() with empty password = %v, want PasswordsNotEqual", err)
+	}
+
+	user, err := GetUserFromUserID(userid)
+	if err != nil || user.Email != "external@example.com" || user.HasPassword {
+		t.Errorf("GetUserFromUserID() = %+v, %v, want the account unchanged", user, err)
+	}
+	var isTotpEnabled bool
+	err = db.QueryRow(`SELECT totp_enabled FROM user_ WHERE id = $1`, userid).Scan(&isTotpEnabled)
+	if err != nil || !isTotpEnabled {
+		t.Errorf("totp_enabled = %v, %v, want still enabled", isTotpEnabled, err)
+	}
+}
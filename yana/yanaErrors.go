@@ -1,5 +1,7 @@
 package yana
 
+import "fmt"
+
 type YanaError struct {
 	Code int
 	Err  error // TODO: Maybe remove and just add a GetErrorStringFromYanaErrorCode() or something??
@@ -16,4 +18,24 @@ const (
 	PasswordsNotEqual
 	BadClient
 	NoteAlreadyExists // Not used yet
+	InvalidPasswordHash
+	NoteNotFound
+	NoteForbidden   // The note exists but belongs to a different user
+	TooManyAttempts // Err is a LockedError
+	WrongTwoFactorCode
+	AuthenticatorFailed   // The authenticator itself didn't work, e.g. because the LDAP server is down
+	AccountDisabled       // An admin has disabled the account
+	PasswordResetRequired // An admin wants the user to choose a new password before logging in with it again
 )
+
+// So that a YanaError can be returned as a normal error and be found again with errors.As()
+func (yanaErr YanaError) Error() string {
+	if yanaErr.Err == nil {
+		return fmt.Sprintf("YanaError with code %d", yanaErr.Code)
+	}
+	return yanaErr.Err.Error()
+}
+
+func (yanaErr YanaError) Unwrap() error {
+	return yanaErr.Err
+}